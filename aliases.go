@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// AliasResolver expands shell aliases in recorded commands. Aliases are read
+// from rc files, the oh-my-zsh plugins they enable and the files they source,
+// or from the saved output of the alias builtin. Nothing is executed.
+type AliasResolver struct {
+	aliases map[string]string
+}
+
+// maxSourceDepth limits how deep "source" lines in rc files are followed
+const maxSourceDepth = 3
+
+var (
+	omzPluginsRegex = regexp.MustCompile(`(?m)^\s*plugins=\(([^)]*)\)`)
+	omzDirRegex     = regexp.MustCompile(`(?m)^\s*(?:export\s+)?(ZSH|ZSH_CUSTOM)=["']?([^"'\s]+)`)
+)
+
+// NewAliasResolver reads the aliases defined in rcFiles, which may be glob
+// patterns, and in dumpFile, the output of running "alias". Files that don't
+// exist are skipped. Later definitions win, so the dump overrides rc files.
+func NewAliasResolver(rcFiles []string, dumpFile, homeDir string) *AliasResolver {
+	r := &AliasResolver{aliases: make(map[string]string)}
+
+	loader := aliasLoader{resolver: r, homeDir: homeDir, visited: make(map[string]bool)}
+	for _, pattern := range rcFiles {
+		matches, _ := filepath.Glob(expandHome(pattern, homeDir))
+		for _, path := range matches {
+			loader.loadRCFile(path, 0)
+		}
+	}
+
+	if dumpFile != "" {
+		if data, err := os.ReadFile(expandHome(dumpFile, homeDir)); err == nil {
+			r.parseDump(data)
+		}
+	}
+
+	return r
+}
+
+type aliasLoader struct {
+	resolver *AliasResolver
+	homeDir  string
+	visited  map[string]bool
+}
+
+func (l *aliasLoader) loadRCFile(path string, depth int) {
+	if l.visited[path] || depth > maxSourceDepth {
+		return
+	}
+	l.visited[path] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	// oh-my-zsh loads its plugins, and with them their aliases, before the
+	// rest of the rc file runs
+	for _, pluginFile := range l.omzPluginFiles(data) {
+		l.loadRCFile(pluginFile, depth+1)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		for _, cmd := range parseSimpleCommands(scanner.Text()) {
+			args := wordTexts(cmd.words[1:])
+			switch cmd.words[0].text {
+			case "alias":
+				l.resolver.define(args)
+			case "source", ".":
+				if len(args) > 0 {
+					if sourced, ok := l.literalPath(args[0], filepath.Dir(path)); ok {
+						l.loadRCFile(sourced, depth+1)
+					}
+				}
+			}
+		}
+	}
+}
+
+// omzPluginFiles returns the plugin files of the oh-my-zsh plugins an rc file
+// enables, preferring custom plugins like oh-my-zsh does
+func (l *aliasLoader) omzPluginFiles(data []byte) []string {
+	match := omzPluginsRegex.FindSubmatch(data)
+	if match == nil {
+		return nil
+	}
+
+	zsh := filepath.Join(l.homeDir, ".oh-my-zsh")
+	custom := ""
+	for _, dirMatch := range omzDirRegex.FindAllSubmatch(data, -1) {
+		dir, ok := l.literalPath(string(dirMatch[2]), l.homeDir)
+		if !ok {
+			continue
+		}
+		if string(dirMatch[1]) == "ZSH" {
+			zsh = dir
+		} else {
+			custom = dir
+		}
+	}
+	if custom == "" {
+		custom = filepath.Join(zsh, "custom")
+	}
+
+	var files []string
+	for _, plugin := range strings.Fields(string(match[1])) {
+		for _, dir := range []string{custom, zsh} {
+			file := filepath.Join(dir, "plugins", plugin, plugin+".plugin.zsh")
+			if _, err := os.Stat(file); err == nil {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	return files
+}
+
+// literalPath expands ~ and $HOME in a path from an rc file. Paths using
+// other variables can't be known without running the shell.
+func (l *aliasLoader) literalPath(path, dir string) (string, bool) {
+	path = strings.ReplaceAll(path, "${HOME}", l.homeDir)
+	path = strings.ReplaceAll(path, "$HOME", l.homeDir)
+	path = expandHome(path, l.homeDir)
+	if strings.ContainsAny(path, "$`*?") {
+		return "", false
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path, true
+}
+
+// parseDump reads the output of "alias": zsh prints name=value lines, bash
+// prints the alias commands that would recreate them
+func (r *AliasResolver) parseDump(data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		words := wordTexts(tokenizeShell(scanner.Text()))
+		if len(words) > 0 && words[0] == "alias" {
+			words = words[1:]
+		}
+		r.define(words)
+	}
+}
+
+// define records the arguments of an alias command. Global (-g) and suffix
+// (-s) aliases don't replace the command word and are skipped.
+func (r *AliasResolver) define(args []string) {
+	for _, arg := range args {
+		if arg == "-g" || arg == "-s" {
+			return
+		}
+		name, value, ok := strings.Cut(arg, "=")
+		if ok && name != "" && !strings.HasPrefix(name, "-") {
+			r.aliases[name] = value
+		}
+	}
+}
+
+// Len returns the number of known aliases
+func (r *AliasResolver) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.aliases)
+}
+
+// Expand replaces aliases used as the command word of any segment of a
+// command line. Like the shell, it expands the result again unless that
+// would recurse, and leaves quoted or escaped words alone.
+func (r *AliasResolver) Expand(command string) string {
+	if r.Len() == 0 {
+		return command
+	}
+
+	commands := parseSimpleCommands(command)
+	expanded := command
+	// Replace from the end so earlier offsets stay valid
+	for i := len(commands) - 1; i >= 0; i-- {
+		word := commands[i].words[0]
+		if command[word.start:word.end] != word.text {
+			continue
+		}
+		if value, ok := r.expandWord(word.text); ok {
+			expanded = expanded[:word.start] + value + expanded[word.end:]
+		}
+	}
+	return expanded
+}
+
+func (r *AliasResolver) expandWord(word string) (string, bool) {
+	value, ok := r.aliases[word]
+	if !ok {
+		return "", false
+	}
+
+	seen := map[string]bool{word: true}
+	for {
+		first := strings.Fields(value)
+		if len(first) == 0 || seen[first[0]] {
+			return value, true
+		}
+		next, ok := r.aliases[first[0]]
+		if !ok {
+			return value, true
+		}
+		seen[first[0]] = true
+		value = next + strings.TrimPrefix(strings.TrimLeft(value, " \t"), first[0])
+	}
+}
+
+func wordTexts(tokens []shellToken) []string {
+	var texts []string
+	for _, token := range tokens {
+		if token.kind == shellWord {
+			texts = append(texts, token.text)
+		}
+	}
+	return texts
+}
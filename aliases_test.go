@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAliasResolver(t *testing.T) {
+	home := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	write(".zshrc", `export ZSH="$HOME/.oh-my-zsh"
+plugins=(
+  git
+  kubectl
+)
+source $ZSH/oh-my-zsh.sh
+source ~/.zsh/aliases.zsh
+alias ll='ls -la' gs="git status"
+alias -g G='| grep'
+if [[ -n $TMUX ]]; then alias t='tmux attach'; fi
+FOO=bar
+`)
+	write(".oh-my-zsh/plugins/git/git.plugin.zsh", "alias gst='git status'\nalias gp='git push'\n")
+	write(".oh-my-zsh/custom/plugins/kubectl/kubectl.plugin.zsh", "alias k=kubectl\nalias kgp='k get pods'\n")
+	write(".zsh/aliases.zsh", "alias dcu='docker compose up'\nalias ls='ls -G'\n")
+	write("aliases.txt", "gp='git pull --rebase'\nalias v='nvim'\n")
+
+	r := NewAliasResolver([]string{"~/.zshrc"}, "~/aliases.txt", home)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"gst", "git status"},
+		{"ll /tmp", "ls -G -la /tmp"},
+		{"kgp -A", "kubectl get pods -A"},
+		{"dcu -d", "docker compose up -d"},
+		{"cd api && gp", "cd api && git pull --rebase"},
+		{"sudo v /etc/hosts", "sudo nvim /etc/hosts"},
+		{"t", "tmux attach"},
+		{"ls | G foo", "ls -G | G foo"},
+		{`\ls`, `\ls`},
+		{"echo gst", "echo gst"},
+		{"FOO", "FOO"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := r.Expand(tt.command); got != tt.want {
+				t.Errorf("Expand(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildEntry_Aliases(t *testing.T) {
+	r := &AliasResolver{aliases: map[string]string{"k": "kubectl", "gst": "git status"}}
+	p := &Parser{config: &Config{HomeDir: "/home/test"}, aliases: r}
+
+	dir := dirState{current: "/home/test"}
+	entry := p.newEntry(1, time.Unix(1700000000, 0), 0, "k get pods", &dir)
+	if entry.Command != "k get pods" || entry.ExpandedCommand != "kubectl get pods" {
+		t.Errorf("Expected original and expanded command, got %q and %q", entry.Command, entry.ExpandedCommand)
+	}
+	if entry.BaseCommand != "kubectl" || entry.Category != CategoryContainers {
+		t.Errorf("Expected kubectl in containers, got %q in %v", entry.BaseCommand, entry.Category)
+	}
+
+	entry = p.newEntry(2, time.Unix(1700000001, 0), 0, "ls -la", &dir)
+	if entry.ExpandedCommand != "" {
+		t.Errorf("Expected no expanded command without aliases, got %q", entry.ExpandedCommand)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteMagic starts every SQLite database file, including Atuin's history.db
+const sqliteMagic = "SQLite format 3\x00"
+
+// ParseAtuinHistory reads the commands recorded in an Atuin history.db. The
+// database is opened read-only, so a live database or a copy of one can be
+// used. Atuin records the real working directory, exit status, duration and
+// shell session of each command, so no directory tracking is needed.
+func (p *Parser) ParseAtuinHistory(path string) ([]HistoryEntry, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT timestamp, duration, exit, command, cwd, session, hostname
+		FROM history
+		WHERE deleted_at IS NULL
+		ORDER BY timestamp, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read atuin history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var timestamp, duration, exit int64
+		var command, cwd, session, hostname string
+		if err := rows.Scan(&timestamp, &duration, &exit, &command, &cwd, &session, &hostname); err != nil {
+			return nil, fmt.Errorf("failed to read atuin history: %w", err)
+		}
+
+		// Atuin stores -1 while a command is still running
+		seconds := 0
+		if duration > 0 {
+			seconds = int(time.Duration(duration) / time.Second)
+		}
+
+		entry := buildEntry(len(entries)+1, time.Unix(0, timestamp), seconds, command, p.aliases.Expand(command), cwd)
+		if exit >= 0 {
+			code := int(exit)
+			entry.ExitCode = &code
+		}
+		entry.SessionHint = session
+		entry.Host = atuinHost(hostname)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read atuin history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// atuinHost strips the user from Atuin's "host:user" hostname column
+func atuinHost(hostname string) string {
+	host, _, _ := strings.Cut(hostname, ":")
+	return host
+}
+
+// atuinModTime returns when the database last changed. Atuin keeps the
+// database in WAL mode, so recent writes only touch the -wal file.
+func atuinModTime(path string, info os.FileInfo) time.Time {
+	modTime := info.ModTime()
+	if wal, err := os.Stat(path + "-wal"); err == nil && wal.ModTime().After(modTime) {
+		modTime = wal.ModTime()
+	}
+	return modTime
+}
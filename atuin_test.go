@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAtuinHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "history.db")
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE history (
+			id text PRIMARY KEY,
+			timestamp integer NOT NULL,
+			duration integer NOT NULL,
+			exit integer NOT NULL,
+			command text NOT NULL,
+			cwd text NOT NULL,
+			session text NOT NULL,
+			hostname text NOT NULL,
+			deleted_at integer
+		);
+		INSERT INTO history VALUES
+			('b', 1700000010000000000, 2500000000, 1, 'make test', '/src/api', 's1', 'devbox:alice', NULL),
+			('a', 1700000000000000000, 40000000, 0, 'git status', '/src/api', 's1', 'devbox:alice', NULL),
+			('c', 1700000020000000000, 1000000, 0, 'rm secrets', '/src/api', 's1', 'devbox:alice', 1700000030000000000),
+			('d', 1700000040000000000, -1, -1, 'vim', '/tmp', 's2', 'laptop:alice', NULL);`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to populate database: %v", err)
+	}
+
+	p := &Parser{config: &Config{HistoryFile: dbPath, HomeDir: tmpDir}}
+	entries, err := p.ParseHistory()
+	if err != nil {
+		t.Fatalf("ParseHistory() error = %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries without the deleted one, got %d", len(entries))
+	}
+	if entries[0].Command != "git status" || entries[1].Command != "make test" {
+		t.Errorf("Entries not in timestamp order: %q, %q", entries[0].Command, entries[1].Command)
+	}
+	if entries[1].Directory != "/src/api" || entries[1].Duration != 2 || entries[1].Host != "devbox" {
+		t.Errorf("Unexpected entry: %+v", entries[1])
+	}
+	if entries[1].ExitCode == nil || *entries[1].ExitCode != 1 {
+		t.Errorf("Expected exit code 1, got %v", entries[1].ExitCode)
+	}
+	if entries[1].SessionHint != "s1" {
+		t.Errorf("Expected session hint s1, got %q", entries[1].SessionHint)
+	}
+	if entries[2].ExitCode != nil || entries[2].Duration != 0 {
+		t.Errorf("Expected unknown exit code and duration, got %+v", entries[2])
+	}
+
+	// The importer must not write to the database
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to stat database: %v", err)
+	}
+	if err := os.Chmod(dbPath, 0444); err != nil {
+		t.Fatalf("Failed to make database read-only: %v", err)
+	}
+	if _, err := p.ParseAtuinHistory(dbPath); err != nil {
+		t.Errorf("Failed to read a read-only database: %v", err)
+	}
+	if after, _ := os.Stat(dbPath); !after.ModTime().Equal(info.ModTime()) {
+		t.Errorf("Database was modified")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bashRecord is a raw command read from a bash history file before
+// timestamps are filled in
+type bashRecord struct {
+	offset    int64
+	timestamp int64 // 0 when the file carries no timestamp for this command
+	command   string
+}
+
+// parseBashHistory reads a bash history file. When HISTTIMEFORMAT is set,
+// bash writes a "#<epoch>" line before each command and everything up to the
+// next marker belongs to that command. Untimestamped lines are one command
+// each, joined only on a trailing backslash.
+//
+// Once a timestamp was seen, every line belongs to a timestamped command.
+// Commands before it, which is all of them in a file without any, are placed
+// one second apart from the Unix epoch by their position in the file. Their
+// times, and so their IDs, stay the same when the file is appended to or
+// touched.
+func (p *Parser) parseBashHistory(r io.Reader, state *parseState) ([]HistoryEntry, error) {
+	var records []bashRecord
+	scanner := newHistoryScanner(r, state.offset)
+
+	var (
+		current     *bashRecord
+		timestamped bool // current came from a "#<epoch>" marker
+	)
+	flush := func() {
+		if current != nil && strings.TrimSpace(current.command) != "" {
+			records = append(records, *current)
+		}
+		current = nil
+		timestamped = false
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if matches := bashTimestampRegex.FindStringSubmatch(line); len(matches) == 2 {
+			flush()
+			ts, _ := strconv.ParseInt(matches[1], 10, 64)
+			current = &bashRecord{offset: scanner.lineStart, timestamp: ts}
+			timestamped = true
+			continue
+		}
+
+		if current != nil && (timestamped || hasLineContinuation(current.command)) {
+			if current.command == "" {
+				current.command = line
+			} else {
+				current.command += "\n" + line
+			}
+			continue
+		}
+
+		flush()
+		current = &bashRecord{offset: scanner.lineStart, command: line}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	state.offset = scanner.next
+
+	var entries []HistoryEntry
+	for _, rec := range records {
+		state.markEntry(rec.offset)
+		timestamp := rec.timestamp
+		if timestamp == 0 {
+			timestamp = int64(state.nextID)
+		}
+		entries = append(entries, p.newEntry(state.nextID, time.Unix(timestamp, 0), 0, rec.command, &state.dir))
+		state.nextID++
+	}
+
+	return entries, nil
+}
+
+// hasLineContinuation reports whether cmd ends in an unescaped backslash
+func hasLineContinuation(cmd string) bool {
+	trailing := len(cmd) - len(strings.TrimRight(cmd, "\\"))
+	return trailing%2 == 1
+}
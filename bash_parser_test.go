@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectHistoryFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"zsh extended", ": 1700000000:0;git status\n: 1700000005:0;ls\n", HistoryFormatZsh},
+		{"bash timestamps", "#1700000000\ngit status\n#1700000005\nls\n", HistoryFormatBash},
+		{"bash plain", "git status\nls -la\n", HistoryFormatBash},
+		{"empty", "", HistoryFormatZsh},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectHistoryFormat([]byte(tt.head)); got != tt.want {
+				t.Errorf("DetectHistoryFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBashHistory_Timestamped(t *testing.T) {
+	input := strings.Join([]string{
+		"#1700000000",
+		"cd /tmp",
+		"#1700000010",
+		"for f in *; do",
+		"  echo $f",
+		"done",
+		"#1700000020",
+		"git status",
+	}, "\n")
+
+	p := &Parser{config: &Config{HomeDir: "/home/test"}}
+	entries, err := p.parseBashHistory(strings.NewReader(input), p.newParseState())
+	if err != nil {
+		t.Fatalf("parseBashHistory() error = %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[1].Command != "for f in *; do\n  echo $f\ndone" {
+		t.Errorf("Multiline command not joined, got %q", entries[1].Command)
+	}
+	if entries[1].Timestamp.Unix() != 1700000010 {
+		t.Errorf("Expected timestamp 1700000010, got %d", entries[1].Timestamp.Unix())
+	}
+	if entries[2].Directory != "/tmp" {
+		t.Errorf("Expected directory /tmp, got %q", entries[2].Directory)
+	}
+	if entries[2].Category != CategoryVCS {
+		t.Errorf("Expected category %v, got %v", CategoryVCS, entries[2].Category)
+	}
+}
+
+func TestParseBashHistory_Plain(t *testing.T) {
+	input := "ls -la\necho one \\\n  two\ngit status\n#1700000100\nmake\n"
+
+	p := &Parser{config: &Config{HomeDir: "/home/test"}}
+	entries, err := p.parseBashHistory(strings.NewReader(input), p.newParseState())
+	if err != nil {
+		t.Fatalf("parseBashHistory() error = %v", err)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(entries))
+	}
+	if entries[1].Command != "echo one \\\n  two" {
+		t.Errorf("Continuation line not joined, got %q", entries[1].Command)
+	}
+	// Commands before the first timestamp count seconds from the epoch
+	for i, want := range []int64{1, 2, 3, 1700000100} {
+		if got := entries[i].Timestamp.Unix(); got != want {
+			t.Errorf("Entry %d timestamp = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestParseBashHistory_StableIDs(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".bash_history")
+	if err := os.WriteFile(historyPath, []byte("ls -la\ncd /tmp\n"), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+	p := &Parser{config: &Config{HistoryFile: historyPath, HistoryFormat: HistoryFormatBash, HomeDir: tmpDir}}
+	var cursors HistoryCursors
+	before, _, err := p.ParseSources(&cursors, nil)
+	if err != nil {
+		t.Fatalf("ParseSources() error = %v", err)
+	}
+
+	// Touch and append, then parse incrementally and from scratch
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(historyPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(historyPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("make test\n")
+	f.Close()
+
+	incremental, result, err := p.ParseSources(&cursors, append([]HistoryEntry{}, before...))
+	if err != nil || result != ParseAppended {
+		t.Fatalf("ParseSources() result=%v err=%v", result, err)
+	}
+	full, err := p.ParseHistory()
+	if err != nil {
+		t.Fatalf("ParseHistory() error = %v", err)
+	}
+	if len(incremental) != 3 || len(full) != 3 {
+		t.Fatalf("Got %d entries incrementally and %d in full, want 3", len(incremental), len(full))
+	}
+	for i := range full {
+		if i < len(before) && before[i].StableID != full[i].StableID {
+			t.Errorf("ID of %q changed from %s to %s", full[i].Command, before[i].StableID, full[i].StableID)
+		}
+		if incremental[i].StableID != full[i].StableID || !incremental[i].Timestamp.Equal(full[i].Timestamp) {
+			t.Errorf("Entry %q differs: %s at %v incrementally, %s at %v in full", full[i].Command,
+				incremental[i].StableID, incremental[i].Timestamp, full[i].StableID, full[i].Timestamp)
+		}
+	}
+	if gap := full[2].Timestamp.Sub(full[1].Timestamp); gap != time.Second {
+		t.Errorf("Gap before the appended command = %v, want 1s", gap)
+	}
+}
+
+func TestParseHistory_ConfiguredFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".bash_history")
+	if err := os.WriteFile(historyPath, []byte("#1700000000\ngit status\n"), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+
+	p := &Parser{config: &Config{
+		HistoryFile:   historyPath,
+		HistoryFormat: HistoryFormatBash,
+		HomeDir:       tmpDir,
+	}}
+	entries, err := p.ParseHistory()
+	if err != nil {
+		t.Fatalf("ParseHistory() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Command != "git status" {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+}
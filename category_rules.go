@@ -0,0 +1,695 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CategoryRule assigns Category to the commands it matches. A rule matches
+// when all of its conditions hold. Category may name a subcategory, such as
+// "build/lint", to assign both levels. Secondary categories label the
+// command as well, with a smaller share. Rules are tried highest Priority first;
+// of equal priorities, rules from the config come before the built-in ones,
+// each in the order they are listed.
+type CategoryRule struct {
+	Name        string            `json:"name"`
+	Category    CommandCategory   `json:"category"`
+	Priority    int               `json:"priority"`
+	Commands    []string          `json:"commands,omitempty"`    // base command is one of these, ignoring its directory
+	Subcommands []string          `json:"subcommands,omitempty"` // first argument that isn't an option is one of these
+	Args        []string          `json:"args,omitempty"`        // globs that must each match an argument
+	Pattern     string            `json:"pattern,omitempty"`     // regexp matched against the whole command
+	Directory   string            `json:"directory,omitempty"`   // glob matched against the working directory, ** crosses /
+	Project     string            `json:"project,omitempty"`     // project the command ran in
+	Secondary   []CommandCategory `json:"secondary,omitempty"`   // further labels of the matched commands
+
+	pattern   *regexp.Regexp
+	directory *regexp.Regexp
+}
+
+// CommandContext is a simple command, without wrappers, with where it ran
+type CommandContext struct {
+	Command   string
+	Directory string
+	Project   string
+}
+
+// builtinCategoryRules are the default rules, all at priority 0, so their
+// order settles overlaps: npm is build before package-manager and vim is
+// dev-tools before editor
+var builtinCategoryRules = []CategoryRule{
+	{Name: "vcs-grep", Category: CategoryVCS, Commands: []string{"git"}, Subcommands: []string{"grep"}, Secondary: []CommandCategory{CategorySearch}},
+	{Name: "vcs", Category: CategoryVCS, Commands: []string{"git", "hg", "svn", "bzr", "cvs"}},
+	{Name: "build-go", Category: CategoryBuild, Commands: []string{"go"}, Subcommands: []string{"build", "test", "run", "install", "generate", "vet"}},
+	{Name: "build", Category: CategoryBuild, Commands: []string{"make", "cmake", "cargo", "npm", "yarn", "pnpm", "gradle", "mvn", "ant", "bazel", "gcc", "g++", "clang", "rustc", "javac"}},
+	{Name: "file-operations", Category: CategoryFileOps, Commands: []string{"cp", "mv", "rm", "mkdir", "rmdir", "touch", "chmod", "chown", "ln", "cat", "head", "tail", "less", "more", "dd", "rsync", "scp"}},
+	{Name: "search-find", Category: CategorySearch, Priority: 10, Commands: []string{"find"}, Args: []string{"-*name"}},
+	{Name: "navigation", Category: CategoryNavigation, Commands: []string{"cd", "ls", "pwd", "tree", "find", "locate", "which", "whereis"}},
+	{Name: "dev-tools", Category: CategoryDevTools, Commands: []string{"vim", "nvim", "emacs", "nano", "code", "subl", "idea", "pycharm", "gdb", "lldb", "valgrind", "strace", "ltrace"}},
+	{Name: "system-admin", Category: CategorySystemAdmin, Commands: []string{"sudo", "su", "systemctl", "service", "kill", "killall", "ps", "top", "htop", "free", "df", "du", "mount", "umount", "lsof", "netstat", "ss", "iptables", "ufw", "systemd"}},
+	{Name: "network", Category: CategoryNetwork, Commands: []string{"curl", "wget", "ssh", "ping", "traceroute", "nslookup", "dig", "host", "telnet", "nc", "netcat", "ftp", "sftp"}},
+	{Name: "containers", Category: CategoryContainers, Commands: []string{"docker", "podman", "kubectl", "k", "helm", "minikube", "kind", "k3s", "nerdctl", "containerd"}},
+	{Name: "database", Category: CategoryDatabase, Commands: []string{"psql", "mysql", "sqlite3", "mongo", "redis-cli", "mongosh", "clickhouse-client"}},
+	{Name: "editor", Category: CategoryEditor, Commands: []string{"vi", "ed", "joe", "pico"}},
+	{Name: "search", Category: CategorySearch, Commands: []string{"grep", "egrep", "fgrep", "ag", "rg", "ack"}},
+	{Name: "package-manager", Category: CategoryPackage, Commands: []string{"apt", "apt-get", "yum", "dnf", "pacman", "brew", "pip", "pip3", "gem", "composer"}},
+}
+
+// toolSubcategories derives subcategories of Category from the subcommands
+// of Commands. ValueOptions are the global options that take a separate
+// value, which comes before the subcommand. Subcommands in Scripts run the
+// script named by the next argument and are classified by the script's name,
+// or as "run".
+type toolSubcategories struct {
+	Category     CommandCategory
+	Commands     []string
+	ValueOptions []string
+	Subcommands  map[string]string
+	Scripts      []string
+}
+
+// builtinSubcategories are the subcategories of the commands of common tools,
+// applied when their command is in the tool's category
+var builtinSubcategories = []toolSubcategories{
+	{
+		Category:     CategoryVCS,
+		Commands:     []string{"git"},
+		ValueOptions: []string{"-C", "-c", "--git-dir", "--work-tree", "--namespace"},
+		Subcommands: map[string]string{
+			"commit": "commit", "push": "push", "pull": "pull", "fetch": "pull",
+			"rebase": "rebase", "cherry-pick": "rebase", "merge": "merge",
+			"checkout": "branch", "switch": "branch", "branch": "branch",
+			"status": "inspect", "diff": "inspect", "log": "inspect", "show": "inspect", "blame": "inspect", "grep": "inspect",
+			"add": "stage", "rm": "stage", "mv": "stage", "restore": "stage", "reset": "stage",
+			"stash": "stash", "clone": "clone", "tag": "tag",
+		},
+	},
+	{
+		Category:     CategoryContainers,
+		Commands:     []string{"docker", "podman", "nerdctl"},
+		ValueOptions: []string{"-H", "--host", "-c", "--context", "--config", "-l", "--log-level"},
+		Subcommands: map[string]string{
+			"build": "build", "buildx": "build",
+			"run": "run", "start": "run", "stop": "run", "restart": "run",
+			"exec": "exec", "attach": "exec", "cp": "exec",
+			"ps": "inspect", "images": "inspect", "logs": "inspect", "inspect": "inspect", "stats": "inspect", "top": "inspect",
+			"pull": "registry", "push": "registry", "login": "registry", "tag": "registry",
+			"rm": "cleanup", "rmi": "cleanup", "prune": "cleanup",
+			"compose": "compose",
+		},
+	},
+	{
+		Category:     CategoryContainers,
+		Commands:     []string{"kubectl", "k"},
+		ValueOptions: []string{"-n", "--namespace", "--context", "--cluster", "--kubeconfig", "-s", "--server", "--user"},
+		Subcommands: map[string]string{
+			"apply": "deploy", "create": "deploy", "delete": "deploy", "rollout": "deploy", "scale": "deploy",
+			"patch": "deploy", "edit": "deploy", "set": "deploy", "replace": "deploy",
+			"get": "inspect", "describe": "inspect", "logs": "inspect", "top": "inspect", "events": "inspect", "explain": "inspect",
+			"exec": "exec", "attach": "exec", "cp": "exec", "port-forward": "exec", "debug": "exec", "run": "exec",
+			"config": "config",
+		},
+	},
+	{
+		Category: CategoryBuild,
+		Commands: []string{"go"},
+		Subcommands: map[string]string{
+			"build": "compile", "install": "compile", "test": "test", "run": "run",
+			"generate": "generate", "vet": "lint",
+		},
+	},
+	{
+		Category:     CategoryBuild,
+		Commands:     []string{"npm", "yarn", "pnpm"},
+		ValueOptions: []string{"--prefix", "--cwd", "-C", "--dir", "--filter", "-w", "--workspace"},
+		Subcommands: map[string]string{
+			"install": "dependencies", "i": "dependencies", "ci": "dependencies", "add": "dependencies",
+			"remove": "dependencies", "uninstall": "dependencies", "update": "dependencies", "upgrade": "dependencies",
+			"test": "test", "t": "test", "build": "compile", "lint": "lint",
+			"start": "run", "exec": "run", "dlx": "run",
+		},
+		Scripts: []string{"run", "run-script"},
+	},
+	{
+		Category: CategoryBuild,
+		Commands: []string{"cargo"},
+		Subcommands: map[string]string{
+			"build": "compile", "check": "compile", "test": "test", "bench": "test", "run": "run",
+			"add": "dependencies", "remove": "dependencies", "update": "dependencies", "install": "dependencies",
+			"clippy": "lint", "fmt": "lint",
+		},
+	},
+}
+
+// commandRunner is a tool that runs a command given in its arguments, like
+// docker run IMAGE COMMAND or ssh HOST COMMAND
+type commandRunner struct {
+	Commands     []string
+	Subcommands  []string // subcommands that run a command, when the tool has subcommands
+	ValueOptions []string // options that take a separate value
+	AfterDashes  bool     // the command follows --, rather than the first argument
+}
+
+// commandRunners are the tools whose commands are also labelled with the
+// category of the command they run
+var commandRunners = []commandRunner{
+	{
+		Commands:    []string{"docker", "podman", "nerdctl"},
+		Subcommands: []string{"run", "exec"},
+		ValueOptions: []string{
+			"-e", "--env", "--env-file", "-v", "--volume", "--mount", "-p", "--publish", "--name", "-w", "--workdir",
+			"--network", "--net", "-u", "--user", "--entrypoint", "-l", "--label", "--platform", "-h", "--hostname",
+			"--add-host", "--cpus", "-m", "--memory", "--restart", "--gpus", "--device", "--cap-add", "--log-driver",
+		},
+	},
+	{
+		Commands:    []string{"kubectl", "k"},
+		Subcommands: []string{"exec", "run", "debug"},
+		AfterDashes: true,
+	},
+	{
+		Commands:     []string{"ssh"},
+		ValueOptions: []string{"-b", "-c", "-D", "-E", "-e", "-F", "-I", "-i", "-J", "-L", "-l", "-m", "-O", "-o", "-p", "-Q", "-R", "-S", "-W", "-w"},
+	},
+}
+
+// secondaryLabelWeight is the weight of a secondary label of a command
+// relative to its own category, before the weights are normalized
+const secondaryLabelWeight = 0.5
+
+// customRulePriority is the priority of the regexps of custom_category_patterns
+const customRulePriority = 100
+
+// learnedRulePriority puts the accepted LLM classifications after every
+// other rule, as they were only asked for commands no rule categorized
+const learnedRulePriority = -10
+
+// categoryRules are the rules in use, from SetCategoryRules,
+// SetCustomCategoryPatterns, SetLearnedCategories and the built-ins
+var categoryRules struct {
+	mu       sync.RWMutex
+	config   []CategoryRule
+	patterns []CategoryRule
+	learned  []CategoryRule
+	ordered  []CategoryRule
+}
+
+func init() {
+	for i := range builtinCategoryRules {
+		if err := builtinCategoryRules[i].compile(""); err != nil {
+			panic(err)
+		}
+	}
+	orderCategoryRules()
+}
+
+// SetCategoryRules configures the category_rules from the config. Rules that
+// don't compile are skipped with a warning. Directory globs may start with ~.
+func SetCategoryRules(rules []CategoryRule, homeDir string) {
+	var compiled []CategoryRule
+	for _, rule := range rules {
+		if err := rule.compile(homeDir); err != nil {
+			log.Printf("Warning: Skipping category rule %q: %v", rule.Name, err)
+			continue
+		}
+		compiled = append(compiled, rule)
+	}
+
+	categoryRules.mu.Lock()
+	categoryRules.config = compiled
+	categoryRules.mu.Unlock()
+	orderCategoryRules()
+}
+
+// orderCategoryRules sorts the configured and built-in rules by priority
+func orderCategoryRules() {
+	categoryRules.mu.Lock()
+	defer categoryRules.mu.Unlock()
+
+	var ordered []CategoryRule
+	ordered = append(ordered, categoryRules.config...)
+	ordered = append(ordered, categoryRules.patterns...)
+	ordered = append(ordered, builtinCategoryRules...)
+	ordered = append(ordered, categoryRules.learned...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority > ordered[j].Priority
+	})
+	categoryRules.ordered = ordered
+}
+
+// SetLearnedCategories configures the categories of base commands accepted
+// from LLM classification, see ClassificationCache
+func SetLearnedCategories(categories map[string]CommandCategory) {
+	bases := make([]string, 0, len(categories))
+	for base := range categories {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	rules := make([]CategoryRule, 0, len(bases))
+	for _, base := range bases {
+		rules = append(rules, CategoryRule{
+			Name:     "llm: " + base,
+			Category: categories[base],
+			Priority: learnedRulePriority,
+			Commands: []string{base},
+		})
+	}
+
+	categoryRules.mu.Lock()
+	categoryRules.learned = rules
+	categoryRules.mu.Unlock()
+	orderCategoryRules()
+}
+
+// knownCategories returns the top-level categories the rules assign
+func knownCategories() []CommandCategory {
+	categoryRules.mu.RLock()
+	seen := make(map[CommandCategory]bool)
+	var categories []CommandCategory
+	for _, rule := range categoryRules.ordered {
+		if category := rule.Category.Parent(); !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	categoryRules.mu.RUnlock()
+
+	sortCategories(categories)
+	return categories
+}
+
+// CategoryRules returns the rules in use, in the order they are tried
+func CategoryRules() []CategoryRule {
+	categoryRules.mu.RLock()
+	defer categoryRules.mu.RUnlock()
+	return append([]CategoryRule{}, categoryRules.ordered...)
+}
+
+// hasContextRules reports whether any rule depends on where a command ran,
+// so that entries must be categorized again once that is known
+func hasContextRules() bool {
+	categoryRules.mu.RLock()
+	defer categoryRules.mu.RUnlock()
+	for _, rule := range categoryRules.ordered {
+		if rule.Directory != "" || rule.Project != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCategoryRule returns the first rule matching the command, if any
+func matchCategoryRule(ctx CommandContext) (CategoryRule, bool) {
+	ctx.Command = strings.TrimSpace(ctx.Command)
+	base, args := commandWords(ctx.Command)
+	return matchCategoryWords(ctx, base, args)
+}
+
+// categorizeContext returns the category and subcategory of a simple command
+// and the rule that chose them, nil when none matched
+func categorizeContext(ctx CommandContext) (CommandCategory, CommandCategory, *CategoryRule) {
+	labels, rule := labelContext(ctx)
+	return labels[0].Category, labels[0].Subcategory, rule
+}
+
+// labelContext returns the weighted labels of a simple command and the rule
+// that chose its category. The first label is the command's own category;
+// the others are the secondary categories of the rule and the category of
+// the command it runs, as psql in docker run postgres psql.
+func labelContext(ctx CommandContext) ([]CategoryLabel, *CategoryRule) {
+	ctx.Command = strings.TrimSpace(ctx.Command)
+	base, args := commandWords(ctx.Command)
+	rule, ok := matchCategoryWords(ctx, base, args)
+	if !ok {
+		return []CategoryLabel{{Category: CategoryOther, Weight: 1}}, nil
+	}
+
+	primary := CategoryLabel{Category: rule.Category, Weight: 1}
+	if rule.Category.IsSubcategory() {
+		primary.Category, primary.Subcategory = rule.Category.Parent(), rule.Category
+	} else {
+		primary.Subcategory = subcategorize(rule.Category, base, args)
+	}
+	labels := []CategoryLabel{primary}
+	for _, secondary := range rule.Secondary {
+		labels = addLabel(labels, secondary.Parent(), subcategoryOrEmpty(secondary))
+	}
+	labels = addNestedLabel(labels, base, args)
+	return normalizeLabels(labels), &rule
+}
+
+// addNestedLabel labels a command with the category of the command it runs
+func addNestedLabel(labels []CategoryLabel, base string, args []string) []CategoryLabel {
+	nested := nestedCommand(base, args)
+	if nested == "" {
+		return labels
+	}
+	segments := SplitCommandSegments(nested)
+	if len(segments) == 0 {
+		return labels
+	}
+	primary := primarySegment(segments)
+	if primary.Category == CategoryOther {
+		return labels
+	}
+	return addLabel(labels, primary.Category, primary.Subcategory)
+}
+
+// addLabel adds a secondary label, unless the command already has one in
+// its category
+func addLabel(labels []CategoryLabel, category, subcategory CommandCategory) []CategoryLabel {
+	for _, label := range labels {
+		if label.Category == category {
+			return labels
+		}
+	}
+	return append(labels, CategoryLabel{Category: category, Subcategory: subcategory, Weight: secondaryLabelWeight})
+}
+
+// normalizeLabels scales the weights of labels to add up to 1
+func normalizeLabels(labels []CategoryLabel) []CategoryLabel {
+	total := 0.0
+	for _, label := range labels {
+		total += label.Weight
+	}
+	for i := range labels {
+		labels[i].Weight /= total
+	}
+	return labels
+}
+
+func subcategoryOrEmpty(category CommandCategory) CommandCategory {
+	if category.IsSubcategory() {
+		return category
+	}
+	return ""
+}
+
+// nestedCommand returns the command a commandRunner runs, or "" when the
+// command doesn't run one
+func nestedCommand(base string, args []string) string {
+	for _, runner := range commandRunners {
+		if !containsString(runner.Commands, base) {
+			continue
+		}
+		if len(runner.Subcommands) > 0 {
+			i := subcommandIndex(args, nil)
+			if i < 0 || !containsString(runner.Subcommands, args[i]) {
+				return ""
+			}
+			args = args[i+1:]
+		}
+		if runner.AfterDashes {
+			for i, arg := range args {
+				if arg == "--" {
+					return strings.Join(args[i+1:], " ")
+				}
+			}
+			return ""
+		}
+		// Skip the image, container or host
+		if i := subcommandIndex(args, runner.ValueOptions); i >= 0 {
+			return strings.Join(args[i+1:], " ")
+		}
+		return ""
+	}
+	return ""
+}
+
+// mergeLabels combines the labels of the segments of a command line, each
+// segment having an equal share of it
+func mergeLabels(segments []CommandSegment) []CategoryLabel {
+	var labels []CategoryLabel
+	for _, segment := range segments {
+		segmentLabels := segment.Labels
+		if len(segmentLabels) == 0 {
+			segmentLabels = []CategoryLabel{{Category: segment.Category, Subcategory: segment.Subcategory, Weight: 1}}
+		}
+	next:
+		for _, label := range segmentLabels {
+			weight := label.Weight / float64(len(segments))
+			for i := range labels {
+				if labels[i].Category == label.Category && labels[i].Subcategory == label.Subcategory {
+					labels[i].Weight += weight
+					continue next
+				}
+			}
+			labels = append(labels, CategoryLabel{Category: label.Category, Subcategory: label.Subcategory, Weight: weight})
+		}
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].Weight > labels[j].Weight
+	})
+	return labels
+}
+
+// subcategorize returns the built-in subcategory of a command in category,
+// or "" when it has none
+func subcategorize(category CommandCategory, base string, args []string) CommandCategory {
+	for _, tool := range builtinSubcategories {
+		if tool.Category != category || !containsString(tool.Commands, base) {
+			continue
+		}
+		i := subcommandIndex(args, tool.ValueOptions)
+		if i < 0 {
+			return ""
+		}
+		name, ok := tool.Subcommands[args[i]]
+		if containsString(tool.Scripts, args[i]) {
+			if name, ok = tool.Subcommands[subcommand(args[i+1:])]; !ok {
+				name, ok = "run", true
+			}
+		}
+		if !ok {
+			return ""
+		}
+		return category + "/" + CommandCategory(name)
+	}
+	return ""
+}
+
+func matchCategoryWords(ctx CommandContext, base string, args []string) (CategoryRule, bool) {
+	categoryRules.mu.RLock()
+	defer categoryRules.mu.RUnlock()
+	for _, rule := range categoryRules.ordered {
+		if rule.matches(ctx, base, args) {
+			return rule, true
+		}
+	}
+	return CategoryRule{}, false
+}
+
+// commandWords splits a simple command into its program, without its
+// directory, and arguments
+func commandWords(command string) (string, []string) {
+	commands := parseSimpleCommands(command)
+	if len(commands) == 0 {
+		return "", nil
+	}
+	words := commands[0].words
+	args := make([]string, 0, len(words)-1)
+	for _, word := range words[1:] {
+		args = append(args, word.text)
+	}
+	return path.Base(words[0].text), args
+}
+
+func (r *CategoryRule) compile(homeDir string) error {
+	if r.Category == "" {
+		return fmt.Errorf("no category")
+	}
+	if r.Pattern != "" {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return err
+		}
+		r.pattern = pattern
+	}
+	if r.Directory != "" {
+		directory, err := globRegexp(expandHome(r.Directory, homeDir))
+		if err != nil {
+			return err
+		}
+		r.directory = directory
+	}
+	for _, arg := range r.Args {
+		if _, err := path.Match(arg, ""); err != nil {
+			return fmt.Errorf("bad args glob %q: %w", arg, err)
+		}
+	}
+	return nil
+}
+
+func (r *CategoryRule) matches(ctx CommandContext, base string, args []string) bool {
+	if len(r.Commands) > 0 && !containsString(r.Commands, base) {
+		return false
+	}
+	if len(r.Subcommands) > 0 && !containsString(r.Subcommands, subcommand(args)) {
+		return false
+	}
+	for _, glob := range r.Args {
+		found := false
+		for _, arg := range args {
+			if ok, _ := path.Match(glob, arg); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.pattern != nil && !r.pattern.MatchString(ctx.Command) {
+		return false
+	}
+	if r.directory != nil && !r.directory.MatchString(ctx.Directory) {
+		return false
+	}
+	if r.Project != "" && r.Project != ctx.Project {
+		return false
+	}
+	return true
+}
+
+// subcommand is the first argument that isn't an option
+func subcommand(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// subcommandIndex returns the index of the first argument that is neither
+// an option nor the value of one of valueOptions, or -1 when there is none
+func subcommandIndex(args []string, valueOptions []string) int {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return i
+		}
+		if containsString(valueOptions, args[i]) {
+			i++
+		}
+	}
+	return -1
+}
+
+// globRegexp compiles a glob where * and ? don't match / and ** matches
+// anything, so "~/work/**" matches every directory below ~/work
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CategoryExplanation is the category of a command line with the rules
+// that chose it, for each of its simple commands
+type CategoryExplanation struct {
+	Command         string               `json:"command"`
+	ExpandedCommand string               `json:"expanded_command,omitempty"`
+	Category        CommandCategory      `json:"category"`
+	Subcategory     CommandCategory      `json:"subcategory,omitempty"`
+	Labels          []CategoryLabel      `json:"labels"`
+	Rule            *CategoryRule        `json:"rule"` // nil when no rule matched
+	Segments        []SegmentExplanation `json:"segments"`
+}
+
+// SegmentExplanation is the category of one simple command of a command line
+type SegmentExplanation struct {
+	Command     string          `json:"command"`
+	BaseCommand string          `json:"base_command"`
+	Category    CommandCategory `json:"category"`
+	Subcategory CommandCategory `json:"subcategory,omitempty"`
+	Labels      []CategoryLabel `json:"labels"`
+	Rule        *CategoryRule   `json:"rule"`
+}
+
+// ExplainCategory categorizes a command line as the parser does and reports
+// the rule that fired for each segment. The line's category is that of its
+// primary segment.
+func ExplainCategory(command, expanded, directory, project string) CategoryExplanation {
+	explanation := CategoryExplanation{Command: command, Segments: []SegmentExplanation{}}
+	if expanded != command {
+		explanation.ExpandedCommand = expanded
+	}
+
+	segments := SplitCommandSegments(expanded)
+	if len(segments) == 0 {
+		segments = []CommandSegment{{Command: expanded, BaseCommand: GetBaseCommand(expanded)}}
+	}
+	rules := make([]*CategoryRule, len(segments))
+	for i := range segments {
+		segments[i].Labels, rules[i] = labelContext(CommandContext{segments[i].Command, directory, project})
+		segments[i].Category, segments[i].Subcategory = segments[i].Labels[0].Category, segments[i].Labels[0].Subcategory
+		explanation.Segments = append(explanation.Segments, SegmentExplanation{
+			Command:     segments[i].Command,
+			BaseCommand: segments[i].BaseCommand,
+			Category:    segments[i].Category,
+			Subcategory: segments[i].Subcategory,
+			Labels:      segments[i].Labels,
+			Rule:        rules[i],
+		})
+	}
+
+	primary := primarySegmentIndex(segments)
+	explanation.Category = segments[primary].Category
+	explanation.Subcategory = segments[primary].Subcategory
+	explanation.Labels = mergeLabels(segments)
+	explanation.Rule = rules[primary]
+	return explanation
+}
+
+// recategorize categorizes an entry again with its directory and project
+func recategorize(entry *HistoryEntry) {
+	command := entry.Command
+	if entry.ExpandedCommand != "" {
+		command = entry.ExpandedCommand
+	}
+	if len(entry.Segments) == 0 {
+		entry.Labels, _ = labelContext(CommandContext{command, entry.Directory, entry.Project})
+		entry.Category, entry.Subcategory = entry.Labels[0].Category, entry.Labels[0].Subcategory
+		return
+	}
+	for i := range entry.Segments {
+		segment := &entry.Segments[i]
+		segment.Labels, _ = labelContext(CommandContext{segment.Command, entry.Directory, entry.Project})
+		segment.Category, segment.Subcategory = segment.Labels[0].Category, segment.Labels[0].Subcategory
+	}
+	primary := primarySegment(entry.Segments)
+	entry.Category = primary.Category
+	entry.Subcategory = primary.Subcategory
+	entry.BaseCommand = primary.BaseCommand
+	entry.Labels = mergeLabels(entry.Segments)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCategoryRules(t *testing.T) {
+	SetCategoryRules([]CategoryRule{
+		{Name: "go-lint", Category: "lint", Commands: []string{"go"}, Subcommands: []string{"vet"}},
+		{Name: "tests", Category: "testing", Priority: 5, Commands: []string{"npm", "yarn"}, Subcommands: []string{"test"}},
+		{Name: "terraform-plan", Category: "infra", Commands: []string{"terraform"}, Args: []string{"-var-file=*"}},
+		{Name: "work", Category: "work-tools", Directory: "~/work/**", Commands: []string{"make"}},
+		{Name: "api", Category: "api-dev", Project: "api", Pattern: `^curl .*localhost`},
+		{Name: "broken", Category: "x", Pattern: `[unclosed`},
+	}, "/home/test")
+	defer SetCategoryRules(nil, "")
+
+	tests := []struct {
+		ctx  CommandContext
+		want CommandCategory
+		rule string
+	}{
+		{CommandContext{Command: "go vet ./..."}, "lint", "go-lint"},
+		{CommandContext{Command: "go test ./..."}, CategoryBuild, "build-go"},
+		{CommandContext{Command: "go mod tidy"}, CategoryOther, ""},
+		{CommandContext{Command: "npm test -- --watch"}, "testing", "tests"},
+		{CommandContext{Command: "npm install"}, CategoryBuild, "build"},
+		{CommandContext{Command: "terraform plan -var-file=prod.tfvars"}, "infra", "terraform-plan"},
+		{CommandContext{Command: "terraform plan"}, CategoryOther, ""},
+		{CommandContext{Command: "make", Directory: "/home/test/work/api/cmd"}, "work-tools", "work"},
+		{CommandContext{Command: "make", Directory: "/home/test/play"}, CategoryBuild, "build"},
+		{CommandContext{Command: "curl localhost:8080/health", Project: "api"}, "api-dev", "api"},
+		{CommandContext{Command: "curl localhost:8080/health"}, CategoryNetwork, "network"},
+		{CommandContext{Command: "/usr/bin/git status"}, CategoryVCS, "vcs"},
+		{CommandContext{Command: "find . -name '*.go'"}, CategorySearch, "search-find"},
+		{CommandContext{Command: "find . -type d"}, CategoryNavigation, "navigation"},
+		{CommandContext{Command: "ls"}, CategoryNavigation, "navigation"},
+	}
+	for _, tt := range tests {
+		rule, ok := matchCategoryRule(tt.ctx)
+		got := CategoryOther
+		if ok {
+			got = rule.Category
+		}
+		if got != tt.want || rule.Name != tt.rule {
+			t.Errorf("matchCategoryRule(%+v) = %s by %q, want %s by %q", tt.ctx, got, rule.Name, tt.want, tt.rule)
+		}
+	}
+
+	for _, rule := range CategoryRules() {
+		if rule.Name == "broken" {
+			t.Errorf("Rule with an invalid pattern was kept")
+		}
+	}
+	if !hasContextRules() {
+		t.Errorf("hasContextRules() = false with directory and project rules")
+	}
+}
+
+func TestCategoryRules_Deterministic(t *testing.T) {
+	// Overlapping rules used to be tried in map order
+	for i := 0; i < 50; i++ {
+		if got := CategorizeCommand("vim foo"); got != CategoryDevTools {
+			t.Fatalf("CategorizeCommand(vim foo) = %s on try %d", got, i)
+		}
+		if got := CategorizeCommand("npm install"); got != CategoryBuild {
+			t.Fatalf("CategorizeCommand(npm install) = %s on try %d", got, i)
+		}
+	}
+}
+
+func TestExplainCategory(t *testing.T) {
+	explanation := ExplainCategory("gs && docker ps", "git status && docker ps", "/src", "")
+	if explanation.Category != CategoryVCS || explanation.Rule == nil || explanation.Rule.Name != "vcs" {
+		t.Errorf("Explanation = %s by %+v, want version-control by vcs", explanation.Category, explanation.Rule)
+	}
+	if explanation.ExpandedCommand != "git status && docker ps" {
+		t.Errorf("Expanded command = %q", explanation.ExpandedCommand)
+	}
+	if len(explanation.Segments) != 2 || explanation.Segments[1].Rule == nil || explanation.Segments[1].Rule.Name != "containers" {
+		t.Errorf("Segments = %+v", explanation.Segments)
+	}
+
+	explanation = ExplainCategory("frobnicate", "frobnicate", "", "")
+	if explanation.Category != CategoryOther || explanation.Rule != nil {
+		t.Errorf("Unknown command explained as %s by %+v", explanation.Category, explanation.Rule)
+	}
+}
+
+func TestRecategorize(t *testing.T) {
+	SetCategoryRules([]CategoryRule{{Name: "api", Category: "api-dev", Project: "api", Commands: []string{"make"}}}, "")
+	defer SetCategoryRules(nil, "")
+
+	entry := buildEntry(1, time.Unix(1700000000, 0), 0, "cd api && make", "cd api && make", "/src/api")
+	if entry.Category != CategoryBuild {
+		t.Fatalf("Category before the project is known = %s, want build", entry.Category)
+	}
+	entry.Project = "api"
+	recategorize(&entry)
+	if entry.Category != "api-dev" || entry.BaseCommand != "make" || entry.Segments[1].Category != "api-dev" {
+		t.Errorf("Recategorized entry = %s (%s), segments %+v", entry.Category, entry.BaseCommand, entry.Segments)
+	}
+}
+
+func TestSubcategories(t *testing.T) {
+	SetCategoryRules([]CategoryRule{{Name: "lint", Category: "build/lint", Commands: []string{"golangci-lint"}}}, "")
+	defer SetCategoryRules(nil, "")
+
+	tests := []struct {
+		command     string
+		category    CommandCategory
+		subcategory CommandCategory
+	}{
+		{"git commit -m 'fix'", CategoryVCS, "version-control/commit"},
+		{"git -C api push origin main", CategoryVCS, "version-control/push"},
+		{"git rebase -i HEAD~3", CategoryVCS, "version-control/rebase"},
+		{"git frobnicate", CategoryVCS, ""},
+		{"docker build -t app .", CategoryContainers, "containers/build"},
+		{"kubectl exec -it web -- sh", CategoryContainers, "containers/exec"},
+		{"go test ./...", CategoryBuild, "build/test"},
+		{"npm run test -- --watch", CategoryBuild, "build/test"},
+		{"npm run dev", CategoryBuild, "build/run"},
+		{"cargo add serde", CategoryBuild, "build/dependencies"},
+		{"golangci-lint run", CategoryBuild, "build/lint"},
+		{"ls -la", CategoryNavigation, ""},
+	}
+	for _, tt := range tests {
+		category, subcategory, _ := categorizeContext(CommandContext{Command: tt.command})
+		if category != tt.category || subcategory != tt.subcategory {
+			t.Errorf("categorizeContext(%q) = %s, %q, want %s, %q", tt.command, category, subcategory, tt.category, tt.subcategory)
+		}
+	}
+
+	// "-C api" is an option and its value, so the subcommand is still found
+	entry := buildEntry(1, time.Unix(1700000000, 0), 0, "cd api && git push", "cd api && git push", "/src/api")
+	if entry.Category != CategoryVCS || entry.Subcategory != "version-control/push" {
+		t.Errorf("Entry = %s, %q, want version-control, version-control/push", entry.Category, entry.Subcategory)
+	}
+}
+
+func TestLabelContext(t *testing.T) {
+	type label struct {
+		category CommandCategory
+		weight   float64
+	}
+	tests := []struct {
+		command string
+		want    []label
+	}{
+		{"ls -la", []label{{CategoryNavigation, 1}}},
+		{"git grep TODO", []label{{CategoryVCS, 2.0 / 3}, {CategorySearch, 1.0 / 3}}},
+		{"docker run --rm -e PGPASSWORD=x postgres psql -h db", []label{{CategoryContainers, 2.0 / 3}, {CategoryDatabase, 1.0 / 3}}},
+		{"kubectl exec -it web -- redis-cli ping", []label{{CategoryContainers, 2.0 / 3}, {CategoryDatabase, 1.0 / 3}}},
+		{"ssh -p 2222 prod 'sudo systemctl restart app'", []label{{CategoryNetwork, 2.0 / 3}, {CategorySystemAdmin, 1.0 / 3}}},
+		{"docker run postgres", []label{{CategoryContainers, 1}}},
+		{"frobnicate", []label{{CategoryOther, 1}}},
+	}
+	for _, tt := range tests {
+		labels, _ := labelContext(CommandContext{Command: tt.command})
+		if len(labels) != len(tt.want) {
+			t.Errorf("labelContext(%q) = %+v, want %v", tt.command, labels, tt.want)
+			continue
+		}
+		for i, want := range tt.want {
+			if labels[i].Category != want.category || math.Abs(labels[i].Weight-want.weight) > 1e-9 {
+				t.Errorf("labelContext(%q)[%d] = %+v, want %v", tt.command, i, labels[i], want)
+			}
+		}
+	}
+}
+
+func TestEntryLabelShares(t *testing.T) {
+	entry := buildEntry(1, time.Unix(1700000000, 0), 0, "git grep TODO | wc -l", "git grep TODO | wc -l", "/src")
+	if entry.Category != CategoryVCS {
+		t.Errorf("Category = %s, want version-control", entry.Category)
+	}
+
+	shares := make(map[CommandCategory]float64)
+	addLabelShares(shares, &entry)
+	want := map[CommandCategory]float64{CategoryVCS: 1.0 / 3, "version-control/inspect": 1.0 / 3, CategorySearch: 1.0 / 6, CategoryOther: 0.5}
+	total := 0.0
+	for _, label := range entry.Labels {
+		total += label.Weight
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("Label weights add up to %v, want 1", total)
+	}
+	for category, share := range want {
+		if math.Abs(shares[category]-share) > 1e-9 {
+			t.Errorf("Share of %s = %v, want %v (labels %+v)", category, shares[category], share, entry.Labels)
+		}
+	}
+
+	session := buildSession([]HistoryEntry{entry, buildEntry(2, time.Unix(1700000060, 0), 0, "git commit", "git commit", "/src")}, nil)
+	if !sessionHasCategory(&session, CategorySearch, 0) || sessionHasCategory(&session, CategorySearch, 0.25) {
+		t.Errorf("Search share of the session = %v, want 1/12", sessionCategoryShare(&session, CategorySearch))
+	}
+	if !sessionHasCategory(&session, CategoryVCS, 0.5) {
+		t.Errorf("Version control share of the session = %v, want 2/3", sessionCategoryShare(&session, CategoryVCS))
+	}
+}
@@ -4,19 +4,64 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type Config struct {
 	HistoryFile          string                  `json:"history_file"`
+	HistoryFormat        string                  `json:"history_format"` // "auto", "zsh", "bash", "fish" or "atuin"
+	HistorySources       []HistorySource         `json:"history_sources,omitempty"` // Read instead of HistoryFile when set
 	Port                 int                     `json:"port"`
 	SessionTimeout       time.Duration           `json:"session_timeout_minutes"`
 	OllamaURL            string                  `json:"ollama_url"`
 	OllamaModel          string                  `json:"ollama_model"`
 	AutoRefreshSec       int                     `json:"auto_refresh_seconds"`
 	HomeDir              string                  `json:"home_dir"`
+	HookLog              string                  `json:"hook_log"` // JSON lines written by the shell hook
+	AliasFiles           []string                `json:"alias_files,omitempty"` // rc files to read aliases from, may be globs
+	AliasDump            string                  `json:"alias_dump,omitempty"`  // saved output of the alias builtin
 	SessionHeuristics    SessionHeuristics       `json:"session_heuristics"`
 	CustomCategoryPatterns []CustomCategoryPattern `json:"custom_category_patterns,omitempty"`
+	CategoryRules        []CategoryRule          `json:"category_rules,omitempty"` // Tried before the built-in rules, see CategoryRule
+	Projects             []ProjectDefinition     `json:"projects,omitempty"` // Named directory trees, ahead of detected projects
+	ClassifyOtherCommands bool                   `json:"classify_other_commands,omitempty"` // Ask Ollama to suggest categories for uncategorized commands
+}
+
+// HistorySource is one history file to read, labelled with the host it came from
+type HistorySource struct {
+	Path   string `json:"path"`
+	Host   string `json:"host"`
+	Format string `json:"format,omitempty"` // defaults to history_format
+}
+
+// Sources returns the history files to read. Without history_sources, only
+// HistoryFile is read and its entries carry no host label.
+func (c *Config) Sources() []HistorySource {
+	if len(c.HistorySources) == 0 {
+		return []HistorySource{{Path: expandHome(c.HistoryFile, c.HomeDir), Format: c.HistoryFormat}}
+	}
+
+	sources := make([]HistorySource, len(c.HistorySources))
+	for i, source := range c.HistorySources {
+		source.Path = expandHome(source.Path, c.HomeDir)
+		if source.Format == "" {
+			source.Format = c.HistoryFormat
+		}
+		sources[i] = source
+	}
+	return sources
+}
+
+// expandHome replaces a leading "~/" in path with the home directory
+func expandHome(path, homeDir string) string {
+	if path == "~" {
+		return homeDir
+	}
+	if strings.HasPrefix(path, "~/") && homeDir != "" {
+		return filepath.Join(homeDir, path[2:])
+	}
+	return path
 }
 
 // SessionHeuristics defines configurable parameters for session detection
@@ -41,6 +86,22 @@ type SessionHeuristics struct {
 	// ShortBreakMinutes: a "short break" that doesn't end a session (e.g., coffee break)
 	// Only used if less than TimeoutMinutes. Commands within short break are same session.
 	ShortBreakMinutes int `json:"short_break_minutes"`
+
+	// TimeoutPeriods: timeouts for parts of the day, replacing TimeoutMinutes for gaps
+	// that start in them. "history_viewer fit-timeout -apply" fills these in.
+	TimeoutPeriods []TimeoutPeriod `json:"timeout_periods,omitempty"`
+
+	// SplitConcurrentTerminals: segment the commands of each terminal on their own, so sessions
+	// in terminals open side by side (e.g. with share_history) may overlap in time
+	SplitConcurrentTerminals bool `json:"split_concurrent_terminals"`
+}
+
+// TimeoutPeriod is the session timeout for gaps starting between StartHour
+// and EndHour (exclusive), in local time
+type TimeoutPeriod struct {
+	StartHour      int `json:"start_hour"`
+	EndHour        int `json:"end_hour"`
+	TimeoutMinutes int `json:"timeout_minutes"`
 }
 
 // CustomCategoryPattern allows users to add custom command categorization rules
@@ -57,12 +118,15 @@ func LoadConfig() (*Config, error) {
 
 	config := &Config{
 		HistoryFile:    filepath.Join(homeDir, ".zsh_history"),
+		HistoryFormat:  HistoryFormatAuto,
 		Port:           8080,
 		SessionTimeout: 30 * time.Minute,
 		OllamaURL:      "http://localhost:11434",
 		OllamaModel:    "llama3.3",
 		AutoRefreshSec: 30,
 		HomeDir:        homeDir,
+		HookLog:        filepath.Join(homeDir, ".history_viewer_hook.jsonl"),
+		AliasFiles:     []string{"~/.zshrc", "~/.aliases"},
 		SessionHeuristics: SessionHeuristics{
 			TimeoutMinutes:               30,
 			DirectoryChangeBreaksSession: false,
@@ -80,6 +144,21 @@ func LoadConfig() (*Config, error) {
 			if fileConfig.HistoryFile != "" {
 				config.HistoryFile = fileConfig.HistoryFile
 			}
+			if fileConfig.HistoryFormat != "" {
+				config.HistoryFormat = fileConfig.HistoryFormat
+			}
+			if len(fileConfig.HistorySources) > 0 {
+				config.HistorySources = fileConfig.HistorySources
+			}
+			if fileConfig.HookLog != "" {
+				config.HookLog = expandHome(fileConfig.HookLog, homeDir)
+			}
+			if len(fileConfig.AliasFiles) > 0 {
+				config.AliasFiles = fileConfig.AliasFiles
+			}
+			if fileConfig.AliasDump != "" {
+				config.AliasDump = fileConfig.AliasDump
+			}
 			if fileConfig.Port != 0 {
 				config.Port = fileConfig.Port
 			}
@@ -104,6 +183,13 @@ func LoadConfig() (*Config, error) {
 			if len(fileConfig.CustomCategoryPatterns) > 0 {
 				config.CustomCategoryPatterns = fileConfig.CustomCategoryPatterns
 			}
+			if len(fileConfig.CategoryRules) > 0 {
+				config.CategoryRules = fileConfig.CategoryRules
+			}
+			if len(fileConfig.Projects) > 0 {
+				config.Projects = fileConfig.Projects
+			}
+			config.ClassifyOtherCommands = fileConfig.ClassifyOtherCommands
 		}
 	}
 
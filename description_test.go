@@ -206,7 +206,7 @@ func TestGenerateSessionDescription(t *testing.T) {
 					{BaseCommand: "git", Command: "git commit", Directory: "/Users/chris/code/project"},
 				},
 				Directories: []string{"/Users/chris/code/project"},
-				Categories:  map[CommandCategory]int{CategoryVCS: 3},
+				Categories:  map[CommandCategory]float64{CategoryVCS: 3},
 			},
 			homeDir: "/Users/chris",
 			want:    "project: git [Version Control]",
@@ -222,11 +222,25 @@ func TestGenerateSessionDescription(t *testing.T) {
 					{BaseCommand: "ls", Command: "ls", Directory: "/Users/chris/code/project/src/components"},
 				},
 				Directories: []string{"/Users/chris/code/project/src/components"},
-				Categories:  map[CommandCategory]int{CategoryVCS: 2, CategoryBuild: 2, CategoryFileOps: 1},
+				Categories:  map[CommandCategory]float64{CategoryVCS: 2, CategoryBuild: 2, CategoryFileOps: 1},
 			},
 			homeDir: "/Users/chris",
 			want:    "src/components: git npm [Version Control, Build]",
 		},
+		{
+			name: "subcategory dominating its category",
+			session: &Session{
+				Commands: []HistoryEntry{
+					{BaseCommand: "git", Command: "git rebase -i main", Directory: "/Users/chris/code/project"},
+					{BaseCommand: "git", Command: "git rebase --continue", Directory: "/Users/chris/code/project"},
+					{BaseCommand: "git", Command: "git status", Directory: "/Users/chris/code/project"},
+				},
+				Directories: []string{"/Users/chris/code/project"},
+				Categories:  map[CommandCategory]float64{CategoryVCS: 3, "version-control/rebase": 2, "version-control/inspect": 1},
+			},
+			homeDir: "/Users/chris",
+			want:    "project: git [Version Control: Rebase]",
+		},
 	}
 	
 	for _, tt := range tests {
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dirState is the working directory and directory stack of the shell that
+// wrote the history, as far as it can be inferred from the commands
+type dirState struct {
+	current  string
+	previous string   // $OLDPWD, where "cd -" goes
+	stack    []string // pushd stack below the current directory, top first
+}
+
+// The stack is shared with copies of the state kept by HistoryCursor, so it
+// is never modified in place
+
+func (d *dirState) cd(dir string) {
+	d.previous, d.current = d.current, dir
+}
+
+func (d *dirState) pushd(dir string) {
+	d.stack = append([]string{d.current}, d.stack...)
+	d.cd(dir)
+}
+
+func (d *dirState) popd() {
+	if len(d.stack) == 0 {
+		return
+	}
+	top := d.stack[0]
+	d.stack = d.stack[1:]
+	d.cd(top)
+}
+
+// rotate brings entry n of the full stack (current directory first) to the
+// top, like "pushd +n"
+func (d *dirState) rotate(n int) {
+	full := append([]string{d.current}, d.stack...)
+	if n <= 0 || n >= len(full) {
+		return
+	}
+	rotated := append(append([]string{}, full[n:]...), full[:n]...)
+	d.stack = rotated[1:]
+	d.cd(rotated[0])
+}
+
+// drop removes entry n of the full stack, like "popd +n"
+func (d *dirState) drop(n int) {
+	if n == 0 {
+		d.popd()
+		return
+	}
+	if n < 1 || n > len(d.stack) {
+		return
+	}
+	d.stack = append(append([]string{}, d.stack[:n-1]...), d.stack[n:]...)
+}
+
+// stackIndex converts a "+n" or "-n" stack argument to an index from the top
+// of the full stack
+func (d *dirState) stackIndex(arg string) (int, bool) {
+	if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') {
+		return 0, false
+	}
+	n, err := strconv.Atoi(arg[1:])
+	if err != nil {
+		return 0, false
+	}
+	if arg[0] == '-' {
+		n = len(d.stack) - n
+	}
+	return n, true
+}
+
+// directoryCheck reports whether a cd target existed when the command ran,
+// for shells that record it. Without one, every cd is assumed to succeed.
+type directoryCheck func(target string) bool
+
+// trackDirectory updates dir with the directory changes a command line makes:
+// cd (including "cd -" and zsh's "cd old new"), pushd, popd, "dirs -c" and
+// auto_cd to a bare directory, in any segment of a compound command. Changes
+// made inside a ( ) subshell don't last and are ignored, as are targets that
+// depend on variables or globs that can't be expanded.
+func (p *Parser) trackDirectory(dir *dirState, command string, exists directoryCheck) {
+	for _, cmd := range parseSimpleCommands(command) {
+		if cmd.subshell {
+			continue
+		}
+
+		name := cmd.words[0].text
+		var args []string
+		for _, word := range cmd.words[1:] {
+			args = append(args, word.text)
+		}
+
+		switch name {
+		case "cd", "chdir":
+			p.changeDirectory(dir, args, exists)
+		case "pushd":
+			args = withoutOptions(args)
+			if len(args) == 0 {
+				dir.rotate(1)
+			} else if n, ok := dir.stackIndex(args[0]); ok {
+				dir.rotate(n)
+			} else if target, ok := p.expandDirectory(dir, args[0], exists); ok {
+				dir.pushd(target)
+			}
+		case "popd":
+			args = withoutOptions(args)
+			if len(args) == 0 {
+				dir.popd()
+			} else if n, ok := dir.stackIndex(args[0]); ok {
+				dir.drop(n)
+			}
+		case "dirs":
+			for _, arg := range args {
+				if arg == "-c" {
+					dir.stack = nil
+				}
+			}
+		default:
+			if len(args) == 0 && p.isAutoCD(name, exists) {
+				if target, ok := p.expandDirectory(dir, name, exists); ok {
+					dir.cd(target)
+				}
+			}
+		}
+	}
+}
+
+func (p *Parser) changeDirectory(dir *dirState, args []string, exists directoryCheck) {
+	// Options like -P or -q, but "-" alone is the previous directory and
+	// "+n"/"-n" pick an entry of the stack
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if _, ok := dir.stackIndex(args[0]); ok {
+			break
+		}
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		args = args[1:]
+	}
+
+	switch {
+	case len(args) == 0:
+		dir.cd(p.config.HomeDir)
+	case args[0] == "-":
+		if dir.previous != "" {
+			dir.cd(dir.previous)
+		}
+	case len(args) == 2:
+		// zsh replaces the first occurrence of old with new in $PWD
+		if strings.Contains(dir.current, args[0]) {
+			dir.cd(strings.Replace(dir.current, args[0], args[1], 1))
+		}
+	default:
+		if n, ok := dir.stackIndex(args[0]); ok {
+			dir.rotate(n)
+		} else if target, ok := p.expandDirectory(dir, args[0], exists); ok {
+			dir.cd(target)
+		}
+	}
+}
+
+var autoCDRegex = regexp.MustCompile(`^(\.\.?(/\.\.)*/?|~[\w.-]*/?|.*/)$`)
+
+// isAutoCD reports whether a command without arguments is a directory that
+// zsh's auto_cd (or bash's autocd, or fish) changes into: "..", "~user" or
+// anything ending in "/". A bare name is only a directory when no command
+// has that name, which the history doesn't tell, so it is left alone.
+func (p *Parser) isAutoCD(name string, exists directoryCheck) bool {
+	if exists != nil {
+		return (strings.Contains(name, "/") || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~")) && exists(name)
+	}
+	return autoCDRegex.MatchString(name)
+}
+
+var shellVariableRegex = regexp.MustCompile(`\$(\{(\w+)\}|(\w+))`)
+
+// expandDirectory expands ~, ~user, ~+, ~-, $HOME, $PWD and $OLDPWD in a cd
+// target and resolves it against the current directory. It fails for targets
+// that can't be known from the history.
+func (p *Parser) expandDirectory(dir *dirState, target string, exists directoryCheck) (string, bool) {
+	if exists != nil && !exists(target) {
+		return "", false
+	}
+
+	known := true
+	target = shellVariableRegex.ReplaceAllStringFunc(target, func(variable string) string {
+		match := shellVariableRegex.FindStringSubmatch(variable)
+		name := match[2] + match[3]
+		switch name {
+		case "HOME":
+			return p.config.HomeDir
+		case "PWD":
+			return dir.current
+		case "OLDPWD":
+			return dir.previous
+		}
+		known = false
+		return variable
+	})
+	if !known || strings.ContainsAny(target, "*?[`") {
+		return "", false
+	}
+
+	if strings.HasPrefix(target, "~") {
+		name, rest, _ := strings.Cut(target[1:], "/")
+		var home string
+		switch name {
+		case "":
+			home = p.config.HomeDir
+		case "+":
+			home = dir.current
+		case "-":
+			home = dir.previous
+		default:
+			home = p.userHome(name)
+		}
+		target = filepath.Join(home, rest)
+	}
+
+	return p.resolveDirectory(dir.current, target), true
+}
+
+// userHome returns the home directory of another user. Users that don't
+// exist on this machine are assumed to live next to the configured home.
+func (p *Parser) userHome(name string) string {
+	if u, err := user.Lookup(name); err == nil && u.HomeDir != "" {
+		return u.HomeDir
+	}
+	return filepath.Join(filepath.Dir(p.config.HomeDir), name)
+}
+
+func withoutOptions(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		if _, err := strconv.Atoi(args[0][1:]); err == nil {
+			break
+		}
+		args = args[1:]
+	}
+	return args
+}
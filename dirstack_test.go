@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTrackDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{"cd", []string{"cd /srv/app"}, "/srv/app"},
+		{"cd home", []string{"cd /srv", "cd"}, "/home/test"},
+		{"cd previous", []string{"cd /srv/app", "cd /tmp", "cd -"}, "/srv/app"},
+		{"cd previous twice", []string{"cd /srv/app", "cd /tmp", "cd -", "cd -"}, "/tmp"},
+		{"after and", []string{"cd /srv && cd app && make"}, "/srv/app"},
+		{"after semicolon", []string{"make; cd /tmp"}, "/tmp"},
+		{"subshell", []string{"(cd /tmp && make)"}, "/home/test"},
+		{"wrapped", []string{"builtin cd -P /srv"}, "/srv"},
+		{"auto_cd parent", []string{"cd /srv/app", ".."}, "/srv"},
+		{"auto_cd slash", []string{"cd /srv", "app/"}, "/srv/app"},
+		{"command named like a directory", []string{"cd /", "tmp"}, "/"},
+		{"home variable", []string{`cd "$HOME/code"`}, "/home/test/code"},
+		{"pwd variable", []string{"cd /srv", "cd ${PWD}/app"}, "/srv/app"},
+		{"tilde", []string{"cd ~/code"}, "/home/test/code"},
+		{"other user", []string{"cd ~nobody-else/src"}, "/home/nobody-else/src"},
+		{"unknown variable", []string{"cd /srv", "cd $PROJECT_DIR"}, "/srv"},
+		{"zsh substitution", []string{"cd /srv/app/v1/src", "cd v1 v2"}, "/srv/app/v2/src"},
+		{"pushd popd", []string{"pushd /srv", "pushd /tmp", "popd"}, "/srv"},
+		{"popd to start", []string{"pushd /srv", "pushd /tmp", "popd", "popd"}, "/home/test"},
+		{"pushd swap", []string{"pushd /srv", "pushd"}, "/home/test"},
+		{"pushd rotate", []string{"pushd /a", "pushd /b", "pushd /c", "pushd +2"}, "/a"},
+		{"popd entry", []string{"pushd /a", "pushd /b", "popd +1", "popd"}, "/home/test"},
+		{"dirs clear", []string{"pushd /a", "dirs -c", "popd"}, "/a"},
+	}
+
+	p := &Parser{config: &Config{HomeDir: "/home/test"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := dirState{current: "/home/test"}
+			for _, command := range tt.commands {
+				p.trackDirectory(&dir, command, nil)
+			}
+			if dir.current != tt.want {
+				t.Errorf("directory = %q, want %q", dir.current, tt.want)
+			}
+		})
+	}
+}
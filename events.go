@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types pushed to clients over /api/events
+const (
+	EventSessionCreated  = "session_created"
+	EventSessionUpdated  = "session_updated"
+	EventHistoryReloaded = "history_reloaded"
+)
+
+// ServerEvent is a change to the parsed history
+type ServerEvent struct {
+	Type    string   `json:"type"`
+	Session *Session `json:"session,omitempty"`
+}
+
+// EventHub fans server events out to connected Server-Sent Events clients
+type EventHub struct {
+	mu      sync.Mutex
+	clients map[chan ServerEvent]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{clients: make(map[chan ServerEvent]struct{})}
+}
+
+func (h *EventHub) subscribe() chan ServerEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan ServerEvent, 32)
+	h.clients[ch] = struct{}{}
+	return ch
+}
+
+func (h *EventHub) unsubscribe(ch chan ServerEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, ch)
+}
+
+// Publish sends events to every client. A client that is too slow to keep up
+// gets a history_reloaded event instead of the ones it missed.
+func (h *EventHub) Publish(events ...ServerEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.clients {
+		for _, event := range events {
+			select {
+			case ch <- event:
+			default:
+				// Drop the backlog and tell the client to reload everything
+				for len(ch) > 0 {
+					<-ch
+				}
+				ch <- ServerEvent{Type: EventHistoryReloaded}
+			}
+		}
+	}
+}
+
+// diffSessions returns session_created/session_updated events for the
+// sessions in current that are new or have grown compared to previous
+func diffSessions(previous, current []Session) []ServerEvent {
+	counts := make(map[string]int, len(previous))
+	for _, session := range previous {
+		counts[session.ID] = len(session.Commands)
+	}
+
+	var events []ServerEvent
+	for _, session := range current {
+		count, existed := counts[session.ID]
+		switch {
+		case !existed:
+			events = append(events, ServerEvent{Type: EventSessionCreated, Session: &session})
+		case count != len(session.Commands):
+			events = append(events, ServerEvent{Type: EventSessionUpdated, Session: &session})
+		}
+	}
+	return events
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	// Keep idle connections from being closed by proxies
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Warning: Failed to encode event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
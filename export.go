@@ -27,7 +27,7 @@ func (e *Exporter) ExportCSV(sessions []Session) (string, error) {
 	writer := csv.NewWriter(&buf)
 
 	// Write header
-	header := []string{"Session ID", "Command ID", "Timestamp", "Duration", "Command", "Directory", "Category", "Base Command"}
+	header := []string{"Session ID", "Command ID", "Timestamp", "Duration", "Command", "Directory", "Category", "Base Command", "Host", "Project"}
 	if err := writer.Write(header); err != nil {
 		return "", err
 	}
@@ -36,7 +36,7 @@ func (e *Exporter) ExportCSV(sessions []Session) (string, error) {
 	for _, session := range sessions {
 		for _, cmd := range session.Commands {
 			record := []string{
-				fmt.Sprintf("%d", session.ID),
+				session.ID,
 				fmt.Sprintf("%d", cmd.ID),
 				cmd.Timestamp.Format(time.RFC3339),
 				fmt.Sprintf("%d", cmd.Duration),
@@ -44,6 +44,8 @@ func (e *Exporter) ExportCSV(sessions []Session) (string, error) {
 				cmd.Directory,
 				string(cmd.Category),
 				cmd.BaseCommand,
+				cmd.Host,
+				cmd.Project,
 			}
 			if err := writer.Write(record); err != nil {
 				return "", err
@@ -66,7 +68,13 @@ func (e *Exporter) ExportMarkdown(sessions []Session) string {
 	buf.WriteString(fmt.Sprintf("Generated: %s\n\n", time.Now().Format(time.RFC1123)))
 
 	for _, session := range sessions {
-		buf.WriteString(fmt.Sprintf("## Session %d: %s\n\n", session.ID, session.Description))
+		buf.WriteString(fmt.Sprintf("## Session %d: %s\n\n", session.SequenceNumber, session.Description))
+		if session.Host != "" {
+			buf.WriteString(fmt.Sprintf("- **Host:** %s\n", session.Host))
+		}
+		if session.Project != "" {
+			buf.WriteString(fmt.Sprintf("- **Project:** %s\n", session.Project))
+		}
 		buf.WriteString(fmt.Sprintf("- **Start:** %s\n", session.StartTime.Format(time.RFC1123)))
 		buf.WriteString(fmt.Sprintf("- **End:** %s\n", session.EndTime.Format(time.RFC1123)))
 		buf.WriteString(fmt.Sprintf("- **Duration:** %s\n", session.Duration.Round(time.Second)))
@@ -75,8 +83,17 @@ func (e *Exporter) ExportMarkdown(sessions []Session) string {
 
 		if len(session.Categories) > 0 {
 			buf.WriteString("### Categories\n\n")
-			for cat, count := range session.Categories {
-				buf.WriteString(fmt.Sprintf("- %s: %d commands\n", cat, count))
+			categories := make([]CommandCategory, 0, len(session.Categories))
+			for cat := range session.Categories {
+				categories = append(categories, cat)
+			}
+			sortCategories(categories)
+			for _, cat := range categories {
+				indent := ""
+				if cat.IsSubcategory() {
+					indent = "  "
+				}
+				buf.WriteString(fmt.Sprintf("%s- %s: %.1f commands\n", indent, cat, session.Categories[cat]))
 			}
 			buf.WriteString("\n")
 		}
@@ -102,10 +119,11 @@ func (e *Exporter) ExportZshHistory(entries []HistoryEntry) string {
 
 	for _, entry := range entries {
 		// Format: : <timestamp>:<duration>;<command>
+		// Commands are metafied again so the output matches what zsh writes
 		buf.WriteString(fmt.Sprintf(": %d:%d;%s\n", 
 			entry.Timestamp.Unix(),
 			entry.Duration,
-			entry.Command,
+			metafy(entry.Command),
 		))
 	}
 
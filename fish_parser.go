@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fishRecord is one "- cmd:" entry from a fish history file
+type fishRecord struct {
+	offset    int64
+	command   string
+	timestamp int64
+	paths     []string
+}
+
+var (
+	fishWhenRegex  = regexp.MustCompile(`^\s+when:\s*(\d+)\s*$`)
+	fishPathsRegex = regexp.MustCompile(`^\s+paths:\s*$`)
+	fishPathRegex  = regexp.MustCompile(`^\s+- (.*)$`)
+)
+
+// parseFishHistory reads fish's YAML-like history file, where each record is
+// a "- cmd:" line followed by indented "when:" and "paths:" keys. Fish only
+// records arguments that were valid paths when the command ran, so the paths
+// are used to confirm cd targets and to attribute files to entries.
+func (p *Parser) parseFishHistory(r io.Reader, state *parseState) ([]HistoryEntry, error) {
+	var records []fishRecord
+	scanner := newHistoryScanner(r, state.offset)
+
+	var current *fishRecord
+	inPaths := false
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if matches := fishCommandRegex.FindStringSubmatch(line); len(matches) == 2 {
+			if current != nil {
+				records = append(records, *current)
+			}
+			current = &fishRecord{offset: scanner.lineStart, command: unescapeFish(matches[1])}
+			inPaths = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		if matches := fishWhenRegex.FindStringSubmatch(line); len(matches) == 2 {
+			current.timestamp, _ = strconv.ParseInt(matches[1], 10, 64)
+			inPaths = false
+		} else if fishPathsRegex.MatchString(line) {
+			inPaths = true
+		} else if matches := fishPathRegex.FindStringSubmatch(line); inPaths && len(matches) == 2 {
+			current.paths = append(current.paths, unescapeFish(matches[1]))
+		} else {
+			inPaths = false
+		}
+	}
+	if current != nil {
+		records = append(records, *current)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	state.offset = scanner.next
+
+	var entries []HistoryEntry
+	for _, rec := range records {
+		state.markEntry(rec.offset)
+
+		// Recorded paths are relative to the directory the command ran in
+		var paths []string
+		for _, path := range rec.paths {
+			paths = append(paths, p.resolveDirectory(state.dir.current, path))
+		}
+
+		expanded := p.aliases.Expand(rec.command)
+		p.trackDirectory(&state.dir, expanded, fishPathCheck(rec.paths))
+
+		entry := buildEntry(state.nextID, time.Unix(rec.timestamp, 0), 0, rec.command, expanded, state.dir.current)
+		entry.Paths = paths
+		entries = append(entries, entry)
+		state.nextID++
+	}
+
+	return entries, nil
+}
+
+// fishPathCheck treats a cd to a literal path that fish did not record as a
+// valid path as failed. Fish only records paths that existed.
+func fishPathCheck(paths []string) directoryCheck {
+	return func(target string) bool {
+		if !isLiteralPath(target) {
+			return true
+		}
+		for _, path := range paths {
+			if strings.TrimSuffix(path, "/") == strings.TrimSuffix(target, "/") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isLiteralPath reports whether target is a plain path rather than something
+// the shell expands (home, variables, globs, "cd -")
+func isLiteralPath(target string) bool {
+	if target == "" || strings.ContainsAny(target[:1], "~$-") {
+		return false
+	}
+	return !strings.ContainsAny(target, "*?{")
+}
+
+// unescapeFish reverses fish's history escaping of backslashes and newlines
+func unescapeFish(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFishHistory(t *testing.T) {
+	input := strings.Join([]string{
+		"- cmd: cd code/api",
+		"  when: 1700000000",
+		"  paths:",
+		"    - code/api",
+		"- cmd: cd missing",
+		"  when: 1700000005",
+		"- cmd: vim main.go",
+		"  when: 1700000010",
+		"  paths:",
+		"    - main.go",
+		`- cmd: echo one\ntwo`,
+		"  when: 1700000020",
+	}, "\n")
+
+	p := &Parser{config: &Config{HomeDir: "/home/test"}}
+	entries, err := p.parseFishHistory(strings.NewReader(input), p.newParseState())
+	if err != nil {
+		t.Fatalf("parseFishHistory() error = %v", err)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(entries))
+	}
+	if entries[0].Directory != "/home/test/code/api" {
+		t.Errorf("Expected directory /home/test/code/api, got %q", entries[0].Directory)
+	}
+	// cd to a path fish did not record as valid must not move the directory
+	if entries[1].Directory != "/home/test/code/api" {
+		t.Errorf("Failed cd changed directory to %q", entries[1].Directory)
+	}
+	if len(entries[2].Paths) != 1 || entries[2].Paths[0] != "/home/test/code/api/main.go" {
+		t.Errorf("Expected resolved path /home/test/code/api/main.go, got %v", entries[2].Paths)
+	}
+	if entries[3].Command != "echo one\ntwo" {
+		t.Errorf("Expected unescaped multiline command, got %q", entries[3].Command)
+	}
+	if entries[3].Timestamp.Unix() != 1700000020 {
+		t.Errorf("Expected timestamp 1700000020, got %d", entries[3].Timestamp.Unix())
+	}
+}
+
+func TestDetectHistoryFormat_Fish(t *testing.T) {
+	head := "- cmd: ls\n  when: 1700000000\n- cmd: git status\n  when: 1700000001\n"
+	if got := DetectHistoryFormat([]byte(head)); got != HistoryFormatFish {
+		t.Errorf("DetectHistoryFormat() = %q, want %q", got, HistoryFormatFish)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// Supported values for Config.HistoryFormat
+const (
+	HistoryFormatAuto  = "auto"
+	HistoryFormatZsh   = "zsh"
+	HistoryFormatBash  = "bash"
+	HistoryFormatFish  = "fish"
+	HistoryFormatAtuin = "atuin"
+)
+
+// sniffSize is how much of the history file is inspected to detect its format
+const sniffSize = 16 * 1024
+
+// Bash writes "#<epoch>" before each command when HISTTIMEFORMAT is set
+var bashTimestampRegex = regexp.MustCompile(`^#(\d+)\s*$`)
+
+// Fish starts each record with "- cmd: <command>"
+var fishCommandRegex = regexp.MustCompile(`^- cmd: ?(.*)$`)
+
+// DetectHistoryFormat guesses the shell that wrote a history file from its
+// first few kilobytes. Files with no recognizable markers are treated as
+// plain bash history; empty files default to zsh.
+func DetectHistoryFormat(head []byte) string {
+	if bytes.HasPrefix(head, []byte(sqliteMagic)) {
+		return HistoryFormatAtuin
+	}
+
+	zshLines := 0
+	bashLines := 0
+	fishLines := 0
+	otherLines := 0
+
+	for _, line := range bytes.Split(head, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+		switch {
+		case historyLineRegex.Match(line):
+			zshLines++
+		case bashTimestampRegex.Match(line):
+			bashLines++
+		case fishCommandRegex.Match(line):
+			fishLines++
+		default:
+			otherLines++
+		}
+	}
+
+	if fishLines > 0 && fishLines >= zshLines && fishLines >= bashLines {
+		return HistoryFormatFish
+	}
+	if zshLines > 0 && zshLines >= bashLines {
+		return HistoryFormatZsh
+	}
+	if bashLines > 0 || otherLines > 0 {
+		return HistoryFormatBash
+	}
+	return HistoryFormatZsh
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// zshHookSnippet records every command zsh runs in the hook log. preexec
+// notes the start time, command and working directory; precmd appends them
+// with the exit status and duration once the command has finished. The
+// timestamp is the start second, matching the one zsh writes to its history.
+const zshHookSnippet = `# history_viewer: log cwd, exit status and duration of every command
+# Add to ~/.zshrc: eval "$(history_viewer hook zsh)"
+zmodload zsh/datetime
+typeset -g _history_viewer_log=${HISTORY_VIEWER_LOG:-%s}
+typeset -g _history_viewer_start _history_viewer_cwd _history_viewer_cmd
+
+_history_viewer_json() {
+  local s=$1
+  s=${s//\\/\\\\}
+  s=${s//\"/\\\"}
+  s=${s//$'\n'/\\n}
+  s=${s//$'\r'/\\r}
+  s=${s//$'\t'/\\t}
+  REPLY="\"$s\""
+}
+
+_history_viewer_preexec() {
+  _history_viewer_start=$EPOCHREALTIME
+  _history_viewer_cwd=$PWD
+  _history_viewer_cmd=$1
+}
+
+_history_viewer_precmd() {
+  local exit_status=$?
+  [[ -n $_history_viewer_start ]] || return 0
+  local LC_ALL=C duration cmd cwd tty
+  printf -v duration '%%.3f' $(( EPOCHREALTIME - _history_viewer_start ))
+  _history_viewer_json "$_history_viewer_cmd"; cmd=$REPLY
+  _history_viewer_json "$_history_viewer_cwd"; cwd=$REPLY
+  _history_viewer_json "$TTY"; tty=$REPLY
+  print -r -- "{\"timestamp\":${_history_viewer_start%%%%.*},\"duration\":$duration,\"exit\":$exit_status,\"cwd\":$cwd,\"tty\":$tty,\"pid\":$$,\"command\":$cmd}" >>| $_history_viewer_log
+  _history_viewer_start=
+}
+
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _history_viewer_preexec
+add-zsh-hook precmd _history_viewer_precmd
+`
+
+// runHookCommand implements "history_viewer hook <shell>", which prints the
+// snippet to source from the shell's rc file
+func runHookCommand(args []string, config *Config) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: history_viewer hook zsh")
+	}
+
+	switch args[0] {
+	case "zsh":
+		return fmt.Sprintf(zshHookSnippet, shellQuote(config.HookLog)), nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q, only zsh is supported", args[0])
+	}
+}
+
+// shellQuote single-quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// HookRecord is one line of the log written by the shell hook
+type HookRecord struct {
+	Timestamp int64   `json:"timestamp"` // start of the command, in Unix seconds
+	Duration  float64 `json:"duration"`  // seconds
+	Exit      int     `json:"exit"`
+	Cwd       string  `json:"cwd"`
+	TTY       string  `json:"tty"`
+	PID       int     `json:"pid"`
+	Command   string  `json:"command"`
+}
+
+// terminal identifies the shell the command ran in: its tty and, since a tty
+// is reused by later shells, its process ID
+func (r HookRecord) terminal() string {
+	if r.TTY == "" {
+		return ""
+	}
+	if r.PID == 0 {
+		return r.TTY
+	}
+	return fmt.Sprintf("%s:%d", r.TTY, r.PID)
+}
+
+type hookKey struct {
+	timestamp int64
+	command   string
+}
+
+// HookLog holds the records of the hook log read so far
+type HookLog struct {
+	path    string
+	offset  int64
+	records map[hookKey][]HookRecord
+}
+
+// update reads the records appended since the last call and reports whether
+// there were any. A missing log means the hook isn't installed.
+func (l *HookLog) update(path string) (bool, error) {
+	if l.path != path {
+		*l = HookLog{path: path}
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < l.offset {
+		// Truncated, start over
+		*l = HookLog{path: path}
+	}
+	if info.Size() == l.offset {
+		return false, nil
+	}
+
+	if _, err := file.Seek(l.offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	if l.records == nil {
+		l.records = make(map[hookKey][]HookRecord)
+	}
+
+	added := false
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Leave a partly written line for the next update
+			break
+		}
+		if err != nil {
+			return added, err
+		}
+		l.offset += int64(len(line))
+
+		var record HookRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			continue
+		}
+		key := hookKey{record.Timestamp, normalizeHookCommand(record.Command)}
+		l.records[key] = append(l.records[key], record)
+		added = true
+	}
+
+	return added, nil
+}
+
+// apply copies the directory, exit status and terminal of matching hook
+// records onto entries that have none yet and returns how many entries it updated. zsh
+// may write the history line in the second before preexec runs, so records
+// up to a second later still match. Repeats of a command within the same
+// second are matched in order.
+func (l *HookLog) apply(entries []HistoryEntry) int {
+	if len(l.records) == 0 {
+		return 0
+	}
+
+	used := make(map[hookKey]int)
+	updated := 0
+	for i := range entries {
+		entry := &entries[i]
+		command := normalizeHookCommand(entry.Command)
+		for _, timestamp := range []int64{entry.Timestamp.Unix(), entry.Timestamp.Unix() + 1} {
+			key := hookKey{timestamp, command}
+			if used[key] >= len(l.records[key]) {
+				continue
+			}
+			record := l.records[key][used[key]]
+			used[key]++
+
+			if entry.ExitCode == nil {
+				exit := record.Exit
+				entry.ExitCode = &exit
+				entry.Directory = record.Cwd
+				if entry.Duration == 0 {
+					entry.Duration = int(record.Duration)
+				}
+				if entry.SessionHint == "" {
+					entry.SessionHint = record.terminal()
+				}
+				updated++
+			}
+			break
+		}
+	}
+	return updated
+}
+
+// normalizeHookCommand undoes the backslash zsh writes before each newline of
+// a multiline command in the history file, which the hook doesn't see. Runs
+// of whitespace are collapsed since HIST_REDUCE_BLANKS only applies to the
+// history file.
+func normalizeHookCommand(command string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(command, "\\\n", "\n")), " ")
+}
+
+// joinHookLog applies new hook records to entries. A history that didn't
+// change but gained hook records is reported as appended, since records are
+// written when recent commands finish.
+func (p *Parser) joinHookLog(hooks *HookLog, entries []HistoryEntry, result ParseResult) ([]HistoryEntry, ParseResult) {
+	if p.config.HookLog == "" {
+		return entries, result
+	}
+
+	added, err := hooks.update(p.config.HookLog)
+	if err != nil {
+		log.Printf("Warning: Failed to read hook log: %v", err)
+	}
+	if result == ParseUnchanged && !added {
+		return entries, result
+	}
+
+	if hooks.apply(entries) > 0 && result == ParseUnchanged {
+		result = ParseAppended
+	}
+	return entries, result
+}
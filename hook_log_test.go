@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHistory_HookLog(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".zsh_history")
+	hookPath := filepath.Join(tmpDir, "hook.jsonl")
+
+	history := ": 1700000000:0;pushd /srv/app\n: 1700000010:3;make test\n: 1700000020:0;echo a\\\nb\n: 1700000030:0;ls\n"
+	hooks := strings.Join([]string{
+		`{"timestamp":1700000000,"duration":0.01,"exit":0,"cwd":"/home/test","tty":"/dev/pts/1","pid":42,"command":"pushd /srv/app"}`,
+		`{"timestamp":1700000011,"duration":3.2,"exit":2,"cwd":"/srv/app","tty":"/dev/pts/1","pid":42,"command":"make test"}`,
+		`not json`,
+		`{"timestamp":1700000020,"duration":0,"exit":0,"cwd":"/srv/app","tty":"/dev/pts/1","pid":42,"command":"echo a\nb"}`,
+		`{"timestamp":1700000030,"duration":0,"exit":0,"cwd":"/srv`, // still being written
+	}, "\n")
+	if err := os.WriteFile(historyPath, []byte(history), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+	if err := os.WriteFile(hookPath, []byte(hooks), 0644); err != nil {
+		t.Fatalf("Failed to write hook log: %v", err)
+	}
+
+	p := &Parser{config: &Config{HistoryFile: historyPath, HomeDir: "/home/test", HookLog: hookPath}}
+	var cursors HistoryCursors
+	entries, _, err := p.ParseSources(&cursors, nil)
+	if err != nil {
+		t.Fatalf("ParseSources() error = %v", err)
+	}
+
+	// pushd isn't tracked by the parser, the hook knows better
+	if entries[1].Directory != "/srv/app" {
+		t.Errorf("Expected directory /srv/app from hook, got %q", entries[1].Directory)
+	}
+	if entries[1].ExitCode == nil || *entries[1].ExitCode != 2 {
+		t.Errorf("Expected exit code 2, got %v", entries[1].ExitCode)
+	}
+	if entries[2].ExitCode == nil {
+		t.Errorf("Multiline command was not joined to its hook record")
+	}
+	if entries[1].SessionHint != "/dev/pts/1:42" {
+		t.Errorf("Expected session hint /dev/pts/1:42 from hook, got %q", entries[1].SessionHint)
+	}
+	if entries[3].ExitCode != nil {
+		t.Errorf("Partly written hook record was applied")
+	}
+
+	// Finishing the last record updates the entries without a history change
+	f, err := os.OpenFile(hookPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open hook log: %v", err)
+	}
+	f.WriteString(`/app","tty":"/dev/pts/1","pid":42,"command":"ls"}` + "\n")
+	f.Close()
+
+	entries, result, err := p.ParseSources(&cursors, entries)
+	if err != nil || result != ParseAppended {
+		t.Fatalf("ParseSources() result=%v err=%v", result, err)
+	}
+	if entries[3].Directory != "/srv/app" || entries[3].ExitCode == nil {
+		t.Errorf("Completed hook record not applied: %+v", entries[3])
+	}
+}
+
+func TestRunHookCommand(t *testing.T) {
+	snippet, err := runHookCommand([]string{"zsh"}, &Config{HookLog: "/home/o'neil/hook.jsonl"})
+	if err != nil {
+		t.Fatalf("runHookCommand() error = %v", err)
+	}
+	if !strings.Contains(snippet, `${HISTORY_VIEWER_LOG:-'/home/o'\''neil/hook.jsonl'}`) {
+		t.Errorf("Snippet does not use the quoted log path:\n%s", snippet)
+	}
+	if strings.Contains(snippet, "%!") {
+		t.Errorf("Snippet has formatting errors:\n%s", snippet)
+	}
+
+	if _, err := runHookCommand([]string{"tcsh"}, &Config{}); err == nil {
+		t.Errorf("Expected an error for an unsupported shell")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// ParseResult describes what ParseHistoryIncremental had to do
+type ParseResult int
+
+const (
+	ParseUnchanged ParseResult = iota // nothing new in the file
+	ParseAppended                     // only the tail of the file was parsed
+	ParseFull                         // the whole file was parsed
+)
+
+// HistoryCursor remembers where the last parse of the history file stopped
+type HistoryCursor struct {
+	path    string
+	inode   uint64
+	format  string
+	state   parseState
+	tail    []byte    // raw bytes of the last entry, to detect in-place rewrites
+	modTime time.Time // last change of a database source
+}
+
+// Reset forces the next parse to read the whole file
+func (c *HistoryCursor) Reset() {
+	*c = HistoryCursor{}
+}
+
+// HistoryCursors holds a cursor and the parsed entries for each history source
+type HistoryCursors struct {
+	sources map[string]*sourceCursor // keyed by path
+	hooks   HookLog
+}
+
+type sourceCursor struct {
+	cursor  HistoryCursor
+	entries []HistoryEntry // entries of this source alone, IDs local to it
+}
+
+// Reset forces the next parse to read every source in full
+func (c *HistoryCursors) Reset() {
+	*c = HistoryCursors{}
+}
+
+func (c *HistoryCursors) get(path string) *sourceCursor {
+	if c.sources == nil {
+		c.sources = make(map[string]*sourceCursor)
+	}
+	sc, ok := c.sources[path]
+	if !ok {
+		sc = &sourceCursor{}
+		c.sources[path] = sc
+	}
+	return sc
+}
+
+// ParseSources brings entries up to date with every configured history
+// source and the shell hook log. A single source is parsed incrementally as
+// is. Entries from several sources are merged chronologically and renumbered,
+// so any change to one of them is reported as ParseFull. Every entry gets its
+// stable ID and project.
+func (p *Parser) ParseSources(cursors *HistoryCursors, entries []HistoryEntry) ([]HistoryEntry, ParseResult, error) {
+	entries, result, err := p.parseSources(cursors, entries)
+	if err != nil {
+		return nil, result, err
+	}
+	entries, result = p.joinHookLog(&cursors.hooks, entries, result)
+	if result != ParseUnchanged {
+		assignCommandIDs(entries)
+		p.projects.Assign(entries)
+		if hasContextRules() {
+			for i := range entries {
+				recategorize(&entries[i])
+			}
+		}
+	}
+	return entries, result, nil
+}
+
+func (p *Parser) parseSources(cursors *HistoryCursors, entries []HistoryEntry) ([]HistoryEntry, ParseResult, error) {
+	sources := p.config.Sources()
+	if len(sources) == 1 {
+		sc := cursors.get(sources[0].Path)
+		return p.ParseHistoryIncremental(sources[0], &sc.cursor, entries)
+	}
+
+	changed := entries == nil
+	var merged []HistoryEntry
+	for _, source := range sources {
+		sc := cursors.get(source.Path)
+		parsed, result, err := p.ParseHistoryIncremental(source, &sc.cursor, sc.entries)
+		if err != nil {
+			return nil, ParseFull, fmt.Errorf("%s: %w", source.Path, err)
+		}
+		if result != ParseUnchanged {
+			changed = true
+		}
+		sc.entries = parsed
+		merged = append(merged, parsed...)
+	}
+	if !changed {
+		return entries, ParseUnchanged, nil
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+	for i := range merged {
+		merged[i].ID = i + 1
+	}
+
+	return merged, ParseFull, nil
+}
+
+func setHost(entries []HistoryEntry, host string) {
+	for i := range entries {
+		entries[i].Host = host
+	}
+}
+
+// ParseHistoryIncremental brings entries up to date with the history file.
+// When the file has only grown since the cursor was taken, just the appended
+// bytes are parsed, starting again at the previous last entry in case it was
+// still being written. A different inode, a shrunken file or a changed last
+// entry (zsh rewrites the file when trimming to HISTSIZE) triggers a full
+// parse. The returned slice may share storage with entries.
+func (p *Parser) ParseHistoryIncremental(source HistorySource, cursor *HistoryCursor, entries []HistoryEntry) ([]HistoryEntry, ParseResult, error) {
+	file, err := os.Open(source.Path)
+	if err != nil {
+		return nil, ParseFull, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, ParseFull, err
+	}
+
+	if cursor.format == HistoryFormatAtuin && cursor.path == source.Path {
+		// A database can't be resumed at an offset, re-import it when it changes
+		if atuinModTime(source.Path, info).Equal(cursor.modTime) {
+			return entries, ParseUnchanged, nil
+		}
+	} else if cursor.canResume(source.Path, info, file) {
+		if info.Size() == cursor.state.offset {
+			return entries, ParseUnchanged, nil
+		}
+
+		state := cursor.state
+		keep := len(entries)
+		if state.lastID > 0 {
+			// Re-read the last entry, it may have grown
+			state.offset = state.lastOffset
+			state.nextID = state.lastID
+			state.dir = state.lastDir
+			keep = state.lastID - 1
+		}
+
+		if keep <= len(entries) {
+			tail, err := p.parseFrom(file, cursor.format, &state)
+			if err != nil {
+				return nil, ParseFull, err
+			}
+			setHost(tail, source.Host)
+			cursor.update(file, state)
+			return append(entries[:keep], tail...), ParseAppended, nil
+		}
+	}
+
+	format, err := resolveFormat(file, source.Format)
+	if err != nil {
+		return nil, ParseFull, err
+	}
+
+	if format == HistoryFormatAtuin {
+		modTime := atuinModTime(source.Path, info)
+		parsed, err := p.ParseAtuinHistory(source.Path)
+		if err != nil {
+			return nil, ParseFull, err
+		}
+		// Atuin records the host of every command, keep it unless the
+		// source is explicitly labelled
+		if source.Host != "" {
+			setHost(parsed, source.Host)
+		}
+		*cursor = HistoryCursor{
+			path:    source.Path,
+			inode:   fileInode(info),
+			format:  format,
+			modTime: modTime,
+		}
+		return parsed, ParseFull, nil
+	}
+
+	state := p.newParseState()
+	parsed, err := p.parseFrom(file, format, state)
+	if err != nil {
+		return nil, ParseFull, err
+	}
+	setHost(parsed, source.Host)
+
+	*cursor = HistoryCursor{
+		path:   source.Path,
+		inode:  fileInode(info),
+		format: format,
+	}
+	cursor.update(file, *state)
+
+	return parsed, ParseFull, nil
+}
+
+// canResume reports whether the file is the one the cursor was taken on and
+// has only been appended to since
+func (c *HistoryCursor) canResume(path string, info os.FileInfo, file *os.File) bool {
+	if c.format == "" || c.path != path || c.inode != fileInode(info) {
+		return false
+	}
+	if info.Size() < c.state.offset {
+		return false
+	}
+
+	current := make([]byte, len(c.tail))
+	if _, err := file.ReadAt(current, c.state.lastOffset); err != nil && err != io.EOF {
+		return false
+	}
+	return bytes.Equal(current, c.tail)
+}
+
+func (c *HistoryCursor) update(file *os.File, state parseState) {
+	c.state = state
+	c.tail = nil
+	if state.lastID == 0 {
+		return
+	}
+
+	tail := make([]byte, state.offset-state.lastOffset)
+	n, _ := file.ReadAt(tail, state.lastOffset)
+	c.tail = tail[:n]
+}
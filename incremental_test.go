@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseHistoryIncremental(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".zsh_history")
+
+	write := func(content string) {
+		if err := os.WriteFile(historyPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write history file: %v", err)
+		}
+	}
+	appendTo := func(content string) {
+		f, err := os.OpenFile(historyPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open history file: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("Failed to append to history file: %v", err)
+		}
+	}
+
+	p := &Parser{config: &Config{HistoryFile: historyPath, HomeDir: tmpDir}}
+	var cursor HistoryCursor
+	source := p.config.Sources()[0]
+
+	write(": 1700000000:0;cd /tmp\n: 1700000010:0;ls\n")
+	entries, result, err := p.ParseHistoryIncremental(source, &cursor, nil)
+	if err != nil || result != ParseFull || len(entries) != 2 {
+		t.Fatalf("Initial parse: result=%v entries=%d err=%v", result, len(entries), err)
+	}
+
+	entries, result, err = p.ParseHistoryIncremental(source, &cursor, entries)
+	if err != nil || result != ParseUnchanged || len(entries) != 2 {
+		t.Fatalf("Unchanged parse: result=%v entries=%d err=%v", result, len(entries), err)
+	}
+
+	appendTo(": 1700000020:0;git status\n: 1700000030:0;echo a\\\nb\n")
+	entries, result, err = p.ParseHistoryIncremental(source, &cursor, entries)
+	if err != nil || result != ParseAppended {
+		t.Fatalf("Append parse: result=%v err=%v", result, err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries after append, got %d", len(entries))
+	}
+	if entries[2].ID != 3 || entries[2].Directory != "/tmp" {
+		t.Errorf("Appended entry lost parser state: %+v", entries[2])
+	}
+	if entries[3].Command != "echo a\\\nb" {
+		t.Errorf("Unexpected multiline command %q", entries[3].Command)
+	}
+
+	full, err := p.ParseHistory()
+	if err != nil {
+		t.Fatalf("ParseHistory() error = %v", err)
+	}
+	for i := range full {
+		if full[i].Command != entries[i].Command || full[i].ID != entries[i].ID {
+			t.Errorf("Entry %d differs from full parse: %+v vs %+v", i, entries[i], full[i])
+		}
+	}
+
+	// zsh rewrites the file when trimming to HISTSIZE
+	write(": 1700000040:0;pwd\n")
+	entries, result, err = p.ParseHistoryIncremental(source, &cursor, entries)
+	if err != nil || result != ParseFull || len(entries) != 1 {
+		t.Fatalf("Rewrite parse: result=%v entries=%d err=%v", result, len(entries), err)
+	}
+}
+
+func TestParseSources_MultiHost(t *testing.T) {
+	tmpDir := t.TempDir()
+	laptop := filepath.Join(tmpDir, "laptop.zsh_history")
+	devbox := filepath.Join(tmpDir, "devbox.bash_history")
+	if err := os.WriteFile(laptop, []byte(": 1700000000:0;git status\n: 1700000120:0;git push\n"), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+	if err := os.WriteFile(devbox, []byte("#1700000060\nmake test\n"), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+
+	config := &Config{
+		HomeDir:        tmpDir,
+		SessionTimeout: 30 * time.Minute,
+		HistorySources: []HistorySource{
+			{Path: laptop, Host: "laptop"},
+			{Path: devbox, Host: "devbox"},
+		},
+	}
+	config.SessionHeuristics.MinCommandsPerSession = 1
+	p := &Parser{config: config}
+
+	var cursors HistoryCursors
+	entries, result, err := p.ParseSources(&cursors, nil)
+	if err != nil || result != ParseFull {
+		t.Fatalf("ParseSources() result=%v err=%v", result, err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	wantHosts := []string{"laptop", "devbox", "laptop"}
+	for i, entry := range entries {
+		if entry.ID != i+1 || entry.Host != wantHosts[i] {
+			t.Errorf("Entry %d: got ID %d host %q, want ID %d host %q", i, entry.ID, entry.Host, i+1, wantHosts[i])
+		}
+	}
+
+	if _, result, _ := p.ParseSources(&cursors, entries); result != ParseUnchanged {
+		t.Errorf("Expected unchanged sources, got %v", result)
+	}
+
+	index, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex() error = %v", err)
+	}
+	sessions := p.GroupIntoSessions(entries, index)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected one session per host, got %d", len(sessions))
+	}
+	for _, session := range sessions {
+		for _, cmd := range session.Commands {
+			if cmd.Host != session.Host {
+				t.Errorf("Session on %q contains a command from %q", session.Host, cmd.Host)
+			}
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// llmClassifyBatchSize is how many commands one prompt asks about
+const llmClassifyBatchSize = 25
+
+// llmClassifyInterval is how often the background job looks for new
+// uncategorized commands
+const llmClassifyInterval = 15 * time.Minute
+
+// ClassificationStatus is where a suggested category is in review
+type ClassificationStatus string
+
+const (
+	ClassificationSuggested ClassificationStatus = "suggested"
+	ClassificationAccepted  ClassificationStatus = "accepted"
+	ClassificationRejected  ClassificationStatus = "rejected"
+)
+
+// CommandClassification is the category a model suggested for a base command
+// that no rule categorizes. Once accepted, the command is categorized by it.
+type CommandClassification struct {
+	BaseCommand  string               `json:"base_command"`
+	Example      string               `json:"example"`   // a command line using it, as shown to the model
+	Count        int                  `json:"count"`     // times it was run when it was classified
+	Suggested    CommandCategory      `json:"suggested"` // the model's answer
+	Category     CommandCategory      `json:"category"`  // the suggestion, or the correction made on review
+	Model        string               `json:"model"`
+	Status       ClassificationStatus `json:"status"`
+	ClassifiedAt time.Time            `json:"classified_at"`
+	ReviewedAt   *time.Time           `json:"reviewed_at,omitempty"`
+}
+
+// ClassificationCache stores the classifications of uncategorized commands,
+// so that each is only asked about once and reviews are kept
+type ClassificationCache struct {
+	mu              sync.RWMutex
+	classifications map[string]*CommandClassification // base command -> classification
+	filePath        string
+}
+
+// NewClassificationCache loads the cache from configDir. When the file
+// can't be read, the cache starts empty and the error is returned with it.
+func NewClassificationCache(configDir string) (*ClassificationCache, error) {
+	cache := &ClassificationCache{
+		classifications: make(map[string]*CommandClassification),
+		filePath:        filepath.Join(configDir, "llm_categories.json"),
+	}
+	if err := cache.Load(); err != nil && !os.IsNotExist(err) {
+		return cache, fmt.Errorf("failed to load command classifications: %w", err)
+	}
+	return cache, nil
+}
+
+// Load reads the cache from disk
+func (c *ClassificationCache) Load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.filePath)
+	if err != nil {
+		return err
+	}
+	var classifications []*CommandClassification
+	if err := json.Unmarshal(data, &classifications); err != nil {
+		return fmt.Errorf("failed to parse command classifications: %w", err)
+	}
+	c.classifications = make(map[string]*CommandClassification)
+	for _, classification := range classifications {
+		c.classifications[classification.BaseCommand] = classification
+	}
+	return nil
+}
+
+// Save writes the cache to disk
+func (c *ClassificationCache) Save() error {
+	data, err := json.MarshalIndent(c.List(""), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal command classifications: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(c.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write command classifications: %w", err)
+	}
+	return nil
+}
+
+// List returns the classifications with status, or all of them when status
+// is empty, most run first
+func (c *ClassificationCache) List(status ClassificationStatus) []CommandClassification {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := make([]CommandClassification, 0, len(c.classifications))
+	for _, classification := range c.classifications {
+		if status == "" || classification.Status == status {
+			list = append(list, *classification)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].BaseCommand < list[j].BaseCommand
+	})
+	return list
+}
+
+// Has reports whether a base command was classified already
+func (c *ClassificationCache) Has(base string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.classifications[base]
+	return ok
+}
+
+// Add stores new suggestions. Commands classified already are kept as they are.
+func (c *ClassificationCache) Add(classifications []CommandClassification) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range classifications {
+		if _, ok := c.classifications[classifications[i].BaseCommand]; !ok {
+			c.classifications[classifications[i].BaseCommand] = &classifications[i]
+		}
+	}
+}
+
+// Review accepts or rejects the classification of a base command. An
+// accepted classification takes category when it is set, to correct the
+// model's suggestion. The category must be known (see knownCategory).
+func (c *ClassificationCache) Review(base string, accept bool, category CommandCategory) (CommandClassification, error) {
+	if category != "" {
+		known, ok := knownCategory(category)
+		if !ok {
+			return CommandClassification{}, fmt.Errorf("unknown category %q", category)
+		}
+		category = known
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	classification, ok := c.classifications[base]
+	if !ok {
+		return CommandClassification{}, fmt.Errorf("no classification for %q", base)
+	}
+	now := time.Now()
+	classification.ReviewedAt = &now
+	classification.Status = ClassificationRejected
+	if accept {
+		classification.Status = ClassificationAccepted
+		if category != "" {
+			classification.Category = category
+		}
+	}
+	return *classification, nil
+}
+
+// Accepted returns the categories of the accepted classifications
+func (c *ClassificationCache) Accepted() map[string]CommandCategory {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	accepted := make(map[string]CommandCategory)
+	for base, classification := range c.classifications {
+		if classification.Status == ClassificationAccepted && classification.Category != CategoryOther {
+			accepted[base] = classification.Category
+		}
+	}
+	return accepted
+}
+
+// uncategorizedCommand is a base command no rule categorizes
+type uncategorizedCommand struct {
+	base    string
+	example string
+	count   int
+}
+
+// uncategorizedCommands returns the distinct base commands of the segments
+// in the other category that the cache doesn't know yet, most run first
+func uncategorizedCommands(entries []HistoryEntry, cache *ClassificationCache) []uncategorizedCommand {
+	byBase := make(map[string]*uncategorizedCommand)
+	var commands []*uncategorizedCommand
+	for i := range entries {
+		for _, segment := range commandSegments(&entries[i]) {
+			if segment.Category != CategoryOther || segment.BaseCommand == "" {
+				continue
+			}
+			base := path.Base(segment.BaseCommand)
+			if cache.Has(base) {
+				continue
+			}
+			command, ok := byBase[base]
+			if !ok {
+				command = &uncategorizedCommand{base: base, example: segment.Command}
+				byBase[base] = command
+				commands = append(commands, command)
+			}
+			command.count++
+		}
+	}
+
+	result := make([]uncategorizedCommand, len(commands))
+	for i, command := range commands {
+		result[i] = *command
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].count > result[j].count
+	})
+	return result
+}
+
+// textGenerator answers prompts, as OllamaClient does
+type textGenerator interface {
+	Generate(prompt string) (string, error)
+}
+
+// classifyCommands asks a model for the categories of a batch of commands.
+// Answers outside categories are dropped.
+func classifyCommands(generator textGenerator, commands []uncategorizedCommand, categories []CommandCategory) (map[string]CommandCategory, error) {
+	response, err := generator.Generate(classificationPrompt(commands, categories))
+	if err != nil {
+		return nil, err
+	}
+	return parseClassifications(response, categories)
+}
+
+func classificationPrompt(commands []uncategorizedCommand, categories []CommandCategory) string {
+	var b strings.Builder
+	b.WriteString("Classify each of these shell commands into one of these categories: ")
+	for i, category := range categories {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(string(category))
+	}
+	b.WriteString(", other.\n\nCommands, each with an example of how it was run:\n")
+	for _, command := range commands {
+		fmt.Fprintf(&b, "- %s: %s\n", command.base, command.example)
+	}
+	b.WriteString("\nAnswer with only a JSON object mapping each command to its category, " +
+		`like {"terraform": "system-admin"}. Use "other" when no category fits or you don't know the command.`)
+	return b.String()
+}
+
+// parseClassifications reads the JSON object in a model's answer, which may
+// be wrapped in prose or a code block
+func parseClassifications(response string, categories []CommandCategory) (map[string]CommandCategory, error) {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object in answer: %s", truncateForLog(response, 200))
+	}
+	var answers map[string]string
+	if err := json.Unmarshal([]byte(response[start:end+1]), &answers); err != nil {
+		return nil, fmt.Errorf("failed to parse answer: %w", err)
+	}
+
+	known := map[CommandCategory]bool{CategoryOther: true}
+	for _, category := range categories {
+		known[category] = true
+	}
+	result := make(map[string]CommandCategory)
+	for base, answer := range answers {
+		category := CommandCategory(strings.ToLower(strings.TrimSpace(answer)))
+		if known[category] {
+			result[base] = category
+		}
+	}
+	return result, nil
+}
+
+// knownCategory normalizes a category given by hand and reports whether it
+// is other, one of knownCategories, or a subcategory of one written
+// parent/sub
+func knownCategory(category CommandCategory) (CommandCategory, bool) {
+	category = CommandCategory(strings.ToLower(strings.TrimSpace(string(category))))
+	parent, sub, hasSub := strings.Cut(string(category), "/")
+	if hasSub && (sub == "" || strings.ContainsAny(sub, "/ ")) {
+		return category, false
+	}
+	if CommandCategory(parent) == CategoryOther {
+		return category, !hasSub
+	}
+	for _, known := range knownCategories() {
+		if known == CommandCategory(parent) {
+			return category, true
+		}
+	}
+	return category, false
+}
+
+// classifyUncategorized asks the model about every command no rule or
+// earlier classification covers, in batches, and saves the suggestions. It
+// returns how many commands were classified.
+func (s *Server) classifyUncategorized() (int, error) {
+	s.classifyMu.Lock()
+	defer s.classifyMu.Unlock()
+
+	s.mu.RLock()
+	pending := uncategorizedCommands(s.entries, s.classifications)
+	generator, model := s.ollama, s.config.OllamaModel
+	s.mu.RUnlock()
+
+	categories := knownCategories()
+	classified := 0
+	for start := 0; start < len(pending); start += llmClassifyBatchSize {
+		batch := pending[start:min(start+llmClassifyBatchSize, len(pending))]
+		answers, err := classifyCommands(generator, batch, categories)
+		if err != nil {
+			return classified, err
+		}
+
+		now := time.Now()
+		var suggestions []CommandClassification
+		for _, command := range batch {
+			category, ok := answers[command.base]
+			if !ok {
+				continue
+			}
+			suggestions = append(suggestions, CommandClassification{
+				BaseCommand:  command.base,
+				Example:      command.example,
+				Count:        command.count,
+				Suggested:    category,
+				Category:     category,
+				Model:        model,
+				Status:       ClassificationSuggested,
+				ClassifiedAt: now,
+			})
+		}
+		s.classifications.Add(suggestions)
+		if err := s.classifications.Save(); err != nil {
+			return classified, err
+		}
+		classified += len(suggestions)
+	}
+	return classified, nil
+}
+
+// startClassifier starts runClassifier unless it is running already. Caller
+// must hold the write lock.
+func (s *Server) startClassifier() {
+	if !s.classifierRunning {
+		s.classifierRunning = true
+		go s.runClassifier()
+	}
+}
+
+// runClassifier classifies uncategorized commands in the background, every
+// llmClassifyInterval and whenever woken through classifyWake, until
+// classify_other_commands is turned off
+func (s *Server) runClassifier() {
+	ticker := time.NewTicker(llmClassifyInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		if !s.config.ClassifyOtherCommands {
+			s.classifierRunning = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		if n, err := s.classifyUncategorized(); err != nil {
+			log.Printf("Warning: Failed to classify uncategorized commands: %v", err)
+		} else if n > 0 {
+			log.Printf("Classified %d uncategorized commands, waiting for review", n)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.classifyWake:
+		}
+	}
+}
+
+// applyClassifications makes the accepted classifications category rules and
+// categorizes every command again. Caller must hold the write lock.
+func (s *Server) applyClassifications() {
+	SetLearnedCategories(s.classifications.Accepted())
+	for i := range s.entries {
+		recategorize(&s.entries[i])
+	}
+	s.regroupSessions()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGenerator answers every prompt with response
+type fakeGenerator struct {
+	response string
+	prompts  []string
+}
+
+func (g *fakeGenerator) Generate(prompt string) (string, error) {
+	g.prompts = append(g.prompts, prompt)
+	return g.response, nil
+}
+
+func TestUncategorizedCommands(t *testing.T) {
+	cache, err := NewClassificationCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Add([]CommandClassification{{BaseCommand: "known", Status: ClassificationRejected}})
+
+	var entries []HistoryEntry
+	for i, command := range []string{"terraform plan", "git status", "terraform apply", "/usr/local/bin/known", "ls | jq .name"} {
+		entries = append(entries, buildEntry(i+1, time.Unix(1700000000, 0), 0, command, command, "/src"))
+	}
+
+	got := uncategorizedCommands(entries, cache)
+	want := []uncategorizedCommand{{"terraform", "terraform plan", 2}, {"jq", "jq .name", 1}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("uncategorizedCommands() = %v, want %v", got, want)
+	}
+}
+
+func TestClassifyCommands(t *testing.T) {
+	generator := &fakeGenerator{response: "Sure! Here you go:\n```json\n" +
+		`{"terraform": "System-Admin", "jq": "search", "frob": "wizardry"}` + "\n```"}
+	commands := []uncategorizedCommand{{"terraform", "terraform plan", 2}, {"jq", "jq .name", 1}, {"frob", "frob", 1}}
+
+	got, err := classifyCommands(generator, commands, knownCategories())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]CommandCategory{"terraform": CategorySystemAdmin, "jq": CategorySearch}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("classifyCommands() = %v, want %v", got, want)
+	}
+	if len(generator.prompts) != 1 || !strings.Contains(generator.prompts[0], "- terraform: terraform plan") ||
+		!strings.Contains(generator.prompts[0], "version-control, build") {
+		t.Errorf("Prompt = %q", generator.prompts)
+	}
+
+	generator.response = "I don't know these commands."
+	if _, err := classifyCommands(generator, commands, knownCategories()); err == nil {
+		t.Errorf("Expected an error for an answer without JSON")
+	}
+}
+
+func TestClassificationReview(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := NewClassificationCache(dir)
+	cache.Add([]CommandClassification{
+		{BaseCommand: "terraform", Suggested: CategorySystemAdmin, Category: CategorySystemAdmin, Status: ClassificationSuggested, Count: 2},
+		{BaseCommand: "jq", Suggested: CategorySearch, Category: CategorySearch, Status: ClassificationSuggested, Count: 5},
+		{BaseCommand: "frob", Suggested: CategoryNetwork, Category: CategoryNetwork, Status: ClassificationSuggested},
+	})
+
+	for _, category := range []CommandCategory{"infra", "version control", "build/", "other/thing"} {
+		if _, err := cache.Review("terraform", true, category); err == nil {
+			t.Errorf("Review with category %q succeeded, want an error", category)
+		}
+	}
+	if _, err := cache.Review("terraform", true, " System-Admin/Infra"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Review("jq", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Review("frob", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Review("missing", true, ""); err == nil {
+		t.Errorf("Expected an error reviewing an unknown command")
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewClassificationCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reloaded.List(ClassificationAccepted); len(list) != 2 || list[0].BaseCommand != "jq" {
+		t.Errorf("Accepted after reload = %+v", list)
+	}
+
+	SetLearnedCategories(reloaded.Accepted())
+	defer SetLearnedCategories(nil)
+	tests := []struct {
+		command string
+		want    CommandCategory
+	}{
+		{"terraform plan", CategorySystemAdmin},
+		{"curl -s api | jq .", CategoryNetwork},
+		{"jq .name data.json", CategorySearch},
+		{"frob", CategoryOther},
+		{"git status", CategoryVCS},
+	}
+	for _, tt := range tests {
+		if got := CategorizeCommand(tt.command); got != tt.want {
+			t.Errorf("CategorizeCommand(%q) = %s, want %s", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestStartClassifier(t *testing.T) {
+	cache, err := NewClassificationCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{}
+	s := &Server{config: config, classifications: cache, classifyWake: make(chan struct{}, 1)}
+
+	running := func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.classifierRunning
+	}
+	waitFor := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); running() != want; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Classifier running = %v, want %v", !want, want)
+			}
+		}
+	}
+
+	// Turned on after start, as PUT /api/config does
+	s.mu.Lock()
+	config.ClassifyOtherCommands = true
+	s.startClassifier()
+	s.startClassifier()
+	s.mu.Unlock()
+	waitFor(true)
+
+	// Turned off, it stops when next woken
+	s.mu.Lock()
+	config.ClassifyOtherCommands = false
+	s.mu.Unlock()
+	s.classifyWake <- struct{}{}
+	waitFor(false)
+}
@@ -2,12 +2,47 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hook" {
+		config, err := LoadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		snippet, err := runHookCommand(os.Args[2:], config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(snippet)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "segment" {
+		config, err := LoadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		if err := runSegmentCommand(os.Args[2:], config, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fit-timeout" {
+		config, err := LoadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		if err := runFitTimeoutCommand(os.Args[2:], config, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	portFlag := flag.Int("port", 0, "Port to run the server on")
-	historyFileFlag := flag.String("history", "", "Path to zsh history file")
+	historyFileFlag := flag.String("history", "", "Path to shell history file (zsh, bash or fish)")
 	uiFlag := flag.String("ui", "web", "UI mode: 'web' or 'native'")
 	flag.Parse()
 
@@ -23,9 +58,16 @@ func main() {
 	}
 	if *historyFileFlag != "" {
 		config.HistoryFile = *historyFileFlag
+		config.HistorySources = nil
 	}
 
-	log.Printf("History file: %s", config.HistoryFile)
+	for _, source := range config.Sources() {
+		if source.Host != "" {
+			log.Printf("History file: %s (%s, host %s)", source.Path, source.Format, source.Host)
+		} else {
+			log.Printf("History file: %s (%s)", source.Path, source.Format)
+		}
+	}
 	log.Printf("Session timeout: %v", config.SessionTimeout)
 	log.Printf("Ollama URL: %s", config.OllamaURL)
 	log.Printf("Ollama Model: %s", config.OllamaModel)
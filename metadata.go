@@ -5,13 +5,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// metadataVersion is the layout of the metadata file. Version 0 files used
+// numeric target IDs: the line ordinal for commands and the sequence number
+// for sessions. Version 1 keyed commands by stable ID, and version 2 does the
+// same for sessions.
+const metadataVersion = 2
+
 type MetadataStore struct {
+	Version          int                        `json:"version"`
 	Notes            map[string]Note            `json:"notes"`             // key: note ID
 	Tags             map[string]Tag             `json:"tags"`              // key: tag ID
 	SessionMetadatas map[string]SessionMetadata `json:"session_metadatas"` // key: metadata ID
@@ -27,6 +35,7 @@ func NewMetadataStore() (*MetadataStore, error) {
 
 	filePath := filepath.Join(homeDir, ".history_viewer_metadata.json")
 	store := &MetadataStore{
+		Version:          metadataVersion,
 		Notes:            make(map[string]Note),
 		Tags:             make(map[string]Tag),
 		SessionMetadatas: make(map[string]SessionMetadata),
@@ -50,9 +59,110 @@ func (m *MetadataStore) load() error {
 		return err
 	}
 
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	if header.Version == 0 {
+		return m.loadLegacy(data)
+	}
+
 	return json.Unmarshal(data, m)
 }
 
+// loadLegacy reads a version 0 file. Its numeric target IDs are kept as
+// strings until Migrate maps them to stable IDs.
+func (m *MetadataStore) loadLegacy(data []byte) error {
+	// The outer target_id shadows the string one of the embedded type
+	var legacy struct {
+		Notes map[string]struct {
+			Note
+			TargetID int `json:"target_id"`
+		} `json:"notes"`
+		Tags map[string]struct {
+			Tag
+			TargetID int `json:"target_id"`
+		} `json:"tags"`
+		SessionMetadatas map[string]struct {
+			SessionMetadata
+			TargetID int `json:"target_id"`
+		} `json:"session_metadatas"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	m.Version = 0
+	for id, l := range legacy.Notes {
+		l.Note.TargetID = strconv.Itoa(l.TargetID)
+		m.Notes[id] = l.Note
+	}
+	for id, l := range legacy.Tags {
+		l.Tag.TargetID = strconv.Itoa(l.TargetID)
+		m.Tags[id] = l.Tag
+	}
+	for id, l := range legacy.SessionMetadatas {
+		l.SessionMetadata.TargetID = strconv.Itoa(l.TargetID)
+		m.SessionMetadatas[id] = l.SessionMetadata
+	}
+	return nil
+}
+
+// Migrate re-keys targets saved by older versions to stable IDs: commands
+// from line ordinals through entries, then sessions from sequence numbers
+// through the session index. Both must still be as they were when the targets
+// were saved, so it runs before new sessions are grouped. Targets with no
+// match are left as they are. It does nothing once the file is up to date.
+func (m *MetadataStore) Migrate(entries []HistoryEntry, sessionIndex *SessionIndex) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Version >= metadataVersion {
+		return nil
+	}
+
+	if m.Version < 1 {
+		stableIDs := make(map[string]string, len(entries))
+		for _, entry := range entries {
+			stableIDs[strconv.Itoa(entry.ID)] = entry.StableID
+		}
+		m.retarget(TargetCommand, stableIDs)
+		m.Version = 1
+	}
+
+	if m.Version < 2 && sessionIndex != nil {
+		m.retarget(TargetSession, sessionIndex.IDsBySequenceNumber())
+		m.Version = 2
+	}
+
+	return m.save()
+}
+
+// retarget moves the notes, tags and metadata of targetType from old target
+// IDs to new ones. Caller must hold the write lock.
+func (m *MetadataStore) retarget(targetType TargetType, ids map[string]string) {
+	for id, note := range m.Notes {
+		if newID, ok := ids[note.TargetID]; ok && note.TargetType == targetType {
+			note.TargetID = newID
+			m.Notes[id] = note
+		}
+	}
+	for id, tag := range m.Tags {
+		if newID, ok := ids[tag.TargetID]; ok && tag.TargetType == targetType {
+			tag.TargetID = newID
+			m.Tags[id] = tag
+		}
+	}
+	for id, meta := range m.SessionMetadatas {
+		if newID, ok := ids[meta.TargetID]; ok && meta.TargetType == targetType {
+			meta.TargetID = newID
+			m.SessionMetadatas[id] = meta
+		}
+	}
+}
+
 func (m *MetadataStore) save() error {
 	// Note: Caller must hold the lock (write lock)
 	data, err := json.MarshalIndent(m, "", "  ")
@@ -65,7 +175,7 @@ func (m *MetadataStore) save() error {
 
 // Note operations
 
-func (m *MetadataStore) AddNote(targetType TargetType, targetID int, text string) (*Note, error) {
+func (m *MetadataStore) AddNote(targetType TargetType, targetID string, text string) (*Note, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -120,7 +230,7 @@ func (m *MetadataStore) DeleteNote(noteID string) error {
 	return m.save()
 }
 
-func (m *MetadataStore) GetNotesForTarget(targetType TargetType, targetID int) []Note {
+func (m *MetadataStore) GetNotesForTarget(targetType TargetType, targetID string) []Note {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -136,7 +246,7 @@ func (m *MetadataStore) GetNotesForTarget(targetType TargetType, targetID int) [
 
 // Tag operations (now just keywords)
 
-func (m *MetadataStore) AddTag(targetType TargetType, targetID int, keyword string) (*Tag, error) {
+func (m *MetadataStore) AddTag(targetType TargetType, targetID string, keyword string) (*Tag, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -173,7 +283,7 @@ func (m *MetadataStore) DeleteTag(tagID string) error {
 	return m.save()
 }
 
-func (m *MetadataStore) GetTagsForTarget(targetType TargetType, targetID int) []Tag {
+func (m *MetadataStore) GetTagsForTarget(targetType TargetType, targetID string) []Tag {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -189,7 +299,7 @@ func (m *MetadataStore) GetTagsForTarget(targetType TargetType, targetID int) []
 
 // SessionMetadata operations
 
-func (m *MetadataStore) SetSessionMetadata(targetType TargetType, targetID int, colorCode string, starRating int) (*SessionMetadata, error) {
+func (m *MetadataStore) SetSessionMetadata(targetType TargetType, targetID string, colorCode string, starRating int) (*SessionMetadata, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -233,7 +343,7 @@ func (m *MetadataStore) SetSessionMetadata(targetType TargetType, targetID int,
 	return &metadata, nil
 }
 
-func (m *MetadataStore) GetSessionMetadata(targetType TargetType, targetID int) *SessionMetadata {
+func (m *MetadataStore) GetSessionMetadata(targetType TargetType, targetID string) *SessionMetadata {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -246,6 +356,141 @@ func (m *MetadataStore) GetSessionMetadata(targetType TargetType, targetID int)
 	return nil
 }
 
+// CopyTarget gives target to copies of the notes and tags of target from,
+// and its color and rating unless to has its own. Used when a session is
+// split, so both halves keep what was said about the whole.
+func (m *MetadataStore) CopyTarget(targetType TargetType, from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if from == to {
+		return nil
+	}
+
+	for _, note := range m.Notes {
+		if note.TargetType == targetType && note.TargetID == from {
+			note.ID = uuid.New().String()
+			note.TargetID = to
+			m.Notes[note.ID] = note
+		}
+	}
+	for _, tag := range m.Tags {
+		if tag.TargetType == targetType && tag.TargetID == from {
+			tag.ID = uuid.New().String()
+			tag.TargetID = to
+			m.Tags[tag.ID] = tag
+		}
+	}
+	if meta := m.findSessionMetadata(targetType, from); meta != nil && m.findSessionMetadata(targetType, to) == nil {
+		meta.ID = uuid.New().String()
+		meta.TargetID = to
+		m.SessionMetadatas[meta.ID] = *meta
+	}
+
+	return m.save()
+}
+
+// MoveTarget moves the notes and tags of target from to target to, along
+// with its color and rating unless to has its own. Used when sessions are
+// merged. Notes and tags to already has, such as the copies CopyTarget made
+// when the sessions were split, are dropped instead of moved.
+func (m *MetadataStore) MoveTarget(targetType TargetType, from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if from == to {
+		return nil
+	}
+
+	texts := make(map[string]bool)
+	for _, note := range m.Notes {
+		if note.TargetType == targetType && note.TargetID == to {
+			texts[note.Text] = true
+		}
+	}
+	for id, note := range m.Notes {
+		if note.TargetType == targetType && note.TargetID == from && texts[note.Text] {
+			delete(m.Notes, id)
+		}
+	}
+	keywords := make(map[string]bool)
+	for _, tag := range m.Tags {
+		if tag.TargetType == targetType && tag.TargetID == to {
+			keywords[tag.Keyword] = true
+		}
+	}
+	for id, tag := range m.Tags {
+		if tag.TargetType == targetType && tag.TargetID == from && keywords[tag.Keyword] {
+			delete(m.Tags, id)
+		}
+	}
+
+	if meta := m.findSessionMetadata(targetType, from); meta != nil && m.findSessionMetadata(targetType, to) != nil {
+		delete(m.SessionMetadatas, meta.ID)
+	}
+	m.retarget(targetType, map[string]string{from: to})
+
+	return m.save()
+}
+
+// findSessionMetadata returns a copy of the metadata of a target. Caller must
+// hold the lock.
+func (m *MetadataStore) findSessionMetadata(targetType TargetType, targetID string) *SessionMetadata {
+	for _, meta := range m.SessionMetadatas {
+		if meta.TargetType == targetType && meta.TargetID == targetID {
+			return &meta
+		}
+	}
+	return nil
+}
+
+// Annotations returns the notes and tags of every target, by target ID
+func (m *MetadataStore) Annotations() (map[string][]Note, map[string][]Tag) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	notes := make(map[string][]Note)
+	for _, note := range m.Notes {
+		notes[note.TargetID] = append(notes[note.TargetID], note)
+	}
+	tags := make(map[string][]Tag)
+	for _, tag := range m.Tags {
+		tags[tag.TargetID] = append(tags[tag.TargetID], tag)
+	}
+	return notes, tags
+}
+
+// SessionRatings returns the color and rating of every session that has
+// them, by session ID
+func (m *MetadataStore) SessionRatings() map[string]SessionMetadata {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ratings := make(map[string]SessionMetadata)
+	for _, meta := range m.SessionMetadatas {
+		if meta.TargetType == TargetSession {
+			ratings[meta.TargetID] = meta
+		}
+	}
+	return ratings
+}
+
+// NoteTarget returns the target of a note
+func (m *MetadataStore) NoteTarget(noteID string) (TargetType, string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	note, ok := m.Notes[noteID]
+	return note.TargetType, note.TargetID, ok
+}
+
+// TagTarget returns the target of a tag
+func (m *MetadataStore) TagTarget(tagID string) (TargetType, string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tag, ok := m.Tags[tagID]
+	return tag.TargetType, tag.TargetID, ok
+}
+
 // Merge metadata into sessions and commands
 
 func (m *MetadataStore) MergeIntoSessions(sessions []Session) []Session {
@@ -253,14 +498,14 @@ func (m *MetadataStore) MergeIntoSessions(sessions []Session) []Session {
 	defer m.mu.RUnlock()
 
 	for i := range sessions {
-		// Add session notes, tags, and metadata using SequenceNumber as targetID
-		sessions[i].Notes = m.GetNotesForTarget(TargetSession, sessions[i].SequenceNumber)
-		sessions[i].Tags = m.GetTagsForTarget(TargetSession, sessions[i].SequenceNumber)
-		sessions[i].Metadata = m.GetSessionMetadata(TargetSession, sessions[i].SequenceNumber)
+		// Add session notes, tags, and metadata using the stable ID as targetID
+		sessions[i].Notes = m.GetNotesForTarget(TargetSession, sessions[i].ID)
+		sessions[i].Tags = m.GetTagsForTarget(TargetSession, sessions[i].ID)
+		sessions[i].Metadata = m.GetSessionMetadata(TargetSession, sessions[i].ID)
 
 		// Add command notes and tags
 		for j := range sessions[i].Commands {
-			cmdID := sessions[i].Commands[j].ID
+			cmdID := sessions[i].Commands[j].StableID
 			sessions[i].Commands[j].Notes = m.GetNotesForTarget(TargetCommand, cmdID)
 			sessions[i].Commands[j].Tags = m.GetTagsForTarget(TargetCommand, cmdID)
 		}
@@ -274,8 +519,8 @@ func (m *MetadataStore) MergeIntoCommands(commands []HistoryEntry) []HistoryEntr
 	defer m.mu.RUnlock()
 
 	for i := range commands {
-		commands[i].Notes = m.GetNotesForTarget(TargetCommand, commands[i].ID)
-		commands[i].Tags = m.GetTagsForTarget(TargetCommand, commands[i].ID)
+		commands[i].Notes = m.GetNotesForTarget(TargetCommand, commands[i].StableID)
+		commands[i].Tags = m.GetTagsForTarget(TargetCommand, commands[i].StableID)
 	}
 
 	return commands
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestParseHistory_StableCommandIDs(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".zsh_history")
+	p := NewParser(&Config{HistoryFile: historyPath, HomeDir: tmpDir})
+
+	parse := func(content string) []HistoryEntry {
+		if err := os.WriteFile(historyPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write history file: %v", err)
+		}
+		entries, err := p.ParseHistory()
+		if err != nil {
+			t.Fatalf("ParseHistory failed: %v", err)
+		}
+		return entries
+	}
+
+	before := parse(": 1700000000:0;ls\n: 1700000010:0;make\n: 1700000010:0;make\n: 1700000020:0;git status\n")
+	if before[1].StableID == before[2].StableID {
+		t.Errorf("Repeated command got the same ID %q twice", before[1].StableID)
+	}
+
+	// zsh trimmed the oldest line, shifting every ordinal
+	after := parse(": 1700000010:0;make\n: 1700000010:0;make\n: 1700000020:0;git status\n")
+	for i, entry := range after {
+		if entry.StableID != before[i+1].StableID {
+			t.Errorf("%q: ID changed from %q to %q", entry.Command, before[i+1].StableID, entry.StableID)
+		}
+	}
+}
+
+func TestMetadataStore_Migrate(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "metadata.json")
+	legacy := `{
+  "notes": {
+    "n1": {"id": "n1", "target_type": "command", "target_id": 2, "text": "deploy fix"},
+    "n2": {"id": "n2", "target_type": "session", "target_id": 2, "text": "release day"},
+    "n3": {"id": "n3", "target_type": "command", "target_id": 99, "text": "trimmed away"}
+  },
+  "tags": {
+    "t1": {"id": "t1", "target_type": "command", "target_id": 1, "keyword": "setup"}
+  },
+  "session_metadatas": {
+    "m1": {"id": "m1", "target_type": "session", "target_id": 2, "star_rating": 4}
+  }
+}`
+	if err := os.WriteFile(filePath, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+
+	m := &MetadataStore{
+		Notes:            make(map[string]Note),
+		Tags:             make(map[string]Tag),
+		SessionMetadatas: make(map[string]SessionMetadata),
+		filePath:         filePath,
+	}
+	if err := m.load(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	// The index as saved when the session targets were still valid
+	index := `[
+  {"id": "sess_111", "sequence_number": 1, "start_time": "2023-11-14T22:13:20Z"},
+  {"id": "sess_222", "sequence_number": 2, "start_time": "2023-11-15T09:00:00Z"}
+]`
+	if err := os.WriteFile(filepath.Join(tmpDir, "sessions.json"), []byte(index), 0644); err != nil {
+		t.Fatalf("Failed to write session index: %v", err)
+	}
+	sessionIndex, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+
+	entries := []HistoryEntry{
+		{ID: 1, StableID: "cmd_aaa", Command: "npm install"},
+		{ID: 2, StableID: "cmd_bbb", Command: "make deploy"},
+	}
+	if err := m.Migrate(entries, sessionIndex); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"command note", m.Notes["n1"].TargetID, "cmd_bbb"},
+		{"session note", m.Notes["n2"].TargetID, "sess_222"},
+		{"unmatched command note", m.Notes["n3"].TargetID, "99"},
+		{"command tag", m.Tags["t1"].TargetID, "cmd_aaa"},
+		{"session metadata", m.SessionMetadatas["m1"].TargetID, "sess_222"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: target_id = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// The migrated file is saved and isn't migrated again
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	var saved MetadataStore
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Saved metadata isn't version %d: %v", metadataVersion, err)
+	}
+	if saved.Version != metadataVersion || saved.Notes["n1"].TargetID != "cmd_bbb" || saved.Notes["n2"].TargetID != "sess_222" {
+		t.Errorf("Saved version=%d n1=%q n2=%q", saved.Version, saved.Notes["n1"].TargetID, saved.Notes["n2"].TargetID)
+	}
+
+	sessionIndex.ReassignSequenceNumbers()
+	if err := m.Migrate([]HistoryEntry{{ID: 2, StableID: "cmd_ccc"}}, sessionIndex); err != nil {
+		t.Fatalf("Second Migrate failed: %v", err)
+	}
+	if got := m.Notes["n1"].TargetID; got != "cmd_bbb" {
+		t.Errorf("Second migration moved n1 to %q", got)
+	}
+	if got := m.Notes["n2"].TargetID; got != "sess_222" {
+		t.Errorf("Second migration moved n2 to %q", got)
+	}
+}
+
+func TestMetadataStore_CopyAndMoveTarget(t *testing.T) {
+	m := &MetadataStore{
+		Version:          metadataVersion,
+		Notes:            make(map[string]Note),
+		Tags:             make(map[string]Tag),
+		SessionMetadatas: make(map[string]SessionMetadata),
+		filePath:         filepath.Join(t.TempDir(), "metadata.json"),
+	}
+	if _, err := m.AddNote(TargetSession, "sess_a", "refactoring"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddTag(TargetSession, "sess_a", "api"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split, annotate the new half, and merge it back
+	if err := m.CopyTarget(TargetSession, "sess_a", "sess_b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddNote(TargetSession, "sess_b", "tests pass"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddTag(TargetSession, "sess_b", "deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MoveTarget(TargetSession, "sess_b", "sess_a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var notes, tags []string
+	for _, note := range m.GetNotesForTarget(TargetSession, "sess_a") {
+		notes = append(notes, note.Text)
+	}
+	for _, tag := range m.GetTagsForTarget(TargetSession, "sess_a") {
+		tags = append(tags, tag.Keyword)
+	}
+	sort.Strings(notes)
+	sort.Strings(tags)
+	if fmt.Sprint(notes) != "[refactoring tests pass]" || fmt.Sprint(tags) != "[api deploy]" {
+		t.Errorf("After split and merge notes = %q, tags = %q", notes, tags)
+	}
+	if len(m.Notes) != 2 || len(m.Tags) != 2 {
+		t.Errorf("Store holds %d notes and %d tags, want 2 of each", len(m.Notes), len(m.Tags))
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -13,10 +13,22 @@ const (
 	TargetCommand TargetType = "command"
 )
 
+// ValidID reports whether id has the form of the stable IDs of targetType:
+// Session.ID or HistoryEntry.StableID
+func (t TargetType) ValidID(id string) bool {
+	switch t {
+	case TargetSession:
+		return strings.HasPrefix(id, "sess_")
+	case TargetCommand:
+		return strings.HasPrefix(id, "cmd_")
+	}
+	return false
+}
+
 type Note struct {
 	ID         string     `json:"id"`
 	TargetType TargetType `json:"target_type"`
-	TargetID   int        `json:"target_id"`
+	TargetID   string     `json:"target_id"`
 	Text       string     `json:"text"`
 	CreatedAt  time.Time  `json:"created_at"`
 	UpdatedAt  time.Time  `json:"updated_at"`
@@ -25,14 +37,14 @@ type Note struct {
 type Tag struct {
 	ID         string     `json:"id"`
 	TargetType TargetType `json:"target_type"`
-	TargetID   int        `json:"target_id"`
+	TargetID   string     `json:"target_id"`
 	Keyword    string     `json:"keyword"` // Just a keyword/label
 }
 
 type SessionMetadata struct {
 	ID         string     `json:"id"`
 	TargetType TargetType `json:"target_type"`
-	TargetID   int        `json:"target_id"`
+	TargetID   string     `json:"target_id"`
 	ColorCode  string     `json:"color_code,omitempty"`  // hex color like "#ff0000"
 	StarRating int        `json:"star_rating,omitempty"` // 0-5 (0 means not set)
 }
@@ -55,14 +67,46 @@ const (
 	CategoryOther       CommandCategory = "other"
 )
 
+// Parent returns the category of a subcategory such as "version-control/commit",
+// and the category itself otherwise
+func (c CommandCategory) Parent() CommandCategory {
+	if i := strings.Index(string(c), "/"); i >= 0 {
+		return c[:i]
+	}
+	return c
+}
+
+// IsSubcategory reports whether c names a category and what was done in it
+func (c CommandCategory) IsSubcategory() bool {
+	return strings.Contains(string(c), "/")
+}
+
+// CategoryLabel is one of the categories of a command, with its share of the
+// command. The weights of a command's labels add up to 1.
+type CategoryLabel struct {
+	Category    CommandCategory `json:"category"`
+	Subcategory CommandCategory `json:"subcategory,omitempty"`
+	Weight      float64         `json:"weight"`
+}
+
 type HistoryEntry struct {
 	ID             int             `json:"id"`
+	StableID       string          `json:"stable_id"` // Content-addressed ID (e.g., "cmd_abc123") that survives history rewrites
 	Timestamp      time.Time       `json:"timestamp"`
 	Duration       int             `json:"duration"`
 	Command        string          `json:"command"`
+	ExpandedCommand string         `json:"expanded_command,omitempty"` // Command with aliases expanded, when it uses any
 	Directory      string          `json:"directory"`
+	Paths          []string        `json:"paths,omitempty"` // Files and directories the command referred to, when the shell records them
 	Category       CommandCategory `json:"category"`
+	Subcategory    CommandCategory `json:"subcategory,omitempty"` // e.g. "version-control/commit", when the command has one
+	Labels         []CategoryLabel `json:"labels,omitempty"` // Every category of the command with its share, most weighted first
 	BaseCommand    string          `json:"base_command"`
+	Segments       []CommandSegment `json:"segments,omitempty"` // Simple commands of a pipeline or command list
+	Host           string          `json:"host,omitempty"` // Label of the history source the command came from
+	Project        string          `json:"project,omitempty"` // Project the directory belongs to (see ProjectResolver)
+	ExitCode       *int            `json:"exit_code,omitempty"` // Exit status, when the source records it
+	SessionHint    string          `json:"session_hint,omitempty"` // Shell session the command ran in, when the source records it
 	SessionID      string          `json:"session_id"` // Changed from int to string for stable IDs
 	Notes          []Note          `json:"notes,omitempty"`
 	Tags           []Tag           `json:"tags,omitempty"`
@@ -71,12 +115,15 @@ type HistoryEntry struct {
 type Session struct {
 	ID             string           `json:"id"`              // Stable hash-based ID (e.g., "sess_abc123")
 	SequenceNumber int              `json:"sequence_number"` // Display number (e.g., 5 for "Session #5")
+	Host           string           `json:"host,omitempty"`
+	Terminal       string           `json:"terminal,omitempty"` // Terminal the session ran in, when terminals are split
+	Project        string           `json:"project,omitempty"`  // Project most of the commands ran in
 	StartTime      time.Time        `json:"start_time"`
 	EndTime        time.Time        `json:"end_time"`
 	Duration       time.Duration    `json:"duration"`
 	Commands       []HistoryEntry   `json:"commands"`
 	Directories    []string         `json:"directories"`
-	Categories     map[CommandCategory]int `json:"categories"`
+	Categories     map[CommandCategory]float64 `json:"categories"` // Weighted share of the commands per category and per subcategory, see entryLabels
 	Description    string           `json:"description"`
 	Notes          []Note           `json:"notes,omitempty"`
 	Tags           []Tag            `json:"tags,omitempty"`
@@ -90,66 +137,116 @@ type CommandPattern struct {
 	Categories   map[CommandCategory]int `json:"categories"`
 }
 
-var categoryPatterns = map[CommandCategory]*regexp.Regexp{
-	CategoryVCS:         regexp.MustCompile(`^(git|hg|svn|bzr|cvs)\s`),
-	CategoryBuild:       regexp.MustCompile(`^(make|cmake|cargo|npm|yarn|pnpm|gradle|mvn|ant|bazel|go build|go test|gcc|g\+\+|clang|rustc|javac)\s`),
-	CategoryFileOps:     regexp.MustCompile(`^(cp|mv|rm|mkdir|rmdir|touch|chmod|chown|ln|cat|head|tail|less|more|dd|rsync|scp)\s`),
-	CategoryNavigation:  regexp.MustCompile(`^(cd|ls|pwd|tree|find|locate|which|whereis)\s`),
-	CategoryDevTools:    regexp.MustCompile(`^(vim|nvim|emacs|nano|code|subl|idea|pycharm|gdb|lldb|valgrind|strace|ltrace)\s`),
-	CategorySystemAdmin: regexp.MustCompile(`^(sudo|su|systemctl|service|kill|killall|ps|top|htop|free|df|du|mount|umount|lsof|netstat|ss|iptables|ufw|systemd)\s`),
-	CategoryNetwork:     regexp.MustCompile(`^(curl|wget|ssh|scp|rsync|ping|traceroute|nslookup|dig|host|telnet|nc|netcat|ftp|sftp)\s`),
-	CategoryContainers:  regexp.MustCompile(`^(docker|podman|kubectl|k|helm|minikube|kind|k3s|nerdctl|containerd)\s`),
-	CategoryDatabase:    regexp.MustCompile(`^(psql|mysql|sqlite3|mongo|redis-cli|mongosh|clickhouse-client)\s`),
-	CategoryEditor:      regexp.MustCompile(`^(vim|nvim|emacs|nano|vi|ed|joe|pico)\s`),
-	CategorySearch:      regexp.MustCompile(`^(grep|egrep|fgrep|ag|rg|ack|find.*-name|locate)\s`),
-	CategoryPackage:     regexp.MustCompile(`^(apt|apt-get|yum|dnf|pacman|brew|pip|pip3|npm|yarn|cargo|gem|composer)\s`),
-}
-
-// Custom category patterns (set by parser at runtime)
-var customCategoryPatterns []struct {
-	category CommandCategory
-	pattern  *regexp.Regexp
-}
-
-// SetCustomCategoryPatterns configures custom patterns from config
+// SetCustomCategoryPatterns configures custom patterns from config. Each
+// becomes a rule matching the whole command, ahead of the built-in rules.
+// Invalid patterns are skipped.
 func SetCustomCategoryPatterns(patterns []struct {
 	Category string
 	Pattern  string
 }) {
-	customCategoryPatterns = nil
+	var rules []CategoryRule
 	for _, p := range patterns {
-		if compiled, err := regexp.Compile(p.Pattern); err == nil {
-			customCategoryPatterns = append(customCategoryPatterns, struct {
-				category CommandCategory
-				pattern  *regexp.Regexp
-			}{
-				category: CommandCategory(p.Category),
-				pattern:  compiled,
-			})
+		rule := CategoryRule{
+			Name:     "custom: " + p.Pattern,
+			Category: CommandCategory(p.Category),
+			Priority: customRulePriority,
+			Pattern:  p.Pattern,
+		}
+		if err := rule.compile(""); err == nil {
+			rules = append(rules, rule)
 		}
 	}
+
+	categoryRules.mu.Lock()
+	categoryRules.patterns = rules
+	categoryRules.mu.Unlock()
+	orderCategoryRules()
 }
 
+// CategorizeCommand returns the category of the main command of a command
+// line, see primarySegment
 func CategorizeCommand(cmd string) CommandCategory {
-	cmd = strings.TrimSpace(cmd)
-	
-	// Check custom patterns first (higher priority)
-	for _, custom := range customCategoryPatterns {
-		if custom.pattern.MatchString(cmd) {
-			return custom.category
+	if segments := SplitCommandSegments(cmd); len(segments) > 0 {
+		return primarySegment(segments).Category
+	}
+	return categorizeSimpleCommand(cmd)
+}
+
+// categorizeSimpleCommand matches a single command, without wrappers, against
+// the category rules, see CategoryRule. Rules on the directory or project
+// only apply once an entry is recategorized.
+func categorizeSimpleCommand(cmd string) CommandCategory {
+	category, _, _ := categorizeContext(CommandContext{Command: cmd})
+	return category
+}
+
+// entryLabels returns the labels of an entry, or its category as its only
+// label when it has none
+func entryLabels(entry *HistoryEntry) []CategoryLabel {
+	if len(entry.Labels) > 0 {
+		return entry.Labels
+	}
+	return []CategoryLabel{{Category: entry.Category, Subcategory: entry.Subcategory, Weight: 1}}
+}
+
+// addLabelShares adds the weighted labels of an entry to shares, at both
+// the category and the subcategory level
+func addLabelShares(shares map[CommandCategory]float64, entry *HistoryEntry) {
+	for _, label := range entryLabels(entry) {
+		shares[label.Category] += label.Weight
+		if label.Subcategory != "" {
+			shares[label.Subcategory] += label.Weight
 		}
 	}
-	
-	// Check built-in patterns
-	for category, pattern := range categoryPatterns {
-		if pattern.MatchString(cmd) {
-			return category
+}
+
+// sessionCategoryShare returns the fraction of a session's commands in
+// category, which may be a top-level category or a subcategory
+func sessionCategoryShare(session *Session, category CommandCategory) float64 {
+	if len(session.Commands) == 0 {
+		return 0
+	}
+	return session.Categories[category] / float64(len(session.Commands))
+}
+
+// sessionHasCategory reports whether at least minShare of the session's
+// commands fall in category. A minShare of 0 accepts any share.
+func sessionHasCategory(session *Session, category CommandCategory, minShare float64) bool {
+	share := sessionCategoryShare(session, category)
+	return share > 0 && share >= minShare
+}
+
+// categoryRank orders the categories of a level as their built-in rules are
+// tried, with other categories after them
+func categoryRank(category CommandCategory) int {
+	for i, rule := range builtinCategoryRules {
+		if rule.Category == category.Parent() {
+			return i
 		}
 	}
-	return CategoryOther
+	return len(builtinCategoryRules)
 }
 
+// sortCategories sorts categories by rank, each followed by its
+// subcategories in alphabetical order
+func sortCategories(categories []CommandCategory) {
+	sort.SliceStable(categories, func(i, j int) bool {
+		a, b := categories[i], categories[j]
+		if a.Parent() != b.Parent() {
+			if ra, rb := categoryRank(a), categoryRank(b); ra != rb {
+				return ra < rb
+			}
+			return a.Parent() < b.Parent()
+		}
+		return a < b
+	})
+}
+
+// GetBaseCommand returns the program run by the main command of a command line
 func GetBaseCommand(cmd string) string {
+	if segments := SplitCommandSegments(cmd); len(segments) > 0 {
+		return primarySegment(segments).BaseCommand
+	}
 	parts := strings.Fields(cmd)
 	if len(parts) > 0 {
 		return parts[0]
@@ -157,8 +254,13 @@ func GetBaseCommand(cmd string) string {
 	return cmd
 }
 
-// GetCategoryDisplayName converts a category to a friendly display name
+// GetCategoryDisplayName converts a category to a friendly display name,
+// e.g. "Version Control: Commit" for version-control/commit
 func GetCategoryDisplayName(cat CommandCategory) string {
+	if cat.IsSubcategory() {
+		parent := cat.Parent()
+		return GetCategoryDisplayName(parent) + ": " + GetCategoryDisplayName(cat[len(parent)+1:])
+	}
 	// Convert dash-separated to Title Case
 	parts := strings.Split(string(cat), "-")
 	for i, part := range parts {
@@ -25,6 +25,8 @@ type NativeUI struct {
 	startDate  *widget.Entry
 	endDate    *widget.Entry
 	categorySelect *widget.Select
+	hostSelect *widget.Select
+	projectSelect *widget.Select
 	keywordEntry *widget.Entry
 	sortDescending bool
 	
@@ -56,7 +58,7 @@ func (ui *NativeUI) Start() error {
 		return fmt.Errorf("failed to parse history: %w", err)
 	}
 	
-	ui.sessions = ui.server.GetSessions("", "", "", "desc")
+	ui.sessions = ui.server.GetSessions(nil)
 	ui.filtered = ui.sessions
 	
 	// Build UI
@@ -150,17 +152,24 @@ func (ui *NativeUI) createFiltersPanel() *fyne.Container {
 	ui.endDate.OnSubmitted = func(string) { ui.applyFilters() }
 	
 	// Category filter
-	categories := []string{"All", "VCS", "Build", "File Ops", "Navigation", "Dev Tools", 
-		"System Admin", "Network", "Containers", "Database", "Editor", "Search", 
-		"Package Manager", "Other"}
-	ui.categorySelect = widget.NewSelect(categories, func(string) {
+	ui.categorySelect = widget.NewSelect(ui.categoryOptions(), func(string) {
 		// Auto-apply when category changes
 		ui.applyFilters()
 	})
 	
+	// Host filter, only useful with several history sources
+	ui.hostSelect = widget.NewSelect(ui.hostOptions(), func(string) {
+		ui.applyFilters()
+	})
+	
+	// Project filter
+	ui.projectSelect = widget.NewSelect(ui.projectOptions(), func(string) {
+		ui.applyFilters()
+	})
+	
 	// Keyword search
 	ui.keywordEntry = widget.NewEntry()
-	ui.keywordEntry.SetPlaceHolder("Search keywords...")
+	ui.keywordEntry.SetPlaceHolder(`Search, e.g. cat:vcs dir:~/code "git push" -rebase`)
 	ui.keywordEntry.OnSubmitted = func(string) { ui.applyFilters() }
 	
 	// Sort toggle
@@ -186,12 +195,16 @@ func (ui *NativeUI) createFiltersPanel() *fyne.Container {
 		ui.startDate.SetText("")
 		ui.endDate.SetText("")
 		ui.categorySelect.SetSelected("All")
+		ui.hostSelect.SetSelected("All")
+		ui.projectSelect.SetSelected("All")
 		ui.keywordEntry.SetText("")
 		ui.applyFilters()
 	})
 	
 	// Set initial value after all widgets are created to avoid nil pointer during callback
 	ui.categorySelect.SetSelected("All")
+	ui.hostSelect.SetSelected("All")
+	ui.projectSelect.SetSelected("All")
 	
 	// Layout
 	dateRow := container.NewGridWithColumns(2,
@@ -199,8 +212,10 @@ func (ui *NativeUI) createFiltersPanel() *fyne.Container {
 		container.NewBorder(nil, nil, widget.NewLabel("To:"), nil, ui.endDate),
 	)
 	
-	filterRow := container.NewGridWithColumns(3,
+	filterRow := container.NewGridWithColumns(5,
 		container.NewBorder(nil, nil, widget.NewLabel("Category:"), nil, ui.categorySelect),
+		container.NewBorder(nil, nil, widget.NewLabel("Host:"), nil, ui.hostSelect),
+		container.NewBorder(nil, nil, widget.NewLabel("Project:"), nil, ui.projectSelect),
 		container.NewBorder(nil, nil, widget.NewLabel("Keywords:"), nil, ui.keywordEntry),
 		sortBtn,
 	)
@@ -302,12 +317,18 @@ func (ui *NativeUI) showSessionDetails(session *Session) {
 		directory = session.Directories[0]
 	}
 	
+	project := session.Project
+	if project == "" {
+		project = "N/A"
+	}
+	
 	info := widget.NewRichTextFromMarkdown(fmt.Sprintf(`
 **Description:** %s
 **Start Time:** %s
 **End Time:** %s
 **Duration:** %s
 **Directory:** %s
+**Project:** %s
 **Categories:** %s
 **Command Count:** %d
 `, 
@@ -316,12 +337,19 @@ func (ui *NativeUI) showSessionDetails(session *Session) {
 		session.EndTime.Format("2006-01-02 15:04:05"),
 		session.Duration.Round(time.Second).String(),
 		directory,
+		project,
 		categoryStr,
 		len(session.Commands),
 	))
 	
 	ui.detailsContainer.Add(info)
 	
+	// Fix boundaries by hand
+	mergeBtn := widget.NewButton("⤓ Merge with Next Session", func() {
+		ui.mergeWithNext(session)
+	})
+	ui.detailsContainer.Add(container.NewHBox(mergeBtn))
+	
 	// Commands list
 	ui.detailsContainer.Add(widget.NewSeparator())
 	ui.detailsContainer.Add(widget.NewLabelWithStyle("Commands:", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
@@ -330,12 +358,74 @@ func (ui *NativeUI) showSessionDetails(session *Session) {
 		cmdText := fmt.Sprintf("%d. [%s] %s", i+1, entry.Directory, entry.Command)
 		cmdLabel := widget.NewLabel(cmdText)
 		cmdLabel.Wrapping = fyne.TextWrapWord
-		ui.detailsContainer.Add(cmdLabel)
+		if i == 0 {
+			ui.detailsContainer.Add(cmdLabel)
+			continue
+		}
+		splitBtn := widget.NewButton("✂", func() {
+			ui.splitAt(entry)
+		})
+		ui.detailsContainer.Add(container.NewBorder(nil, nil, nil, splitBtn, cmdLabel))
 	}
 	
 	ui.detailsContainer.Refresh()
 }
 
+// splitAt starts a new session at a command, after asking
+func (ui *NativeUI) splitAt(entry HistoryEntry) {
+	message := fmt.Sprintf("Start a new session at:\n%s", entry.Command)
+	dialog.ShowConfirm("Split Session", message, func(ok bool) {
+		if !ok {
+			return
+		}
+		if _, err := ui.server.SplitSession(entry.StableID); err != nil {
+			dialog.ShowError(fmt.Errorf("split failed: %w", err), ui.window)
+			return
+		}
+		ui.reloadSessions()
+	}, ui.window)
+}
+
+// mergeWithNext merges a session with the next one on the same host and
+// terminal
+func (ui *NativeUI) mergeWithNext(session *Session) {
+	var next *Session
+	for i, s := range ui.sessions {
+		if s.ID != session.ID {
+			continue
+		}
+		for _, later := range ui.sessions[i+1:] {
+			if later.Host == session.Host && later.Terminal == session.Terminal {
+				next = later
+				break
+			}
+		}
+		break
+	}
+	if next == nil {
+		dialog.ShowInformation("Merge Sessions", "This is the last session", ui.window)
+		return
+	}
+
+	message := fmt.Sprintf("Merge with the session starting %s?\n%s", next.StartTime.Format("2006-01-02 15:04:05"), next.Description)
+	dialog.ShowConfirm("Merge Sessions", message, func(ok bool) {
+		if !ok {
+			return
+		}
+		if _, err := ui.server.MergeSessions([]string{session.ID, next.ID}); err != nil {
+			dialog.ShowError(fmt.Errorf("merge failed: %w", err), ui.window)
+			return
+		}
+		ui.reloadSessions()
+	}, ui.window)
+}
+
+// reloadSessions shows the sessions again after they were regrouped
+func (ui *NativeUI) reloadSessions() {
+	ui.sessions = ui.server.GetSessions(nil)
+	ui.applyFilters()
+}
+
 func (ui *NativeUI) applyFilters() {
 	startDate := ui.startDate.Text
 	endDate := ui.endDate.Text
@@ -343,9 +433,36 @@ func (ui *NativeUI) applyFilters() {
 	if category == "All" {
 		category = ""
 	}
-	keyword := ui.keywordEntry.Text
+	host := ui.hostSelect.Selected
+	if host == "All" {
+		host = ""
+	}
+	project := ui.projectSelect.Selected
+	if project == "All" {
+		project = ""
+	}
 	
-	ui.filtered = ui.server.GetSessions(startDate, endDate, category, keyword)
+	// The keyword box takes the query syntax of the web UI and the API
+	query, err := ParseSessionQuery(ui.keywordEntry.Text)
+	if err == nil {
+		filters := []struct{ field, value string }{
+			{"after", startDate}, {"before", endDate}, {"cat", category}, {"host", host}, {"project", project},
+		}
+		for _, filter := range filters {
+			if filter.value == "" {
+				continue
+			}
+			if err = query.Where(filter.field, ":", filter.value); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		ui.statusLabel.SetText(fmt.Sprintf("Invalid search: %v", err))
+		return
+	}
+	
+	ui.filtered = ui.server.GetSessions(query)
 	
 	// Apply sort
 	sortOrder := "desc"
@@ -380,7 +497,13 @@ func (ui *NativeUI) refresh() {
 		return
 	}
 	
-	ui.sessions = ui.server.GetSessions("", "", "", "desc")
+	ui.sessions = ui.server.GetSessions(nil)
+	ui.hostSelect.Options = ui.hostOptions()
+	ui.hostSelect.Refresh()
+	ui.projectSelect.Options = ui.projectOptions()
+	ui.projectSelect.Refresh()
+	ui.categorySelect.Options = ui.categoryOptions()
+	ui.categorySelect.Refresh()
 	ui.applyFilters()
 	ui.statusLabel.SetText("Refreshed successfully")
 	
@@ -390,6 +513,24 @@ func (ui *NativeUI) refresh() {
 	})
 }
 
+func (ui *NativeUI) hostOptions() []string {
+	return append([]string{"All"}, ui.server.Hosts()...)
+}
+
+func (ui *NativeUI) projectOptions() []string {
+	return append([]string{"All"}, ui.server.Projects()...)
+}
+
+// categoryOptions lists the categories and subcategories found in the
+// sessions, so that either level can be selected
+func (ui *NativeUI) categoryOptions() []string {
+	options := []string{"All"}
+	for _, cat := range ui.server.Categories() {
+		options = append(options, string(cat))
+	}
+	return options
+}
+
 func (ui *NativeUI) updateStatus() {
 	total := len(ui.sessions)
 	filtered := len(ui.filtered)
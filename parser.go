@@ -2,16 +2,21 @@ package main
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Parser struct {
-	config *Config
+	config   *Config
+	aliases  *AliasResolver
+	projects *ProjectResolver
 }
 
 func NewParser(config *Config) *Parser {
@@ -27,108 +32,214 @@ func NewParser(config *Config) *Parser {
 		}
 		SetCustomCategoryPatterns(patterns)
 	}
-	return &Parser{config: config}
+	SetCategoryRules(config.CategoryRules, config.HomeDir)
+	return &Parser{
+		config:  config,
+		aliases:  NewAliasResolver(config.AliasFiles, config.AliasDump, config.HomeDir),
+		projects: NewProjectResolver(config.Projects, config.HomeDir),
+	}
 }
 
 // Parse zsh history format: : <timestamp>:<duration>;<command>
 var historyLineRegex = regexp.MustCompile(`^:\s*(\d+):(\d+);(.*)$`)
-// Match cd command but stop at &&, ||, ;, or |
-var cdRegex = regexp.MustCompile(`^\s*cd\s+([^;&|]+)`)
 
+// ParseHistory reads every configured history source from scratch
 func (p *Parser) ParseHistory() ([]HistoryEntry, error) {
-	file, err := os.Open(p.config.HistoryFile)
-	if err != nil {
+	var cursors HistoryCursors
+	entries, _, err := p.ParseSources(&cursors, nil)
+	return entries, err
+}
+
+// parseFrom parses the history file starting at state.offset, which must be
+// the start of an entry. format must already be resolved.
+func (p *Parser) parseFrom(file *os.File, format string, state *parseState) ([]HistoryEntry, error) {
+	if _, err := file.Seek(state.offset, io.SeekStart); err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	reader := bufio.NewReaderSize(file, 64*1024)
+
+	switch format {
+	case HistoryFormatBash:
+		return p.parseBashHistory(reader, state)
+	case HistoryFormatZsh:
+		return p.parseZshHistory(reader, state)
+	case HistoryFormatFish:
+		return p.parseFishHistory(reader, state)
+	default:
+		return nil, fmt.Errorf("unsupported history format: %s", format)
+	}
+}
+
+// resolveFormat returns the configured history format, sniffing the start of
+// the file when it is set to auto
+func resolveFormat(file *os.File, format string) (string, error) {
+	if format != "" && format != HistoryFormatAuto {
+		return format, nil
+	}
+
+	head := make([]byte, sniffSize)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return DetectHistoryFormat(head[:n]), nil
+}
+
+// parseState tracks a parse's position in the history file so that a later
+// parse can resume where it stopped
+type parseState struct {
+	offset     int64 // file offset where the reader starts; bytes consumed once parsed
+	nextID     int
+	dir        dirState
+
+	// The last entry is re-read on resume in case more of it has been written
+	lastOffset int64  // file offset of the last entry
+	lastID     int    // ID of the last entry, 0 if there is none
+	lastDir    dirState // directories before the last entry ran
+}
+
+func (p *Parser) newParseState() *parseState {
+	return &parseState{nextID: 1, dir: dirState{current: p.config.HomeDir}}
+}
 
+// markEntry records that an entry starting at offset is about to be added
+func (s *parseState) markEntry(offset int64) {
+	s.lastOffset = offset
+	s.lastID = s.nextID
+	s.lastDir = s.dir
+}
+
+// parseZshHistory reads the zsh extended format. Lines that don't start a
+// new entry are continuation lines of a multi-line command.
+func (p *Parser) parseZshHistory(r io.Reader, state *parseState) ([]HistoryEntry, error) {
 	var entries []HistoryEntry
-	scanner := bufio.NewScanner(file)
-	
-	// Increase buffer size for long commands
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-	
-	currentDir := p.config.HomeDir
-	id := 1
+	scanner := newHistoryScanner(r, state.offset)
+
+	var (
+		pending   bool
+		offset    int64
+		timestamp int64
+		duration  int
+		command   string
+	)
+	flush := func() {
+		if !pending {
+			return
+		}
+		state.markEntry(offset)
+		entries = append(entries, p.newEntry(state.nextID, time.Unix(timestamp, 0), duration, command, &state.dir))
+		state.nextID++
+		pending = false
+	}
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		
+		line := unmetafy(scanner.Text())
+
 		matches := historyLineRegex.FindStringSubmatch(line)
 		if len(matches) == 4 {
-			timestamp, _ := strconv.ParseInt(matches[1], 10, 64)
-			duration, _ := strconv.Atoi(matches[2])
-			command := matches[3]
-			
-			// Handle multi-line commands
-			for scanner.Scan() {
-				nextLine := scanner.Text()
-				if historyLineRegex.MatchString(nextLine) {
-					// This is a new command, need to "unscan" it
-					// Since we can't unscan, we'll process it in the next iteration
-					line = nextLine
-					break
-				}
-				command += "\n" + nextLine
-			}
-			
-			// Track directory changes
-			if cdMatches := cdRegex.FindStringSubmatch(command); len(cdMatches) > 1 {
-				newDir := strings.TrimSpace(cdMatches[1])
-				currentDir = p.resolveDirectory(currentDir, newDir)
-			}
-			
-			entry := HistoryEntry{
-				ID:          id,
-				Timestamp:   time.Unix(timestamp, 0),
-				Duration:    duration,
-				Command:     command,
-				Directory:   currentDir,
-				Category:    CategorizeCommand(command),
-				BaseCommand: GetBaseCommand(command),
-			}
-			
-			entries = append(entries, entry)
-			id++
-			
-			// If we read ahead to check for multiline, process that line now
-			if historyLineRegex.MatchString(line) {
-				matches = historyLineRegex.FindStringSubmatch(line)
-				if len(matches) == 4 {
-					timestamp, _ := strconv.ParseInt(matches[1], 10, 64)
-					duration, _ := strconv.Atoi(matches[2])
-					command := matches[3]
-					
-					if cdMatches := cdRegex.FindStringSubmatch(command); len(cdMatches) > 1 {
-						newDir := strings.TrimSpace(cdMatches[1])
-						currentDir = p.resolveDirectory(currentDir, newDir)
-					}
-					
-					entry := HistoryEntry{
-						ID:          id,
-						Timestamp:   time.Unix(timestamp, 0),
-						Duration:    duration,
-						Command:     command,
-						Directory:   currentDir,
-						Category:    CategorizeCommand(command),
-						BaseCommand: GetBaseCommand(command),
-					}
-					
-					entries = append(entries, entry)
-					id++
-				}
-			}
+			flush()
+			offset = scanner.lineStart
+			timestamp, _ = strconv.ParseInt(matches[1], 10, 64)
+			duration, _ = strconv.Atoi(matches[2])
+			command = matches[3]
+			pending = true
+			continue
+		}
+
+		// Handle multi-line commands
+		if pending {
+			command += "\n" + line
 		}
 	}
+	flush()
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
+	state.offset = scanner.next
 
 	return entries, nil
 }
 
+// newEntry builds a HistoryEntry and tracks the directory changes it makes
+func (p *Parser) newEntry(id int, timestamp time.Time, duration int, command string, dir *dirState) HistoryEntry {
+	expanded := p.aliases.Expand(command)
+	p.trackDirectory(dir, expanded, nil)
+	return buildEntry(id, timestamp, duration, command, expanded, dir.current)
+}
+
+// buildEntry builds a HistoryEntry for command. Its segments, base command
+// and category come from expanded, the command with aliases expanded.
+func buildEntry(id int, timestamp time.Time, duration int, command, expanded, directory string) HistoryEntry {
+	entry := HistoryEntry{
+		ID:        id,
+		Timestamp: timestamp,
+		Duration:  duration,
+		Command:   command,
+		Directory: directory,
+		Segments:  SplitCommandSegments(expanded),
+	}
+	if expanded != command {
+		entry.ExpandedCommand = expanded
+	}
+	if len(entry.Segments) > 0 {
+		primary := primarySegment(entry.Segments)
+		entry.Category = primary.Category
+		entry.Subcategory = primary.Subcategory
+		entry.BaseCommand = primary.BaseCommand
+		entry.Labels = mergeLabels(entry.Segments)
+	} else {
+		entry.Labels, _ = labelContext(CommandContext{Command: expanded})
+		entry.Category, entry.Subcategory = entry.Labels[0].Category, entry.Labels[0].Subcategory
+		entry.BaseCommand = GetBaseCommand(expanded)
+	}
+	return entry
+}
+
+type commandKey struct {
+	host      string
+	timestamp int64
+	command   string
+}
+
+// assignCommandIDs gives every entry its stable ID, numbering repeats of a
+// command within the same second in order
+func assignCommandIDs(entries []HistoryEntry) {
+	occurrences := make(map[commandKey]int)
+	for i := range entries {
+		entry := &entries[i]
+		key := commandKey{entry.Host, entry.Timestamp.Unix(), entry.Command}
+		entry.StableID = GenerateCommandID(entry.Host, entry.Timestamp, entry.Command, occurrences[key])
+		occurrences[key]++
+	}
+}
+
+// historyScanner is a line scanner that knows the file offset of each line
+type historyScanner struct {
+	*bufio.Scanner
+	lineStart int64 // offset of the line returned by the last Scan
+	next      int64 // offset just past the last line read
+}
+
+func newHistoryScanner(r io.Reader, offset int64) *historyScanner {
+	s := &historyScanner{Scanner: bufio.NewScanner(r), next: offset}
+
+	// Increase buffer size for long commands
+	buf := make([]byte, 0, 64*1024)
+	s.Buffer(buf, 1024*1024)
+
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			s.lineStart = s.next
+			s.next += int64(advance)
+		}
+		return advance, token, err
+	})
+
+	return s
+}
+
 func (p *Parser) resolveDirectory(currentDir, newDir string) string {
 	newDir = strings.Trim(newDir, "\"'")
 	
@@ -158,151 +269,59 @@ func (p *Parser) resolveDirectory(currentDir, newDir string) string {
 	return filepath.Clean(filepath.Join(currentDir, newDir))
 }
 
+// GroupIntoSessions splits entries into sessions using the configured
+// session heuristics
 func (p *Parser) GroupIntoSessions(entries []HistoryEntry, sessionIndex *SessionIndex) []Session {
+	heuristics := p.config.SessionHeuristics
+	strategy := NewSegmentationStrategy(heuristics, p.config.SessionTimeout)
+	return p.SegmentSessions(entries, strategy, heuristics.MinCommandsPerSession, heuristics.SplitConcurrentTerminals, sessionIndex.Overrides(), sessionIndex)
+}
+
+// SegmentSessions splits entries into sessions wherever strategy says so,
+// unless the session would have fewer than minCommands commands. With
+// byTerminal, each terminal's commands are segmented on their own (see
+// splitTerminals). overrides, keyed by command stable ID, force whether a
+// command starts a session.
+// Sessions get their stable IDs and sequence numbers from sessionIndex;
+// without one, the IDs are computed without recording anything.
+func (p *Parser) SegmentSessions(entries []HistoryEntry, strategy SegmentationStrategy, minCommands int, byTerminal bool, overrides map[string]bool, sessionIndex *SessionIndex) []Session {
 	if len(entries) == 0 {
 		return []Session{}
 	}
 
+	// Sessions never span hosts, so each host's commands are grouped on their own
 	sessions := []Session{}
-	currentSession := Session{
-		ID:             "", // Will be set when session is finalized
-		SequenceNumber: 1,
-		StartTime:      entries[0].Timestamp,
-		Commands:       []HistoryEntry{},
-		Categories:     make(map[CommandCategory]int),
-	}
-	
-	dirSet := make(map[string]bool)
-	consecutiveCategoryChanges := 0
-	var lastCategory CommandCategory
-
-	for i, entry := range entries {
-		// Check if we should start a new session
-		if i > 0 {
-			shouldBreak := false
-			timeSinceLastCommand := entry.Timestamp.Sub(entries[i-1].Timestamp)
-			
-			// Heuristic 1: Timeout-based (primary)
-			if timeSinceLastCommand > p.config.SessionTimeout {
-				shouldBreak = true
-			}
-			
-			// Heuristic 2: Directory change (if enabled)
-			if p.config.SessionHeuristics.DirectoryChangeBreaksSession && !shouldBreak {
-				if !isRelatedDirectory(entries[i-1].Directory, entry.Directory) {
-					shouldBreak = true
-				}
-			}
-			
-			// Heuristic 3: Category change threshold
-			if p.config.SessionHeuristics.CategoryChangeThreshold > 0 && !shouldBreak {
-				if entry.Category != lastCategory && lastCategory != "" {
-					consecutiveCategoryChanges++
-					if consecutiveCategoryChanges >= p.config.SessionHeuristics.CategoryChangeThreshold {
-						shouldBreak = true
-					}
-				} else {
-					consecutiveCategoryChanges = 0
-				}
-			}
-			
-			// Heuristic 4: Maximum session duration
-			if p.config.SessionHeuristics.MaxSessionDuration > 0 && !shouldBreak {
-				sessionDuration := entry.Timestamp.Sub(currentSession.StartTime)
-				maxDuration := time.Duration(p.config.SessionHeuristics.MaxSessionDuration) * time.Minute
-				if sessionDuration > maxDuration {
-					shouldBreak = true
-				}
-			}
-			
-			if shouldBreak {
-				// Check minimum commands per session
-				if len(currentSession.Commands) >= p.config.SessionHeuristics.MinCommandsPerSession {
-					// Finalize current session
-					currentSession.EndTime = entries[i-1].Timestamp
-					currentSession.Duration = currentSession.EndTime.Sub(currentSession.StartTime)
-					currentSession.Directories = getUniqueDirectories(dirSet)
-					currentSession.Description = generateSessionDescription(&currentSession)
-					
-					// Generate stable ID from first command
-					firstCmd := currentSession.Commands[0]
-					stableID := sessionIndex.GetOrCreate(
-						currentSession.StartTime,
-						currentSession.EndTime,
-						firstCmd.Command,
-						currentSession.Description,
-					)
-					currentSession.ID = stableID
-					currentSession.SequenceNumber = sessionIndex.GetSequenceNumber(stableID)
-					
-					// Update all commands in this session with the stable ID
-					// Need to update both the session copy and the original entries slice
-					for j := range currentSession.Commands {
-						currentSession.Commands[j].SessionID = stableID
-						// Also update the original entry in the entries slice
-						for k := range entries {
-							if entries[k].ID == currentSession.Commands[j].ID {
-								entries[k].SessionID = stableID
-								break
-							}
-						}
-					}
-					
-					sessions = append(sessions, currentSession)
-					
-					// Start new session
-					currentSession = Session{
-						ID:             "",
-						SequenceNumber: len(sessions) + 1,
-						StartTime:      entry.Timestamp,
-						Commands:       []HistoryEntry{},
-						Categories:     make(map[CommandCategory]int),
-					}
-					dirSet = make(map[string]bool)
-					consecutiveCategoryChanges = 0
-				}
+	for _, hostEntries := range splitByHost(entries) {
+		if !byTerminal {
+			sessions = append(sessions, groupHostSessions(hostEntries, strategy, minCommands, overrides, sessionIndex)...)
+			continue
+		}
+		for _, terminal := range splitTerminals(hostEntries) {
+			for _, session := range groupHostSessions(terminal.entries, strategy, minCommands, overrides, sessionIndex) {
+				session.Terminal = terminal.label
+				sessions = append(sessions, session)
 			}
 		}
-		
-	lastCategory = entry.Category
-	// Don't set SessionID yet - will be set when session is finalized
-	currentSession.Commands = append(currentSession.Commands, entry)
-	currentSession.Categories[entry.Category]++
-	dirSet[entry.Directory] = true
 	}
-	
-	// Don't forget the last session
-	if len(currentSession.Commands) >= p.config.SessionHeuristics.MinCommandsPerSession {
-		currentSession.EndTime = entries[len(entries)-1].Timestamp
-		currentSession.Duration = currentSession.EndTime.Sub(currentSession.StartTime)
-		currentSession.Directories = getUniqueDirectories(dirSet)
-		currentSession.Description = generateSessionDescription(&currentSession)
-		
-		// Generate stable ID from first command
-		firstCmd := currentSession.Commands[0]
-		stableID := sessionIndex.GetOrCreate(
-			currentSession.StartTime,
-			currentSession.EndTime,
-			firstCmd.Command,
-			currentSession.Description,
-		)
-		currentSession.ID = stableID
-		currentSession.SequenceNumber = sessionIndex.GetSequenceNumber(stableID)
-		
-		// Update all commands in this session with the stable ID
-		// Need to update both the session copy and the original entries slice
-		for j := range currentSession.Commands {
-			currentSession.Commands[j].SessionID = stableID
-			// Also update the original entry in the entries slice
-			for k := range entries {
-				if entries[k].ID == currentSession.Commands[j].ID {
-					entries[k].SessionID = stableID
-					break
-				}
-			}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].StartTime.Before(sessions[j].StartTime)
+	})
+
+	// Update the original entries slice with the stable session IDs
+	sessionIDs := make(map[int]string, len(entries))
+	for _, session := range sessions {
+		for _, cmd := range session.Commands {
+			sessionIDs[cmd.ID] = session.ID
+		}
+	}
+	for k := range entries {
+		if id, ok := sessionIDs[entries[k].ID]; ok {
+			entries[k].SessionID = id
 		}
-		
-		sessions = append(sessions, currentSession)
+	}
+
+	if sessionIndex == nil {
+		return sessions
 	}
 
 	// Reassign sequence numbers to ensure they're in chronological order
@@ -314,6 +333,91 @@ func (p *Parser) GroupIntoSessions(entries []HistoryEntry, sessionIndex *Session
 	return sessions
 }
 
+// splitByHost partitions entries by host, keeping each host's entries in
+// order. Entries from a single host are returned without copying.
+func splitByHost(entries []HistoryEntry) [][]HistoryEntry {
+	var order []string
+	byHost := make(map[string][]HistoryEntry)
+	for _, entry := range entries {
+		if _, seen := byHost[entry.Host]; !seen {
+			order = append(order, entry.Host)
+		}
+		byHost[entry.Host] = append(byHost[entry.Host], entry)
+	}
+	if len(order) <= 1 {
+		return [][]HistoryEntry{entries}
+	}
+
+	parts := make([][]HistoryEntry, 0, len(order))
+	for _, host := range order {
+		parts = append(parts, byHost[host])
+	}
+	return parts
+}
+
+// groupHostSessions groups entries from a single host into sessions. A
+// trailing session shorter than minCommands is left out unless it was split
+// off by hand.
+func groupHostSessions(entries []HistoryEntry, strategy SegmentationStrategy, minCommands int, overrides map[string]bool, sessionIndex *SessionIndex) []Session {
+	sessions := []Session{}
+	start := 0
+	manualStart := false
+	for i := 1; i < len(entries); i++ {
+		split, manual := overrides[entries[i].StableID]
+		if !manual {
+			// Sessions too short to close keep growing
+			split = i-start >= minCommands && strategy.Split(entries[start:i], entries[i])
+		}
+		if !split {
+			continue
+		}
+		sessions = append(sessions, buildSession(entries[start:i], sessionIndex))
+		start = i
+		manualStart = manual
+	}
+	if manualStart || len(entries)-start >= minCommands {
+		sessions = append(sessions, buildSession(entries[start:], sessionIndex))
+	}
+	return sessions
+}
+
+// buildSession summarizes a run of commands into a session
+func buildSession(entries []HistoryEntry, sessionIndex *SessionIndex) Session {
+	first, last := entries[0], entries[len(entries)-1]
+	session := Session{
+		Host:       first.Host,
+		StartTime:  first.Timestamp,
+		EndTime:    last.Timestamp,
+		Duration:   last.Timestamp.Sub(first.Timestamp),
+		Commands:   append([]HistoryEntry{}, entries...),
+		Categories: make(map[CommandCategory]float64),
+	}
+
+	dirSet := make(map[string]bool)
+	for i := range session.Commands {
+		addLabelShares(session.Categories, &session.Commands[i])
+		dirSet[session.Commands[i].Directory] = true
+	}
+	session.Directories = getUniqueDirectories(dirSet)
+	session.Project = mainProject(session.Commands)
+	session.Description = generateSessionDescription(&session)
+
+	// Generate stable ID from first command
+	if sessionIndex != nil {
+		session.ID = sessionIndex.GetOrCreate(session.Host, session.StartTime, session.EndTime, first.Command, session.Description)
+		session.SequenceNumber = sessionIndex.GetSequenceNumber(session.ID)
+	} else {
+		session.ID = GenerateStableID(session.Host, session.StartTime, first.Command)
+	}
+
+	// Update all commands in this session with the stable ID
+	for j := range session.Commands {
+		session.Commands[j].SessionID = session.ID
+	}
+
+	return session
+}
+
 func getUniqueDirectories(dirSet map[string]bool) []string {
 	dirs := []string{}
 	for dir := range dirSet {
@@ -424,36 +528,33 @@ func extractTopActivities(session *Session) string {
 		return "work"
 	}
 	
-	// Count base commands (first word of each command)
-	cmdCounts := make(map[string]int)
-	for _, cmd := range session.Commands {
-		base := strings.ToLower(cmd.BaseCommand)
-		// Clean up base command (remove ./ prefix, etc.)
-		base = strings.TrimPrefix(base, "./")
-		if base != "" {
-			cmdCounts[base]++
-		}
-	}
-	
-	// Find top 2 commands
+	// Count base commands (first word of each command), in order of first use
 	type cmdCount struct {
 		cmd   string
 		count int
 	}
 	var topCmds []cmdCount
-	for cmd, count := range cmdCounts {
-		topCmds = append(topCmds, cmdCount{cmd, count})
-	}
-	
-	// Sort by count (simple bubble sort for small lists)
-	for i := 0; i < len(topCmds); i++ {
-		for j := i + 1; j < len(topCmds); j++ {
-			if topCmds[j].count > topCmds[i].count {
-				topCmds[i], topCmds[j] = topCmds[j], topCmds[i]
-			}
+	cmdIndex := make(map[string]int)
+	for _, cmd := range session.Commands {
+		base := strings.ToLower(cmd.BaseCommand)
+		// Clean up base command (remove ./ prefix, etc.)
+		base = strings.TrimPrefix(base, "./")
+		if base == "" {
+			continue
+		}
+		if i, ok := cmdIndex[base]; ok {
+			topCmds[i].count++
+		} else {
+			cmdIndex[base] = len(topCmds)
+			topCmds = append(topCmds, cmdCount{base, 1})
 		}
 	}
 	
+	// Sort by count, the first used first among equals
+	sort.SliceStable(topCmds, func(i, j int) bool {
+		return topCmds[i].count > topCmds[j].count
+	})
+	
 	// Build activity string
 	if len(topCmds) == 0 {
 		return "work"
@@ -501,6 +602,31 @@ func findMostActiveDirectory(session *Session) string {
 	return maxDir
 }
 
+// rankCategories returns the top-level categories of counts, or the
+// subcategories of parent when it is set, most counted first
+func rankCategories(counts map[CommandCategory]float64, parent CommandCategory) []CommandCategory {
+	var categories []CommandCategory
+	for cat := range counts {
+		if parent == "" && !cat.IsSubcategory() || parent != "" && cat.IsSubcategory() && cat.Parent() == parent {
+			categories = append(categories, cat)
+		}
+	}
+	sortCategories(categories)
+	sort.SliceStable(categories, func(i, j int) bool {
+		return counts[categories[i]] > counts[categories[j]]
+	})
+	return categories
+}
+
+// dominantSubcategory returns the subcategory of more than half of the
+// commands of category, or category when there is none
+func dominantSubcategory(counts map[CommandCategory]float64, category CommandCategory) CommandCategory {
+	if subs := rankCategories(counts, category); len(subs) > 0 && counts[subs[0]]*2 > counts[category] {
+		return subs[0]
+	}
+	return category
+}
+
 func generateSessionDescription(session *Session) string {
 	if len(session.Commands) == 0 {
 		return "Empty session"
@@ -520,34 +646,13 @@ func generateSessionDescription(session *Session) string {
 	
 	// Get primary categories
 	categoryStr := ""
-	if len(session.Categories) > 0 {
-		// Get top 2 categories by count
-		type catCount struct {
-			cat   CommandCategory
-			count int
-		}
-		var topCats []catCount
-		for cat, count := range session.Categories {
-			topCats = append(topCats, catCount{cat, count})
-		}
-		
-		// Sort by count
-		for i := 0; i < len(topCats); i++ {
-			for j := i + 1; j < len(topCats); j++ {
-				if topCats[j].count > topCats[i].count {
-					topCats[i], topCats[j] = topCats[j], topCats[i]
-				}
-			}
-		}
-		
-		// Take top 1-2 categories
-		if len(topCats) > 0 {
-			categoryStr = " [" + GetCategoryDisplayName(topCats[0].cat)
-			if len(topCats) > 1 && topCats[1].count >= len(session.Commands)/5 {
-				categoryStr += ", " + GetCategoryDisplayName(topCats[1].cat)
-			}
-			categoryStr += "]"
+	if topCats := rankCategories(session.Categories, ""); len(topCats) > 0 {
+		// Take top 1-2 categories, each as its subcategory when one dominates it
+		categoryStr = " [" + GetCategoryDisplayName(dominantSubcategory(session.Categories, topCats[0]))
+		if len(topCats) > 1 && session.Categories[topCats[1]] >= float64(len(session.Commands)/5) {
+			categoryStr += ", " + GetCategoryDisplayName(dominantSubcategory(session.Categories, topCats[1]))
 		}
+		categoryStr += "]"
 	}
 	
 	// Generate description: "shortDir: activities [categories]"
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProjectDefinition names the project of every directory under Path
+type ProjectDefinition struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// projectMarkers are the files and directories that mark the root of a
+// project, in the order they name it
+var projectMarkers = []string{"go.mod", "package.json", "Cargo.toml", ".git", ".hg"}
+
+// ProjectResolver maps working directories to the projects they belong to.
+// Projects defined in the config come first. Otherwise the nearest directory
+// up the tree with a project marker is the root, named after its manifest
+// when it has one and after the directory otherwise. The home directory and
+// those above it are never roots. Results are cached, so directories are
+// only looked at once.
+type ProjectResolver struct {
+	definitions []ProjectDefinition
+	homeDir     string
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func NewProjectResolver(definitions []ProjectDefinition, homeDir string) *ProjectResolver {
+	r := &ProjectResolver{homeDir: filepath.Clean(homeDir), cache: make(map[string]string)}
+	for _, definition := range definitions {
+		if definition.Name == "" || definition.Path == "" {
+			continue
+		}
+		definition.Path = filepath.Clean(expandHome(definition.Path, homeDir))
+		r.definitions = append(r.definitions, definition)
+	}
+	// The most specific definition wins
+	sort.SliceStable(r.definitions, func(i, j int) bool {
+		return len(r.definitions[i].Path) > len(r.definitions[j].Path)
+	})
+	return r
+}
+
+// Resolve returns the project of a directory, or "" when it has none
+func (r *ProjectResolver) Resolve(dir string) string {
+	if r == nil || !filepath.IsAbs(dir) {
+		return ""
+	}
+	dir = filepath.Clean(dir)
+
+	for _, definition := range r.definitions {
+		if isSubpath(dir, definition.Path) {
+			return definition.Name
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if project, ok := r.cache[dir]; ok {
+		return project
+	}
+
+	project := ""
+	for current := dir; !r.isAboveProjects(current); current = filepath.Dir(current) {
+		if name, ok := projectAt(current); ok {
+			project = name
+			break
+		}
+	}
+	r.cache[dir] = project
+	return project
+}
+
+// isAboveProjects reports whether dir is the home directory, one of its
+// parents, or the root of the file system
+func (r *ProjectResolver) isAboveProjects(dir string) bool {
+	if filepath.Dir(dir) == dir {
+		return true
+	}
+	return r.homeDir != "." && isSubpath(r.homeDir, dir)
+}
+
+// Assign sets the project of every entry from its directory
+func (r *ProjectResolver) Assign(entries []HistoryEntry) {
+	for i := range entries {
+		entries[i].Project = r.Resolve(entries[i].Directory)
+	}
+}
+
+// projectAt returns the name of the project rooted at dir, if dir has a
+// project marker
+func projectAt(dir string) (string, bool) {
+	for _, marker := range projectMarkers {
+		path := filepath.Join(dir, marker)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if name := manifestName(path); name != "" {
+			return name, true
+		}
+		return filepath.Base(dir), true
+	}
+	return "", false
+}
+
+// manifestName reads the name a go.mod, package.json or Cargo.toml gives
+// its project. It returns "" for other markers and unnamed manifests.
+func manifestName(path string) string {
+	switch filepath.Base(path) {
+	case "go.mod":
+		return goModuleName(path)
+	case "package.json":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		var manifest struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(data, &manifest) != nil {
+			return ""
+		}
+		return manifest.Name
+	case "Cargo.toml":
+		return cargoPackageName(path)
+	}
+	return ""
+}
+
+// goModuleName returns the last element of the module path, e.g. "cobra"
+// for github.com/spf13/cobra
+func goModuleName(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			module := strings.Trim(fields[1], `"`)
+			return module[strings.LastIndex(module, "/")+1:]
+		}
+	}
+	return ""
+}
+
+// cargoPackageName returns the name in the [package] table of a Cargo.toml.
+// Workspace manifests have none.
+func cargoPackageName(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	inPackage := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inPackage = line == "[package]"
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if inPackage && ok && strings.TrimSpace(key) == "name" {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return ""
+}
+
+// isSubpath reports whether path is dir or inside it
+func isSubpath(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// mainProject is the project most of the commands ran in, or "" when none
+// ran in a project. Of equals, the first to reach the count wins.
+func mainProject(commands []HistoryEntry) string {
+	counts := make(map[string]int)
+	main := ""
+	for _, cmd := range commands {
+		if cmd.Project == "" {
+			continue
+		}
+		counts[cmd.Project]++
+		if counts[cmd.Project] > counts[main] {
+			main = cmd.Project
+		}
+	}
+	return main
+}
+
+// sessionInProject reports whether any command of the session ran in project
+func sessionInProject(session *Session, project string) bool {
+	if session.Project == project {
+		return true
+	}
+	for _, cmd := range session.Commands {
+		if cmd.Project == project {
+			return true
+		}
+	}
+	return false
+}
+
+// ProjectSummary is the activity in one project
+type ProjectSummary struct {
+	Name             string    `json:"name"`
+	Commands         int       `json:"commands"`
+	Sessions         int       `json:"sessions"`
+	TimeSpentSeconds int64     `json:"time_spent_seconds"`
+	LastActivity     time.Time `json:"last_activity"`
+}
+
+// summarizeProjects totals the activity per project, most recently active
+// first. The time between two commands of a session counts towards the
+// project of the first.
+func summarizeProjects(sessions []Session) []ProjectSummary {
+	byName := make(map[string]*ProjectSummary)
+	get := func(name string) *ProjectSummary {
+		summary, ok := byName[name]
+		if !ok {
+			summary = &ProjectSummary{Name: name}
+			byName[name] = summary
+		}
+		return summary
+	}
+
+	for _, session := range sessions {
+		inSession := make(map[string]bool)
+		for i, cmd := range session.Commands {
+			if cmd.Project == "" {
+				continue
+			}
+			summary := get(cmd.Project)
+			summary.Commands++
+			if cmd.Timestamp.After(summary.LastActivity) {
+				summary.LastActivity = cmd.Timestamp
+			}
+			if i+1 < len(session.Commands) {
+				summary.TimeSpentSeconds += int64(session.Commands[i+1].Timestamp.Sub(cmd.Timestamp) / time.Second)
+			}
+			if !inSession[cmd.Project] {
+				inSession[cmd.Project] = true
+				summary.Sessions++
+			}
+		}
+	}
+
+	summaries := make([]ProjectSummary, 0, len(byName))
+	for _, summary := range byName {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if !summaries[i].LastActivity.Equal(summaries[j].LastActivity) {
+			return summaries[i].LastActivity.After(summaries[j].LastActivity)
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProjectResolver(t *testing.T) {
+	home := t.TempDir()
+	files := map[string]string{
+		"src/tool/go.mod":                 "// tool\nmodule github.com/example/tool\n\ngo 1.22\n",
+		"src/web/package.json":            `{"name": "storefront", "private": true}`,
+		"src/engine/Cargo.toml":           "[workspace]\nmembers = []\n\n[package]\nname = \"engine-core\"\nversion = \"0.1.0\"\n",
+		"src/scripts/.git/HEAD":           "ref: refs/heads/main\n",
+		"src/scripts/nested/package.json": `{"private": true}`,
+		".git/HEAD":                       "ref: refs/heads/main\n",
+		"work/infra/main.tf":              "",
+	}
+	for name, content := range files {
+		path := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(home, "src/tool/cmd/tool"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	r := NewProjectResolver([]ProjectDefinition{
+		{Name: "infra", Path: "~/work"},
+		{Name: "terraform", Path: "~/work/infra"},
+	}, home)
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"src/tool/cmd/tool", "tool"},
+		{"src/web", "storefront"},
+		{"src/engine", "engine-core"},
+		{"src/scripts", "scripts"},
+		{"src/scripts/nested", "nested"},
+		{"src/deleted/dir", ""},
+		{"work/infra", "terraform"},
+		{"work/notes", "infra"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(home, tt.dir)
+		if got := r.Resolve(dir); got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+
+	// The home directory is no project, even under version control
+	if got := r.Resolve(home); got != "" {
+		t.Errorf("Resolve(home) = %q, want none", got)
+	}
+	if got := r.Resolve("relative/dir"); got != "" {
+		t.Errorf("Resolve of a relative directory = %q, want none", got)
+	}
+
+	var none *ProjectResolver
+	if got := none.Resolve(filepath.Join(home, "src/web")); got != "" {
+		t.Errorf("nil resolver = %q, want none", got)
+	}
+}
+
+func TestSummarizeProjects(t *testing.T) {
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	command := func(minutes int, project string) HistoryEntry {
+		return HistoryEntry{Timestamp: base.Add(time.Duration(minutes) * time.Minute), Project: project}
+	}
+	sessions := []Session{
+		{Commands: []HistoryEntry{command(0, "api"), command(10, "api"), command(15, "web"), command(20, "")}},
+		{Commands: []HistoryEntry{command(120, "api"), command(125, "api")}},
+	}
+	for i := range sessions {
+		sessions[i].Project = mainProject(sessions[i].Commands)
+	}
+	if sessions[0].Project != "api" {
+		t.Errorf("Main project = %q, want api", sessions[0].Project)
+	}
+	if !sessionInProject(&sessions[0], "web") || sessionInProject(&sessions[1], "web") {
+		t.Errorf("sessionInProject doesn't match the projects of the commands")
+	}
+
+	summaries := summarizeProjects(sessions)
+	if len(summaries) != 2 || summaries[0].Name != "api" || summaries[1].Name != "web" {
+		t.Fatalf("Summaries = %+v, want api then web", summaries)
+	}
+	api := summaries[0]
+	if api.Commands != 4 || api.Sessions != 2 || api.TimeSpentSeconds != 20*60 || !api.LastActivity.Equal(base.Add(125*time.Minute)) {
+		t.Errorf("api summary = %+v", api)
+	}
+	if web := summaries[1]; web.Commands != 1 || web.TimeSpentSeconds != 5*60 {
+		t.Errorf("web summary = %+v", web)
+	}
+}
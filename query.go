@@ -0,0 +1,637 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SessionQuery selects sessions. Its text is a list of terms that must all
+// hold, such as
+//
+//	cat:vcs dir:~/code/api after:2025-01-01 tag:deploy stars>=3 "git push" -rebase
+//
+// Words must start words of the session's description or commands, and a
+// quoted phrase must appear as written. A term preceded by - must not hold,
+// terms separated by OR need only one to hold, and parentheses group them.
+// Fields are written name:value, or name>=value for stars:
+//
+//	cat:       category or subcategory, also by alias (vcs, db, ...)
+//	dir:       a command ran in the directory or below it, or in one matching a glob
+//	after:     started on or after a date (YYYY-MM-DD)
+//	before:    ended on or before a date
+//	host:      recorded on a host
+//	project:   has commands in a project
+//	tag:       the session or one of its commands has a tag with these words
+//	note:      the same for notes
+//	color:     the session's color
+//	stars:     the session's rating, compared with :, =, >, >=, < or <=
+//
+// Other words with a colon, like http://host, are searched as text.
+type SessionQuery struct {
+	root queryNode // nil matches every session
+
+	// CategoryShare is the share of a session's commands that cat: needs in
+	// the category, 0 for any
+	CategoryShare float64
+}
+
+// ParseSessionQuery parses the text of a query. Empty text matches every
+// session.
+func ParseSessionQuery(text string) (*SessionQuery, error) {
+	p := &queryParser{input: text}
+	p.skipSpace()
+	if p.done() {
+		return &SessionQuery{}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	return &SessionQuery{root: root}, nil
+}
+
+// Where adds a field term that must hold as well
+func (q *SessionQuery) Where(field, op, value string) error {
+	node, err := newFieldNode(field, op, value)
+	if err != nil {
+		return err
+	}
+	q.and(node)
+	return nil
+}
+
+// AndText adds the terms of query text that must hold as well
+func (q *SessionQuery) AndText(text string) error {
+	other, err := ParseSessionQuery(text)
+	if err != nil {
+		return err
+	}
+	if other.root != nil {
+		q.and(other.root)
+	}
+	return nil
+}
+
+func (q *SessionQuery) and(node queryNode) {
+	if and, ok := q.root.(andNode); ok {
+		q.root = append(and, node)
+	} else if q.root != nil {
+		q.root = andNode{q.root, node}
+	} else {
+		q.root = node
+	}
+}
+
+// queryEnv is what evaluating a query looks up besides the session itself
+type queryEnv struct {
+	index         *SearchIndex
+	metadata      *MetadataStore
+	homeDir       string
+	categoryShare float64
+
+	found   map[string]map[string]bool // sessions found in the index, by fields and text
+	globs   map[string]*regexp.Regexp  // dir: globs, with ~ expanded
+	ratings map[string]SessionMetadata // loaded on first use
+}
+
+func newQueryEnv(q *SessionQuery, index *SearchIndex, metadata *MetadataStore, homeDir string) *queryEnv {
+	return &queryEnv{
+		index:         index,
+		metadata:      metadata,
+		homeDir:       homeDir,
+		categoryShare: q.CategoryShare,
+		found:         make(map[string]map[string]bool),
+		globs:         make(map[string]*regexp.Regexp),
+	}
+}
+
+// Match reports whether a session matches the query
+func (q *SessionQuery) Match(env *queryEnv, session *Session) bool {
+	return q.root == nil || q.root.match(env, session)
+}
+
+// sessionsWith returns the sessions where every word of text is in one of fields
+func (env *queryEnv) sessionsWith(text string, fields ...string) map[string]bool {
+	key := strings.Join(fields, ",") + "\x00" + text
+	sessions, ok := env.found[key]
+	if !ok {
+		sessions = env.index.SessionMatches(text, fields...)
+		env.found[key] = sessions
+	}
+	return sessions
+}
+
+// glob returns the regexp of a directory glob
+func (env *queryEnv) glob(pattern string) *regexp.Regexp {
+	re, ok := env.globs[pattern]
+	if !ok {
+		re, _ = globRegexp(expandHome(pattern, env.homeDir))
+		env.globs[pattern] = re
+	}
+	return re
+}
+
+// rating returns the color and stars of a session
+func (env *queryEnv) rating(sessionID string) SessionMetadata {
+	if env.ratings == nil {
+		env.ratings = make(map[string]SessionMetadata)
+		if env.metadata != nil {
+			env.ratings = env.metadata.SessionRatings()
+		}
+	}
+	return env.ratings[sessionID]
+}
+
+type queryNode interface {
+	match(env *queryEnv, session *Session) bool
+}
+
+type andNode []queryNode
+
+func (n andNode) match(env *queryEnv, session *Session) bool {
+	for _, node := range n {
+		if !node.match(env, session) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []queryNode
+
+func (n orNode) match(env *queryEnv, session *Session) bool {
+	for _, node := range n {
+		if node.match(env, session) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct {
+	node queryNode
+}
+
+func (n notNode) match(env *queryEnv, session *Session) bool {
+	return !n.node.match(env, session)
+}
+
+// textNode is a word or quoted phrase searched in the description and
+// commands of a session
+type textNode struct {
+	text   string
+	phrase bool
+}
+
+func (n textNode) match(env *queryEnv, session *Session) bool {
+	if len(queryTerms(n.text)) == 0 {
+		// Only punctuation, like && or |, which the index leaves out
+		return sessionContains(session, strings.ToLower(n.text))
+	}
+	if !env.sessionsWith(n.text, FieldDescription, FieldCommand)[session.ID] {
+		return false
+	}
+	return !n.phrase || sessionContains(session, strings.ToLower(n.text))
+}
+
+// sessionContains reports whether the description or a command of a session
+// contains lowercased text
+func sessionContains(session *Session, text string) bool {
+	if strings.Contains(strings.ToLower(session.Description), text) {
+		return true
+	}
+	for _, cmd := range session.Commands {
+		if strings.Contains(strings.ToLower(cmd.Command), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryFields are the fields a query can name, with the operators they take
+var queryFields = map[string][]string{
+	"cat":     {":"},
+	"dir":     {":"},
+	"after":   {":"},
+	"before":  {":"},
+	"host":    {":"},
+	"project": {":"},
+	"tag":     {":"},
+	"note":    {":"},
+	"color":   {":"},
+	"stars":   {":", "=", ">=", "<=", ">", "<"},
+}
+
+// queryFieldAliases are longer names of fields
+var queryFieldAliases = map[string]string{
+	"category":  "cat",
+	"directory": "dir",
+	"since":     "after",
+	"until":     "before",
+	"rating":    "stars",
+}
+
+// categoryAliases are short names of the built-in categories
+var categoryAliases = map[string]CommandCategory{
+	"vcs":      CategoryVCS,
+	"files":    CategoryFileOps,
+	"fileops":  CategoryFileOps,
+	"nav":      CategoryNavigation,
+	"dev":      CategoryDevTools,
+	"admin":    CategorySystemAdmin,
+	"sysadmin": CategorySystemAdmin,
+	"net":      CategoryNetwork,
+	"docker":   CategoryContainers,
+	"db":       CategoryDatabase,
+	"pkg":      CategoryPackage,
+	"packages": CategoryPackage,
+}
+
+// fieldNode is a term on a field of the session
+type fieldNode struct {
+	field string
+	op    string
+	value string
+
+	category CommandCategory
+	glob     bool // dir: value has wildcards
+	date     time.Time
+	stars    int
+}
+
+func newFieldNode(field, op, value string) (queryNode, error) {
+	field = strings.ToLower(field)
+	if alias, ok := queryFieldAliases[field]; ok {
+		field = alias
+	}
+	ops, ok := queryFields[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", field)
+	}
+	if !containsString(ops, op) {
+		return nil, fmt.Errorf("%s doesn't take %s", field, op)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("%s%s needs a value", field, op)
+	}
+
+	n := fieldNode{field: field, op: op, value: value}
+	switch field {
+	case "cat":
+		n.category = resolveCategory(value)
+	case "dir":
+		n.glob = strings.ContainsAny(value, "*?")
+	case "after", "before":
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: wants a date like 2025-01-31, not %q", field, value)
+		}
+		n.date = date
+	case "stars":
+		stars, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("stars wants a number, not %q", value)
+		}
+		n.stars = stars
+	}
+	return n, nil
+}
+
+// resolveCategory turns a category name or alias, or category/subcategory,
+// into the category
+func resolveCategory(value string) CommandCategory {
+	value = strings.ToLower(value)
+	parent, sub, _ := strings.Cut(value, "/")
+	if alias, ok := categoryAliases[parent]; ok {
+		parent = string(alias)
+	}
+	if sub != "" {
+		return CommandCategory(parent + "/" + sub)
+	}
+	return CommandCategory(parent)
+}
+
+func (n fieldNode) match(env *queryEnv, session *Session) bool {
+	switch n.field {
+	case "cat":
+		return sessionHasCategory(session, n.category, env.categoryShare)
+	case "dir":
+		return n.matchDirectory(env, session)
+	case "after":
+		return !session.StartTime.Before(n.date)
+	case "before":
+		// The whole day is included
+		return !session.EndTime.After(n.date.Add(24 * time.Hour))
+	case "host":
+		return strings.EqualFold(session.Host, n.value)
+	case "project":
+		return sessionInProject(session, n.value)
+	case "tag":
+		return env.sessionsWith(n.value, FieldTag)[session.ID]
+	case "note":
+		return env.sessionsWith(n.value, FieldNote)[session.ID]
+	case "color":
+		return strings.EqualFold(strings.TrimPrefix(env.rating(session.ID).ColorCode, "#"), strings.TrimPrefix(n.value, "#"))
+	case "stars":
+		stars := env.rating(session.ID).StarRating
+		switch n.op {
+		case ">=":
+			return stars >= n.stars
+		case "<=":
+			return stars <= n.stars
+		case ">":
+			return stars > n.stars
+		case "<":
+			return stars < n.stars
+		default:
+			return stars == n.stars
+		}
+	}
+	return false
+}
+
+// matchDirectory reports whether a command of the session ran in the
+// directory, or below it, or in a directory matching the glob
+func (n fieldNode) matchDirectory(env *queryEnv, session *Session) bool {
+	if n.glob {
+		glob := env.glob(n.value)
+		for _, cmd := range session.Commands {
+			if glob != nil && glob.MatchString(cmd.Directory) {
+				return true
+			}
+		}
+		return false
+	}
+
+	dir := expandHome(n.value, env.homeDir)
+	below := strings.TrimSuffix(dir, "/") + "/"
+	for _, cmd := range session.Commands {
+		if cmd.Directory == dir || strings.HasPrefix(cmd.Directory, below) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryParser is a recursive descent parser of query text:
+//
+//	or    = and { ("OR" | "|") and }
+//	and   = unary { unary }
+//	unary = "-" unary | "(" or ")" | '"' phrase '"' | term
+//	term  = field op value | word
+type queryParser struct {
+	input string
+	pos   int
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// orOperator returns the OR operator the input continues with, OR or |, or ""
+func (p *queryParser) orOperator() string {
+	rest := p.input[p.pos:]
+	for _, op := range []string{"OR", "|"} {
+		if strings.HasPrefix(rest, op) {
+			if next := rest[len(op):]; next == "" || unicode.IsSpace(rune(next[0])) || next[0] == '(' {
+				return op
+			}
+		}
+	}
+	return ""
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	var nodes orNode
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		op := p.orOperator()
+		if op == "" {
+			break
+		}
+		p.pos += len(op)
+		p.skipSpace()
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes andNode
+	for !p.done() && p.input[p.pos] != ')' && p.orOperator() == "" {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		p.skipSpace()
+	}
+	switch len(nodes) {
+	case 0:
+		if p.done() {
+			return nil, fmt.Errorf("query ends where a term was expected")
+		}
+		return nil, fmt.Errorf("expected a term at position %d", p.pos+1)
+	case 1:
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	switch {
+	case p.input[p.pos] == '-' && p.pos+1 < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos+1])):
+		p.pos++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	case p.input[p.pos] == '(':
+		start := p.pos
+		p.pos++
+		p.skipSpace()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.done() || p.input[p.pos] != ')' {
+			return nil, fmt.Errorf("parenthesis at position %d isn't closed", start+1)
+		}
+		p.pos++
+		return node, nil
+	case p.input[p.pos] == '"':
+		phrase, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return textNode{text: phrase, phrase: true}, nil
+	}
+	return p.parseTerm()
+}
+
+// parseQuoted reads a quoted string. Inside it, \" is a quote.
+func (p *queryParser) parseQuoted() (string, error) {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '"':
+			b.WriteByte('"')
+			p.pos += 2
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("quote at position %d isn't closed", start+1)
+}
+
+// parseTerm reads a field term, or a word up to the next space or parenthesis
+func (p *queryParser) parseTerm() (queryNode, error) {
+	start := p.pos
+	for !p.done() && unicode.IsLetter(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	name := strings.ToLower(p.input[start:p.pos])
+	if alias, ok := queryFieldAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := queryFields[name]; ok {
+		if op := p.parseOperator(); op != "" {
+			var value string
+			if !p.done() && p.input[p.pos] == '"' {
+				quoted, err := p.parseQuoted()
+				if err != nil {
+					return nil, err
+				}
+				value = quoted
+			} else {
+				value = p.parseWord()
+			}
+			return newFieldNode(name, op, value)
+		}
+	}
+
+	p.pos = start
+	return textNode{text: p.parseWord()}, nil
+}
+
+// parseOperator reads the operator after a field name
+func (p *queryParser) parseOperator() string {
+	for _, op := range []string{">=", "<=", ":", "=", ">", "<"} {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *queryParser) parseWord() string {
+	start := p.pos
+	for !p.done() {
+		c := p.input[p.pos]
+		if unicode.IsSpace(rune(c)) || c == '(' || c == ')' {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// sessionQueryParams are the older filter parameters of /api/sessions and
+// /api/export, under both their spellings, with the field each sets
+var sessionQueryParams = []struct {
+	names []string
+	field string
+	op    string
+}{
+	{[]string{"start_date", "startDate"}, "after", ":"},
+	{[]string{"end_date", "endDate"}, "before", ":"},
+	{[]string{"category"}, "cat", ":"},
+	{[]string{"host"}, "host", ":"},
+	{[]string{"project"}, "project", ":"},
+	{[]string{"tag_keyword", "tagKeyword"}, "tag", ":"},
+	{[]string{"tag_color", "tagColor"}, "color", ":"},
+	{[]string{"tag_stars", "tagStars"}, "stars", ">="},
+	{[]string{"note_search", "noteSearch"}, "note", ":"},
+}
+
+// sessionQueryFromParams reads the query in q and keyword, and the older
+// filter parameters, which add field terms. "all" and empty parameters, and
+// tag_stars of 0, don't filter.
+func sessionQueryFromParams(get func(string) string) (*SessionQuery, error) {
+	query, err := ParseSessionQuery(get("q"))
+	if err != nil {
+		return nil, err
+	}
+	if err := query.AndText(get("keyword")); err != nil {
+		return nil, err
+	}
+	for _, param := range sessionQueryParams {
+		for _, name := range param.names {
+			value := strings.TrimSpace(get(name))
+			if value == "" || value == "all" || param.field == "stars" && value == "0" {
+				continue
+			}
+			if err := query.Where(param.field, param.op, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			break
+		}
+	}
+	for _, name := range []string{"category_share", "categoryShare"} {
+		if value := get(name); value != "" {
+			share, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s wants a number, not %q", name, value)
+			}
+			query.CategoryShare = share
+			break
+		}
+	}
+	return query, nil
+}
+
+// querySessions returns the sessions matching a query. Caller must hold
+// the lock.
+func (s *Server) querySessions(q *SessionQuery) []*Session {
+	homeDir := ""
+	if s.config != nil {
+		homeDir = s.config.HomeDir
+	}
+	env := newQueryEnv(q, s.index, s.metadata, homeDir)
+
+	sessions := make([]*Session, 0)
+	for i := range s.sessions {
+		if q.Match(env, &s.sessions[i]) {
+			sessions = append(sessions, &s.sessions[i])
+		}
+	}
+	return sessions
+}
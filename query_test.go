@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// querySession is a session with one command per line, all categorized as
+// category, in directory dir
+func querySession(id, host, description string, day int, category CommandCategory, dir string, commands ...string) Session {
+	start := time.Date(2025, 1, day, 9, 0, 0, 0, time.UTC)
+	session := Session{
+		ID:          id,
+		Host:        host,
+		StartTime:   start,
+		EndTime:     start.Add(time.Hour),
+		Description: description,
+		Categories:  map[CommandCategory]float64{category: float64(len(commands))},
+	}
+	for i, command := range commands {
+		session.Commands = append(session.Commands, HistoryEntry{
+			ID:        i + 1,
+			StableID:  fmt.Sprintf("cmd_%s_%d", id, i),
+			SessionID: id,
+			Command:   command,
+			Directory: dir,
+			Category:  category,
+		})
+	}
+	return session
+}
+
+func newQueryTestServer(t *testing.T) *Server {
+	t.Helper()
+	metadata := &MetadataStore{
+		Version:          metadataVersion,
+		Notes:            make(map[string]Note),
+		Tags:             make(map[string]Tag),
+		SessionMetadatas: make(map[string]SessionMetadata),
+		filePath:         filepath.Join(t.TempDir(), "metadata.json"),
+	}
+	s := &Server{
+		config:   &Config{HomeDir: "/home/me"},
+		metadata: metadata,
+		sessions: []Session{
+			querySession("sess_a", "laptop", "Pushing the API", 2, CategoryVCS, "/home/me/code/api",
+				"git pull --rebase", "git push origin main"),
+			querySession("sess_b", "laptop", "Building", 10, CategoryBuild, "/home/me/code/web",
+				"make build", "go test ./... && git push"),
+			querySession("sess_c", "server", "Deploying", 20, CategoryContainers, "/srv/app",
+				"docker compose up -d", "docker ps | grep app"),
+		},
+	}
+	if _, err := metadata.AddTag(TargetSession, "sess_c", "deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadata.AddNote(TargetCommand, "cmd_sess_b_0", "flaky build on CI"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadata.SetSessionMetadata(TargetSession, "sess_a", "#FF0000", 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadata.SetSessionMetadata(TargetSession, "sess_c", "", 2); err != nil {
+		t.Fatal(err)
+	}
+	s.reindex()
+	return s
+}
+
+func sessionIDs(sessions []*Session) []string {
+	ids := make([]string, len(sessions))
+	for i, session := range sessions {
+		ids[i] = session.ID
+	}
+	return ids
+}
+
+func TestSessionQuery(t *testing.T) {
+	s := newQueryTestServer(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"sess_a", "sess_b", "sess_c"}},
+		{"git push", []string{"sess_a", "sess_b"}},
+		{`"git push"`, []string{"sess_a", "sess_b"}},
+		{`"push origin"`, []string{"sess_a"}},
+		{"push -rebase", []string{"sess_b"}},
+		{"docker OR make", []string{"sess_b", "sess_c"}},
+		{"(docker | rebase) -host:server", []string{"sess_a"}},
+		{"cat:vcs", []string{"sess_a"}},
+		{"category:containers OR cat:build", []string{"sess_b", "sess_c"}},
+		{"dir:~/code", []string{"sess_a", "sess_b"}},
+		{"dir:~/code/api/", []string{"sess_a"}},
+		{"dir:~/co", []string{}},
+		{"dir:/srv/*", []string{"sess_c"}},
+		{"after:2025-01-10", []string{"sess_b", "sess_c"}},
+		{"after:2025-01-03 before:2025-01-10", []string{"sess_b"}},
+		{"tag:deploy", []string{"sess_c"}},
+		{"note:flaky", []string{"sess_b"}},
+		{"stars>=3", []string{"sess_a"}},
+		{"stars<3", []string{"sess_b", "sess_c"}},
+		{"stars:2", []string{"sess_c"}},
+		{"color:ff0000", []string{"sess_a"}},
+		{"&&", []string{"sess_b"}},
+		{"https://example.com", []string{}},
+		{"-(git OR docker)", []string{}},
+	}
+	for _, tt := range tests {
+		query, err := ParseSessionQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseSessionQuery(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if got := sessionIDs(s.querySessions(query)); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Sessions for %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseSessionQueryErrors(t *testing.T) {
+	for _, text := range []string{
+		`"git push`,
+		"(git push",
+		"git)",
+		"OR git",
+		"git OR",
+		"after:yesterday",
+		"stars>=many",
+		"cat>=build",
+		"tag:",
+	} {
+		if _, err := ParseSessionQuery(text); err == nil {
+			t.Errorf("ParseSessionQuery(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestSessionQueryFromParams(t *testing.T) {
+	s := newQueryTestServer(t)
+
+	tests := []struct {
+		params string
+		want   []string
+	}{
+		{"keyword=git+-rebase&host=all", []string{"sess_b"}},
+		{"q=git&start_date=2025-01-05", []string{"sess_b"}},
+		{"q=git&startDate=2025-01-05", []string{"sess_b"}},
+		{"category=containers&tag_keyword=deploy", []string{"sess_c"}},
+		{"tagStars=3&category=all", []string{"sess_a"}},
+		{"noteSearch=flaky", []string{"sess_b"}},
+		{"tag_stars=0", []string{"sess_a", "sess_b", "sess_c"}},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.params)
+		query, err := sessionQueryFromParams(values.Get)
+		if err != nil {
+			t.Errorf("sessionQueryFromParams(%s) failed: %v", tt.params, err)
+			continue
+		}
+		if got := sessionIDs(s.querySessions(query)); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Sessions for %s = %v, want %v", tt.params, got, tt.want)
+		}
+	}
+
+	values, _ := url.ParseQuery("end_date=soon")
+	if _, err := sessionQueryFromParams(values.Get); err == nil {
+		t.Errorf("Expected an error for an invalid end_date")
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// minPrefixLength is the shortest query term that also matches the longer
+// terms it starts, so "dock" finds docker but "d" only finds d
+const minPrefixLength = 2
+
+// Search fields
+const (
+	FieldCommand         = "command"
+	FieldExpandedCommand = "expanded_command"
+	FieldDirectory       = "directory"
+	FieldDescription     = "description"
+	FieldNote            = "note"
+	FieldTag             = "tag"
+)
+
+// SearchField is one text of a document. Notes and tags carry their ID.
+type SearchField struct {
+	Name string
+	ID   string
+	Text string
+}
+
+// SearchDocument is a command or session as the index sees it
+type SearchDocument struct {
+	ID      string // stable ID of the command or session
+	Kind    TargetType
+	Session string // session of a command, or the session itself
+	Ref     int    // where the caller keeps it, such as a command's entry ID
+	Fields  []SearchField
+}
+
+// SearchMatch is where a query term matched, as character offsets into the
+// text of a field
+type SearchMatch struct {
+	Field string `json:"field"`
+	ID    string `json:"id,omitempty"` // of the note or tag
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// SearchHit is a document matching every term of a query, with its BM25 score
+type SearchHit struct {
+	ID      string
+	Ref     int
+	Score   float64
+	Matches []SearchMatch
+}
+
+// posting is one occurrence of a term
+type posting struct {
+	doc   int32
+	field uint16 // index into the document's fields
+	start int32
+	end   int32
+}
+
+type indexedDocument struct {
+	SearchDocument
+	length int // number of tokens
+	live   bool
+}
+
+// SearchIndex is an inverted index over the tokens of commands, session
+// descriptions, notes and tags. Documents are added and replaced one at a
+// time, so the index follows the history as it grows, and a query only
+// looks at the postings of its terms. Query terms match the terms they are
+// a prefix of, and a document must contain every term of a query.
+type SearchIndex struct {
+	mu       sync.RWMutex
+	docs     []indexedDocument
+	byID     map[string]int32
+	postings map[string][]posting
+	terms    []string // sorted, for prefix lookups
+	unsorted bool     // terms were added since they were sorted
+	dead     int      // replaced documents whose postings are still listed
+	stats    map[TargetType]*kindStats
+	freq     map[termKind]int // live documents containing each term
+}
+
+type termKind struct {
+	term string
+	kind TargetType
+}
+
+// kindStats are the document count and total length of one kind of document
+type kindStats struct {
+	docs   int
+	tokens int
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		byID:     make(map[string]int32),
+		postings: make(map[string][]posting),
+		stats:    make(map[TargetType]*kindStats),
+		freq:     make(map[termKind]int),
+	}
+}
+
+// Update adds documents, replacing those with the same ID. A document whose
+// fields didn't change is left as it is, so updating with the whole history
+// only indexes what is new.
+func (x *SearchIndex) Update(docs []SearchDocument) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, doc := range docs {
+		x.put(doc)
+	}
+	x.finish()
+}
+
+// Retain removes the documents whose IDs keep doesn't report
+func (x *SearchIndex) Retain(keep func(id string) bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for id, n := range x.byID {
+		if !keep(id) {
+			x.remove(n)
+		}
+	}
+	x.finish()
+}
+
+// Annotate replaces the notes and tags of a document
+func (x *SearchIndex) Annotate(id string, notes []Note, tags []Tag) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	n, ok := x.byID[id]
+	if !ok {
+		return
+	}
+	doc := x.docs[n].SearchDocument
+	doc.Fields = append(contentFields(doc.Fields), annotationFields(notes, tags)...)
+	x.put(doc)
+	x.finish()
+}
+
+// Len returns the number of documents
+func (x *SearchIndex) Len() int {
+	if x == nil {
+		return 0
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.byID)
+}
+
+func (x *SearchIndex) put(doc SearchDocument) {
+	if n, ok := x.byID[doc.ID]; ok {
+		if sameFields(x.docs[n].Fields, doc.Fields) {
+			x.docs[n].Session = doc.Session
+			x.docs[n].Ref = doc.Ref
+			return
+		}
+		x.remove(n)
+	}
+
+	n := int32(len(x.docs))
+	length := 0
+	seen := make(map[string]bool)
+	for f, field := range doc.Fields {
+		for _, token := range tokenizeText(field.Text) {
+			if _, ok := x.postings[token.text]; !ok {
+				x.terms = append(x.terms, token.text)
+				x.unsorted = true
+			}
+			x.postings[token.text] = append(x.postings[token.text], posting{n, uint16(f), int32(token.start), int32(token.end)})
+			if !seen[token.text] {
+				seen[token.text] = true
+				x.freq[termKind{token.text, doc.Kind}]++
+			}
+			length++
+		}
+	}
+	x.docs = append(x.docs, indexedDocument{SearchDocument: doc, length: length, live: true})
+	x.byID[doc.ID] = n
+
+	stats := x.kindStats(doc.Kind)
+	stats.docs++
+	stats.tokens += length
+}
+
+func (x *SearchIndex) remove(n int32) {
+	doc := &x.docs[n]
+	stats := x.kindStats(doc.Kind)
+	stats.docs--
+	stats.tokens -= doc.length
+	seen := make(map[string]bool)
+	for _, field := range doc.Fields {
+		for _, token := range tokenizeText(field.Text) {
+			if !seen[token.text] {
+				seen[token.text] = true
+				x.freq[termKind{token.text, doc.Kind}]--
+			}
+		}
+	}
+	delete(x.byID, doc.ID)
+	doc.live = false
+	doc.Fields = nil
+	x.dead++
+}
+
+func (x *SearchIndex) kindStats(kind TargetType) *kindStats {
+	stats, ok := x.stats[kind]
+	if !ok {
+		stats = &kindStats{}
+		x.stats[kind] = stats
+	}
+	return stats
+}
+
+// finish sorts new terms and drops the postings of replaced documents once
+// they outnumber the live ones
+func (x *SearchIndex) finish() {
+	if x.dead > len(x.byID) {
+		x.compact()
+	}
+	if x.unsorted {
+		sort.Strings(x.terms)
+		x.unsorted = false
+	}
+}
+
+// compact renumbers the live documents and rebuilds their postings
+func (x *SearchIndex) compact() {
+	docs := x.docs
+	x.docs = nil
+	x.byID = make(map[string]int32)
+	x.postings = make(map[string][]posting)
+	x.terms = nil
+	x.stats = make(map[TargetType]*kindStats)
+	x.freq = make(map[termKind]int)
+	x.dead = 0
+	for _, doc := range docs {
+		if doc.live {
+			x.put(doc.SearchDocument)
+		}
+	}
+}
+
+// Search returns the documents of kind containing every term of query, best
+// first. Equal scores list the most recently added first.
+func (x *SearchIndex) Search(query string, kind TargetType) []SearchHit {
+	if x == nil {
+		return nil
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	stats := x.stats[kind]
+	if stats == nil || stats.docs == 0 {
+		return nil
+	}
+	avgLength := float64(stats.tokens) / float64(stats.docs)
+
+	// Start with the rarest term, so later ones only check its documents
+	expanded := make([][]string, len(terms))
+	df := make([]int, len(terms))
+	for i, term := range terms {
+		expanded[i] = x.expand(term)
+		for _, t := range expanded[i] {
+			df[i] += x.freq[termKind{t, kind}]
+		}
+	}
+	order := make([]int, len(terms))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return df[order[i]] < df[order[j]] })
+
+	scores := make(map[int32]float64)
+	found := make(map[int32][]posting)
+	for i, t := range order {
+		tf := make(map[int32]int) // documents with this and the earlier terms
+		for _, p := range x.postingsOf(expanded[t]) {
+			doc := &x.docs[p.doc]
+			if !doc.live || doc.Kind != kind {
+				continue
+			}
+			if _, ok := scores[p.doc]; !ok && i > 0 {
+				continue
+			}
+			tf[p.doc]++
+			found[p.doc] = append(found[p.doc], p)
+		}
+
+		for n := range scores {
+			if _, ok := tf[n]; !ok {
+				delete(scores, n)
+				delete(found, n)
+			}
+		}
+		if len(tf) == 0 {
+			return nil
+		}
+		idf := math.Log(1 + (float64(stats.docs)-float64(df[t])+0.5)/(float64(df[t])+0.5))
+		for n, count := range tf {
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(x.docs[n].length)/avgLength)
+			scores[n] += idf * float64(count) * (bm25K1 + 1) / (float64(count) + norm)
+		}
+	}
+
+	ranked := make([]int32, 0, len(scores))
+	for n := range scores {
+		ranked = append(ranked, n)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		return ranked[i] > ranked[j]
+	})
+	hits := make([]SearchHit, len(ranked))
+	for i, n := range ranked {
+		hits[i] = SearchHit{ID: x.docs[n].ID, Ref: x.docs[n].Ref, Score: scores[n], Matches: x.matches(n, found[n])}
+	}
+	return hits
+}
+
+// matches converts the postings found in a document to matches, in the
+// order of its fields
+func (x *SearchIndex) matches(n int32, postings []posting) []SearchMatch {
+	sort.Slice(postings, func(i, j int) bool {
+		if postings[i].field != postings[j].field {
+			return postings[i].field < postings[j].field
+		}
+		return postings[i].start < postings[j].start
+	})
+	matches := make([]SearchMatch, len(postings))
+	for i, p := range postings {
+		field := x.docs[n].Fields[p.field]
+		matches[i] = SearchMatch{
+			Field: field.Name,
+			ID:    field.ID,
+			Start: runeOffset(field.Text, int(p.start)),
+			End:   runeOffset(field.Text, int(p.end)),
+		}
+	}
+	return matches
+}
+
+// SessionMatches returns the sessions in which every term of query appears,
+// in the session's own document or in one of its commands, counting only
+// the given fields
+func (x *SearchIndex) SessionMatches(query string, fields ...string) map[string]bool {
+	if x == nil {
+		return make(map[string]bool)
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var result map[string]bool
+	for _, term := range queryTerms(query) {
+		sessions := make(map[string]bool)
+		for _, p := range x.postingsOf(x.expand(term)) {
+			doc := &x.docs[p.doc]
+			if !doc.live || doc.Session == "" || len(fields) > 0 && !containsString(fields, doc.Fields[p.field].Name) {
+				continue
+			}
+			if result == nil || result[doc.Session] {
+				sessions[doc.Session] = true
+			}
+		}
+		result = sessions
+		if len(result) == 0 {
+			break
+		}
+	}
+	if result == nil {
+		result = make(map[string]bool)
+	}
+	return result
+}
+
+// expand returns term and, when it is long enough, every indexed term it
+// is a prefix of
+func (x *SearchIndex) expand(term string) []string {
+	if len(term) < minPrefixLength {
+		return []string{term}
+	}
+	start := sort.SearchStrings(x.terms, term)
+	end := start
+	for end < len(x.terms) && strings.HasPrefix(x.terms[end], term) {
+		end++
+	}
+	return x.terms[start:end]
+}
+
+// postingsOf returns the postings of terms
+func (x *SearchIndex) postingsOf(terms []string) []posting {
+	if len(terms) == 1 {
+		return x.postings[terms[0]]
+	}
+	var postings []posting
+	for _, term := range terms {
+		postings = append(postings, x.postings[term]...)
+	}
+	return postings
+}
+
+// searchToken is a lowercased word of a text with its byte offsets
+type searchToken struct {
+	text       string
+	start, end int
+}
+
+// tokenizeText splits text into runs of letters and digits, lowercased
+func tokenizeText(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			tokens = append(tokens, searchToken{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// queryTerms returns the distinct terms of a query
+func queryTerms(query string) []string {
+	var terms []string
+	for _, token := range tokenizeText(query) {
+		if !containsString(terms, token.text) {
+			terms = append(terms, token.text)
+		}
+	}
+	return terms
+}
+
+func sameFields(a, b []SearchField) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// contentFields drops the notes and tags of a document's fields
+func contentFields(fields []SearchField) []SearchField {
+	var content []SearchField
+	for _, field := range fields {
+		if field.Name != FieldNote && field.Name != FieldTag {
+			content = append(content, field)
+		}
+	}
+	return content
+}
+
+// annotationFields returns the fields of notes and tags, ordered by ID so
+// that unchanged annotations compare equal
+func annotationFields(notes []Note, tags []Tag) []SearchField {
+	var fields []SearchField
+	for _, note := range notes {
+		fields = append(fields, SearchField{Name: FieldNote, ID: note.ID, Text: note.Text})
+	}
+	for _, tag := range tags {
+		fields = append(fields, SearchField{Name: FieldTag, ID: tag.ID, Text: tag.Keyword})
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].Name != fields[j].Name {
+			return fields[i].Name == FieldNote
+		}
+		return fields[i].ID < fields[j].ID
+	})
+	return fields
+}
+
+// commandDocument is the search document of a command
+func commandDocument(entry *HistoryEntry, notes []Note, tags []Tag) SearchDocument {
+	fields := []SearchField{{Name: FieldCommand, Text: entry.Command}}
+	if entry.ExpandedCommand != "" {
+		fields = append(fields, SearchField{Name: FieldExpandedCommand, Text: entry.ExpandedCommand})
+	}
+	fields = append(fields, SearchField{Name: FieldDirectory, Text: entry.Directory})
+	return SearchDocument{
+		ID:      entry.StableID,
+		Kind:    TargetCommand,
+		Session: entry.SessionID,
+		Ref:     entry.ID,
+		Fields:  append(fields, annotationFields(notes, tags)...),
+	}
+}
+
+// sessionDocument is the search document of a session
+func sessionDocument(session *Session, notes []Note, tags []Tag) SearchDocument {
+	return SearchDocument{
+		ID:      session.ID,
+		Kind:    TargetSession,
+		Session: session.ID,
+		Fields:  append([]SearchField{{Name: FieldDescription, Text: session.Description}}, annotationFields(notes, tags)...),
+	}
+}
+
+// runeOffset converts a byte offset into text to a character offset
+func runeOffset(text string, offset int) int {
+	return utf8.RuneCountInString(text[:offset])
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func commandDoc(id, session, command string) SearchDocument {
+	return SearchDocument{ID: id, Kind: TargetCommand, Session: session, Fields: []SearchField{{Name: FieldCommand, Text: command}}}
+}
+
+func hitIDs(hits []SearchHit) []string {
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids
+}
+
+func TestSearchIndexRanking(t *testing.T) {
+	index := NewSearchIndex()
+	index.Update([]SearchDocument{
+		commandDoc("cmd_1", "sess_1", "docker run --rm -v /data:/data postgres"),
+		commandDoc("cmd_2", "sess_1", "docker ps"),
+		commandDoc("cmd_3", "sess_2", "git commit -m 'run docker in the ci pipeline'"),
+		commandDoc("cmd_4", "sess_2", "docker run docker run"),
+		commandDoc("cmd_5", "sess_2", "ls"),
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"docker run", []string{"cmd_4", "cmd_1", "cmd_3"}},
+		{"DOCK", []string{"cmd_4", "cmd_2", "cmd_1", "cmd_3"}}, // prefix of docker
+		{"postgres data", []string{"cmd_1"}},
+		{"docker missing", []string{}},
+		{"l", []string{}}, // single letters only match whole words
+		{"ls", []string{"cmd_5"}},
+		{"  ", []string{}},
+	}
+	for _, tt := range tests {
+		if got := hitIDs(index.Search(tt.query, TargetCommand)); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+	if hits := index.Search("docker", TargetSession); len(hits) != 0 {
+		t.Errorf("Search(docker) over sessions = %v", hitIDs(hits))
+	}
+}
+
+func TestSearchIndexMatches(t *testing.T) {
+	index := NewSearchIndex()
+	index.Update([]SearchDocument{{
+		ID:   "cmd_1",
+		Kind: TargetCommand,
+		Fields: []SearchField{
+			{Name: FieldCommand, Text: "echo «café» && cat café.txt"},
+			{Name: FieldNote, ID: "n1", Text: "Café menu"},
+		},
+	}})
+
+	hits := index.Search("caf", TargetCommand)
+	if len(hits) != 1 {
+		t.Fatalf("Search(caf) = %v", hits)
+	}
+	want := []SearchMatch{
+		{Field: FieldCommand, Start: 6, End: 10},
+		{Field: FieldCommand, Start: 19, End: 23},
+		{Field: FieldNote, ID: "n1", Start: 0, End: 4},
+	}
+	if fmt.Sprint(hits[0].Matches) != fmt.Sprint(want) {
+		t.Errorf("Matches = %v, want %v", hits[0].Matches, want)
+	}
+}
+
+func TestSearchIndexUpdates(t *testing.T) {
+	index := NewSearchIndex()
+	index.Update([]SearchDocument{
+		commandDoc("cmd_1", "sess_1", "make build"),
+		commandDoc("cmd_2", "sess_1", "make test"),
+	})
+
+	// Unchanged documents only move to their new session
+	index.Update([]SearchDocument{
+		commandDoc("cmd_1", "sess_2", "make build"),
+		commandDoc("cmd_2", "sess_1", "go test ./..."),
+	})
+	if got := hitIDs(index.Search("make", TargetCommand)); fmt.Sprint(got) != "[cmd_1]" {
+		t.Errorf("Search(make) after update = %v", got)
+	}
+	if got := index.SessionMatches("build"); !got["sess_2"] || got["sess_1"] {
+		t.Errorf("SessionMatches(build) = %v", got)
+	}
+
+	index.Annotate("cmd_1", []Note{{ID: "n1", Text: "release build"}}, []Tag{{ID: "t1", Keyword: "deploy"}})
+	index.Annotate("cmd_missing", []Note{{ID: "n2", Text: "nowhere"}}, nil)
+	if got := hitIDs(index.Search("release", TargetCommand)); fmt.Sprint(got) != "[cmd_1]" {
+		t.Errorf("Search(release) after annotate = %v", got)
+	}
+	index.Annotate("cmd_1", nil, nil)
+	if got := index.Search("release", TargetCommand); len(got) != 0 {
+		t.Errorf("Search(release) after removing the note = %v", hitIDs(got))
+	}
+	if got := hitIDs(index.Search("make build", TargetCommand)); fmt.Sprint(got) != "[cmd_1]" {
+		t.Errorf("Search(make build) after annotate = %v", got)
+	}
+
+	index.Retain(func(id string) bool { return id != "cmd_1" })
+	if index.Len() != 1 || len(index.Search("make", TargetCommand)) != 0 {
+		t.Errorf("Len() = %d after Retain, want 1", index.Len())
+	}
+
+	// Replacing documents many times compacts the postings
+	for i := 0; i < 10; i++ {
+		index.Update([]SearchDocument{commandDoc("cmd_2", "sess_1", fmt.Sprintf("go test -count=%d", i))})
+	}
+	if index.dead > index.Len() {
+		t.Errorf("%d dead documents for %d live ones", index.dead, index.Len())
+	}
+	if got := hitIDs(index.Search("go test 9", TargetCommand)); fmt.Sprint(got) != "[cmd_2]" {
+		t.Errorf("Search(go test 9) after compaction = %v", got)
+	}
+}
+
+func TestSessionMatches(t *testing.T) {
+	index := NewSearchIndex()
+	index.Update([]SearchDocument{
+		{ID: "sess_1", Kind: TargetSession, Session: "sess_1", Fields: []SearchField{
+			{Name: FieldDescription, Text: "Kubernetes deploy"},
+			{Name: FieldTag, ID: "t1", Text: "prod"},
+		}},
+		commandDoc("cmd_1", "sess_1", "kubectl apply -f app.yaml"),
+		{ID: "sess_2", Kind: TargetSession, Session: "sess_2", Fields: []SearchField{{Name: FieldDescription, Text: "Git work"}}},
+		{ID: "cmd_2", Kind: TargetCommand, Session: "sess_2", Fields: []SearchField{
+			{Name: FieldCommand, Text: "git push"},
+			{Name: FieldNote, ID: "n1", Text: "pushed the prod hotfix"},
+		}},
+	})
+
+	tests := []struct {
+		query  string
+		fields []string
+		want   []string
+	}{
+		{"deploy kubectl", []string{FieldDescription, FieldCommand}, []string{"sess_1"}}, // words from the session and a command
+		{"git", []string{FieldDescription, FieldCommand}, []string{"sess_2"}},
+		{"prod", []string{FieldTag}, []string{"sess_1"}},
+		{"prod", []string{FieldNote}, []string{"sess_2"}},
+		{"prod", nil, []string{"sess_1", "sess_2"}},
+		{"prod git", []string{FieldDescription, FieldCommand}, []string{}},
+		{"", nil, []string{}},
+	}
+	for _, tt := range tests {
+		got := index.SessionMatches(tt.query, tt.fields...)
+		if len(got) != len(tt.want) {
+			t.Errorf("SessionMatches(%q, %v) = %v, want %v", tt.query, tt.fields, got, tt.want)
+			continue
+		}
+		for _, id := range tt.want {
+			if !got[id] {
+				t.Errorf("SessionMatches(%q, %v) = %v, want %v", tt.query, tt.fields, got, tt.want)
+			}
+		}
+	}
+}
+
+func BenchmarkSearchIndex(b *testing.B) {
+	index := NewSearchIndex()
+	docs := make([]SearchDocument, 200000)
+	for i := range docs {
+		docs[i] = commandDoc(fmt.Sprintf("cmd_%d", i), fmt.Sprintf("sess_%d", i/20),
+			fmt.Sprintf("git commit -m 'change %d' && docker build -t app:%d /src/project%d", i, i, i%50))
+	}
+	index.Update(docs)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Search("project17 change", TargetCommand)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchMode is how a search pattern matches commands
+type SearchMode string
+
+const (
+	SearchWords SearchMode = "words" // every word starts a word of the command, through the index
+	SearchRegex SearchMode = "regex" // an RE2 regular expression
+	SearchFuzzy SearchMode = "fuzzy" // the characters of each word in order, as fzf matches
+)
+
+// parseSearchMode reads the mode parameter, which is fallback when empty
+func parseSearchMode(value string, fallback SearchMode) (SearchMode, error) {
+	switch mode := SearchMode(strings.ToLower(value)); mode {
+	case "":
+		return fallback, nil
+	case SearchWords, SearchRegex, SearchFuzzy:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown search mode %q, use words, regex or fuzzy", value)
+}
+
+// Fuzzy scores, as fzf gives them: every matched character scores, gaps
+// cost, and characters starting a word or following the previous match
+// score extra
+const (
+	fuzzyScoreMatch        = 16
+	fuzzyScoreGapStart     = -3
+	fuzzyScoreGapExtension = -1
+	fuzzyBonusBoundary     = fuzzyScoreMatch / 2
+	fuzzyBonusNonWord      = fuzzyScoreMatch / 2
+	fuzzyBonusCamel        = fuzzyBonusBoundary + fuzzyScoreGapExtension
+	fuzzyBonusConsecutive  = -(fuzzyScoreGapStart + fuzzyScoreGapExtension)
+	fuzzyBonusFirstChar    = 2 // multiplies the bonus of a word's first character
+)
+
+// recencyHalfLife is the age at which a command's recency bonus halves.
+// A command run just now gets recencyBonusMax, worth two matched characters.
+const (
+	recencyHalfLife = 30 * 24 * time.Hour
+	recencyBonusMax = 2 * fuzzyScoreMatch
+)
+
+// scanEntries matches pattern against fields of every entry, for the regex
+// and fuzzy modes, which the index can't answer. Hits are best first: fuzzy
+// hits by match quality plus recency, regex hits by recency alone.
+func scanEntries(entries []HistoryEntry, mode SearchMode, pattern string, fields []string, now time.Time) ([]SearchHit, error) {
+	var match func(text string) (float64, [][2]int, bool)
+	switch mode {
+	case SearchRegex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		match = func(text string) (float64, [][2]int, bool) {
+			if !re.MatchString(text) {
+				return 0, nil, false
+			}
+			return 0, regexSpans(re, text), true
+		}
+	case SearchFuzzy:
+		terms := strings.Fields(pattern)
+		if len(terms) == 0 {
+			return nil, nil
+		}
+		match = func(text string) (float64, [][2]int, bool) {
+			score, positions, ok := fuzzyMatchTerms(terms, text)
+			return float64(score), positionSpans(positions), ok
+		}
+	default:
+		return nil, fmt.Errorf("search mode %s doesn't scan", mode)
+	}
+
+	var hits []SearchHit
+	for i := range entries {
+		entry := &entries[i]
+		hit := SearchHit{ID: entry.StableID, Ref: entry.ID, Score: math.Inf(-1)}
+		for _, field := range fields {
+			score, spans, ok := match(entryField(entry, field))
+			if !ok {
+				continue
+			}
+			// Fuzzy hits keep the best field; regex hits show every field
+			if mode == SearchFuzzy && score <= hit.Score {
+				continue
+			}
+			if mode == SearchFuzzy {
+				hit.Matches = nil
+			}
+			hit.Score = score
+			for _, span := range spans {
+				hit.Matches = append(hit.Matches, SearchMatch{Field: field, Start: span[0], End: span[1]})
+			}
+		}
+		if math.IsInf(hit.Score, -1) {
+			continue
+		}
+		hit.Score += recencyBonus(entry.Timestamp, now)
+		hits = append(hits, hit)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Ref > hits[j].Ref
+	})
+	return hits, nil
+}
+
+// entryField returns the text of a search field of an entry
+func entryField(entry *HistoryEntry, field string) string {
+	switch field {
+	case FieldCommand:
+		return entry.Command
+	case FieldExpandedCommand:
+		return entry.ExpandedCommand
+	case FieldDirectory:
+		return entry.Directory
+	}
+	return ""
+}
+
+// recencyBonus scores how recently a command ran
+func recencyBonus(timestamp, now time.Time) float64 {
+	if timestamp.IsZero() {
+		return 0
+	}
+	age := now.Sub(timestamp)
+	if age < 0 {
+		age = 0
+	}
+	return recencyBonusMax * math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+}
+
+// regexSpans returns the character spans of the non-empty matches of re
+func regexSpans(re *regexp.Regexp, text string) [][2]int {
+	var spans [][2]int
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		if loc[1] > loc[0] {
+			spans = append(spans, [2]int{runeOffset(text, loc[0]), runeOffset(text, loc[1])})
+		}
+	}
+	return spans
+}
+
+// positionSpans joins sorted character positions into spans of consecutive
+// characters
+func positionSpans(positions []int) [][2]int {
+	var spans [][2]int
+	for _, pos := range positions {
+		if n := len(spans); n > 0 && spans[n-1][1] == pos {
+			spans[n-1][1]++
+		} else {
+			spans = append(spans, [2]int{pos, pos + 1})
+		}
+	}
+	return spans
+}
+
+// fuzzyMatchTerms matches every term in text, as fzf does with a pattern of
+// several words. The score is the sum of the terms' scores, and positions
+// are the matched characters of all of them.
+func fuzzyMatchTerms(terms []string, text string) (int, []int, bool) {
+	runes := []rune(text)
+	total := 0
+	var positions []int
+	for _, term := range terms {
+		score, matched, ok := fuzzyMatch(term, runes)
+		if !ok {
+			return 0, nil, false
+		}
+		total += score
+		positions = append(positions, matched...)
+	}
+	sort.Ints(positions)
+	unique := positions[:0]
+	for i, pos := range positions {
+		if i == 0 || pos != positions[i-1] {
+			unique = append(unique, pos)
+		}
+	}
+	return total, unique, true
+}
+
+// fuzzyMatch finds the characters of pattern in text in order, as fzf's
+// first algorithm does: the first occurrence, narrowed from its end to the
+// shortest match. It is case-insensitive unless pattern has upper case.
+func fuzzyMatch(pattern string, text []rune) (int, []int, bool) {
+	caseSensitive := strings.IndexFunc(pattern, unicode.IsUpper) >= 0
+	if !caseSensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	chars := []rune(pattern)
+	if len(chars) == 0 || len(chars) > len(text) {
+		return 0, nil, false
+	}
+	fold := func(r rune) rune {
+		if caseSensitive {
+			return r
+		}
+		return unicode.ToLower(r)
+	}
+
+	// Forward to where the whole pattern has been seen
+	p, end := 0, -1
+	for i, r := range text {
+		if fold(r) == chars[p] {
+			p++
+			if p == len(chars) {
+				end = i + 1
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	// Back to the last start of that match
+	p, start := len(chars)-1, 0
+	for i := end - 1; i >= 0; i-- {
+		if fold(text[i]) == chars[p] {
+			p--
+			if p < 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	score, positions := fuzzyScore(chars, text, start, end, fold)
+	return score, positions, true
+}
+
+type charClass int
+
+const (
+	charNonWord charClass = iota
+	charLower
+	charUpper
+	charLetter
+	charNumber
+)
+
+func classOf(r rune) charClass {
+	switch {
+	case unicode.IsLower(r):
+		return charLower
+	case unicode.IsUpper(r):
+		return charUpper
+	case unicode.IsLetter(r):
+		return charLetter
+	case unicode.IsDigit(r):
+		return charNumber
+	}
+	return charNonWord
+}
+
+// fuzzyBonus is the bonus of a character of class following one of prev
+func fuzzyBonus(prev, class charClass) int {
+	switch {
+	case prev == charNonWord && class != charNonWord:
+		return fuzzyBonusBoundary
+	case prev == charLower && class == charUpper, prev != charNumber && class == charNumber:
+		return fuzzyBonusCamel
+	case class == charNonWord:
+		return fuzzyBonusNonWord
+	}
+	return 0
+}
+
+// fuzzyScore scores the match of chars in text[start:end]
+func fuzzyScore(chars []rune, text []rune, start, end int, fold func(rune) rune) (int, []int) {
+	score, p, consecutive, firstBonus := 0, 0, 0, 0
+	inGap := false
+	prevClass := charNonWord
+	if start > 0 {
+		prevClass = classOf(text[start-1])
+	}
+
+	var positions []int
+	for i := start; i < end; i++ {
+		class := classOf(text[i])
+		if p < len(chars) && fold(text[i]) == chars[p] {
+			positions = append(positions, i)
+			score += fuzzyScoreMatch
+			bonus := fuzzyBonus(prevClass, class)
+			if consecutive == 0 {
+				firstBonus = bonus
+			} else {
+				// A run keeps the bonus of its first character
+				if bonus >= fuzzyBonusBoundary && bonus > firstBonus {
+					firstBonus = bonus
+				}
+				bonus = max(bonus, firstBonus, fuzzyBonusConsecutive)
+			}
+			if p == 0 {
+				score += bonus * fuzzyBonusFirstChar
+			} else {
+				score += bonus
+			}
+			inGap = false
+			consecutive++
+			p++
+		} else {
+			if inGap {
+				score += fuzzyScoreGapExtension
+			} else {
+				score += fuzzyScoreGapStart
+			}
+			inGap = true
+			consecutive = 0
+			firstBonus = 0
+		}
+		prevClass = class
+	}
+	return score, positions
+}
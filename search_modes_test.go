@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    []int // matched positions, nil for no match
+	}{
+		{"dkr", "docker run", []int{0, 3, 5}},
+		{"vol", "docker run -v /a:/b --volume x", []int{22, 23, 24}}, // narrowed to the shortest match ending at the first l
+		{"DR", "docker run", nil},                                    // upper case matches case-sensitively
+		{"DR", "Docker Run", []int{0, 7}},
+		{"xyz", "docker", nil},
+		{"café", "echo «café»", []int{6, 7, 8, 9}},
+	}
+	for _, tt := range tests {
+		_, got, ok := fuzzyMatch(tt.pattern, []rune(tt.text))
+		if !ok {
+			got = nil
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("fuzzyMatch(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+
+	// Matches at word starts and in runs score better than scattered ones
+	better, _, _ := fuzzyMatch("dr", []rune("docker run"))
+	worse, _, _ := fuzzyMatch("dr", []rune("dddrrr"))
+	if better <= worse {
+		t.Errorf("Score of word starts %d, want more than %d", better, worse)
+	}
+	run, _, _ := fuzzyMatch("run", []rune("docker run"))
+	scattered, _, _ := fuzzyMatch("run", []rune("rebuild unit n"))
+	if run <= scattered {
+		t.Errorf("Score of a run %d, want more than %d", run, scattered)
+	}
+}
+
+func TestScanEntries(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	entries := []HistoryEntry{
+		{ID: 1, StableID: "cmd_1", Timestamp: now.AddDate(0, -3, 0), Command: "docker run -v /data:/data -v ~/.cfg:/etc/app:ro app", Directory: "/srv"},
+		{ID: 2, StableID: "cmd_2", Timestamp: now.AddDate(0, 0, -1), Command: "docker ps", Directory: "/srv"},
+		{ID: 3, StableID: "cmd_3", Timestamp: now.AddDate(0, 0, -2), Command: "docker run --rm -v $(pwd):/w img", Directory: "/home/me/dr"},
+		{ID: 4, StableID: "cmd_4", Timestamp: now, Command: "ls", Directory: "/tmp"},
+	}
+
+	hits, err := scanEntries(entries, SearchRegex, `-v \S+:ro\b`, []string{FieldCommand, FieldDirectory}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hitIDs(hits); fmt.Sprint(got) != "[cmd_1]" {
+		t.Errorf("Regex hits = %v", got)
+	} else if want := []SearchMatch{{Field: FieldCommand, Start: 26, End: 47}}; fmt.Sprint(hits[0].Matches) != fmt.Sprint(want) {
+		t.Errorf("Regex matches = %v, want %v", hits[0].Matches, want)
+	}
+
+	// Regex hits come newest first, with the spans of every field
+	hits, _ = scanEntries(entries, SearchRegex, `dr|run`, []string{FieldCommand, FieldDirectory}, now)
+	if got := hitIDs(hits); fmt.Sprint(got) != "[cmd_3 cmd_1]" {
+		t.Errorf("Regex hits = %v", got)
+	} else if len(hits[0].Matches) != 2 || hits[0].Matches[1].Field != FieldDirectory {
+		t.Errorf("Regex matches = %v, want the command and the directory", hits[0].Matches)
+	}
+
+	if _, err := scanEntries(entries, SearchRegex, `(`, []string{FieldCommand}, now); err == nil {
+		t.Errorf("Expected an error for an invalid regex")
+	}
+
+	// Every word of a fuzzy pattern must match
+	hits, _ = scanEntries(entries, SearchFuzzy, "drun vol", []string{FieldCommand}, now)
+	if got := hitIDs(hits); fmt.Sprint(got) != "[]" {
+		t.Errorf("Fuzzy hits for drun vol = %v", got)
+	}
+	hits, _ = scanEntries(entries, SearchFuzzy, "dock run ro", []string{FieldCommand}, now)
+	if got := hitIDs(hits); fmt.Sprint(got) != "[cmd_1]" {
+		t.Errorf("Fuzzy hits for dock run ro = %v", got)
+	}
+	// Fuzzy hits rank by match quality, then recency
+	hits, _ = scanEntries(entries, SearchFuzzy, "dock run", []string{FieldCommand}, now)
+	if got := hitIDs(hits); fmt.Sprint(got) != "[cmd_3 cmd_1]" {
+		t.Errorf("Fuzzy hits for dock run = %v", got)
+	}
+	hits, _ = scanEntries(entries, SearchFuzzy, "dock", []string{FieldCommand}, now)
+	if got := hitIDs(hits); fmt.Sprint(got) != "[cmd_2 cmd_3 cmd_1]" {
+		t.Errorf("Fuzzy hits for dock = %v", got)
+	} else if want := []SearchMatch{{Field: FieldCommand, Start: 0, End: 4}}; fmt.Sprint(hits[0].Matches) != fmt.Sprint(want) {
+		t.Errorf("Fuzzy matches = %v, want %v", hits[0].Matches, want)
+	}
+}
+
+func TestParseSearchMode(t *testing.T) {
+	if mode, err := parseSearchMode("", SearchWords); err != nil || mode != SearchWords {
+		t.Errorf("parseSearchMode(\"\") = %v, %v", mode, err)
+	}
+	if mode, err := parseSearchMode("Fuzzy", SearchWords); err != nil || mode != SearchFuzzy {
+		t.Errorf("parseSearchMode(Fuzzy) = %v, %v", mode, err)
+	}
+	if _, err := parseSearchMode("glob", SearchWords); err == nil {
+		t.Errorf("Expected an error for an unknown mode")
+	}
+}
+
+func TestHandleSearch_Punctuation(t *testing.T) {
+	s := &Server{entries: []HistoryEntry{
+		{ID: 1, StableID: "cmd_1", Command: "make && make install", Directory: "/src"},
+		{ID: 2, StableID: "cmd_2", Command: "cd ..", Directory: "/src"},
+		{ID: 3, StableID: "cmd_3", Command: "ls", Directory: "/src"},
+	}}
+	s.sessions = []Session{{ID: "sess_1", Commands: s.entries}}
+	s.reindex()
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"&&", "[cmd_1]"},
+		{"..", "[cmd_2]"},
+		{"make", "[cmd_1]"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.handleSearch(rec, httptest.NewRequest("GET", "/api/search?q="+url.QueryEscape(tt.query), nil))
+		var results []SearchResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+			t.Fatalf("Search for %q: %v", tt.query, err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.StableID)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("Search for %q = %v, want %s", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SegmentationStrategy decides where one session ends and the next begins
+type SegmentationStrategy interface {
+	// Name describes the strategy and its settings, e.g. "timeout(30m0s)"
+	Name() string
+	// Split reports whether next starts a new session after session, the
+	// commands of the current session in order
+	Split(session []HistoryEntry, next HistoryEntry) bool
+}
+
+// TimeoutStrategy splits after a gap between commands longer than Timeout
+type TimeoutStrategy struct {
+	Timeout time.Duration
+}
+
+func (s TimeoutStrategy) Name() string {
+	return fmt.Sprintf("timeout(%v)", s.Timeout)
+}
+
+func (s TimeoutStrategy) Split(session []HistoryEntry, next HistoryEntry) bool {
+	return gapBefore(session, next) > s.Timeout
+}
+
+// TimeOfDayTimeoutStrategy splits after a gap longer than the timeout of the
+// period of the day the gap starts in, or Default outside of all periods
+type TimeOfDayTimeoutStrategy struct {
+	Default time.Duration
+	Periods []TimeoutPeriod
+}
+
+func (s TimeOfDayTimeoutStrategy) Name() string {
+	parts := []string{s.Default.String()}
+	for _, period := range s.Periods {
+		parts = append(parts, fmt.Sprintf("%02d-%02d:%v", period.StartHour, period.EndHour, time.Duration(period.TimeoutMinutes)*time.Minute))
+	}
+	return "time-of-day-timeout(" + strings.Join(parts, ", ") + ")"
+}
+
+func (s TimeOfDayTimeoutStrategy) Split(session []HistoryEntry, next HistoryEntry) bool {
+	hour := session[len(session)-1].Timestamp.Hour()
+	timeout := s.Default
+	for _, period := range s.Periods {
+		if hour >= period.StartHour && hour < period.EndHour && period.TimeoutMinutes > 0 {
+			timeout = time.Duration(period.TimeoutMinutes) * time.Minute
+			break
+		}
+	}
+	return gapBefore(session, next) > timeout
+}
+
+// ShortBreakStrategy only lets Strategy split a session after a gap longer
+// than ShortBreak, so a coffee break or a stretch of rapid commands never
+// ends a session on its own
+type ShortBreakStrategy struct {
+	ShortBreak time.Duration
+	Strategy   SegmentationStrategy
+}
+
+func (s ShortBreakStrategy) Name() string {
+	return fmt.Sprintf("short-break(%v, %s)", s.ShortBreak, s.Strategy.Name())
+}
+
+func (s ShortBreakStrategy) Split(session []HistoryEntry, next HistoryEntry) bool {
+	return gapBefore(session, next) > s.ShortBreak && s.Strategy.Split(session, next)
+}
+
+// DirectoryTreeStrategy splits when a command runs in a directory unrelated
+// to the previous one (see isRelatedDirectory)
+type DirectoryTreeStrategy struct{}
+
+func (DirectoryTreeStrategy) Name() string {
+	return "directory-tree"
+}
+
+func (DirectoryTreeStrategy) Split(session []HistoryEntry, next HistoryEntry) bool {
+	return !isRelatedDirectory(session[len(session)-1].Directory, next.Directory)
+}
+
+// CategoryDriftStrategy splits when the last Threshold commands, including
+// next, all have a different category than the command before them. The
+// split happens at next, the command that reaches the threshold.
+type CategoryDriftStrategy struct {
+	Threshold int
+}
+
+func (s CategoryDriftStrategy) Name() string {
+	return fmt.Sprintf("category-drift(%d)", s.Threshold)
+}
+
+func (s CategoryDriftStrategy) Split(session []HistoryEntry, next HistoryEntry) bool {
+	if s.Threshold <= 0 || len(session) < s.Threshold {
+		return false
+	}
+	drift := session[len(session)-s.Threshold+1:]
+	previous := session[len(session)-s.Threshold].Category
+	if next.Category == previous {
+		return false
+	}
+	for _, entry := range drift {
+		if entry.Category == previous {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxDurationStrategy splits once a session would last longer than Max
+type MaxDurationStrategy struct {
+	Max time.Duration
+}
+
+func (s MaxDurationStrategy) Name() string {
+	return fmt.Sprintf("max-duration(%v)", s.Max)
+}
+
+func (s MaxDurationStrategy) Split(session []HistoryEntry, next HistoryEntry) bool {
+	return next.Timestamp.Sub(session[0].Timestamp) > s.Max
+}
+
+// AnyStrategy splits when any of its strategies does
+type AnyStrategy []SegmentationStrategy
+
+func (s AnyStrategy) Name() string {
+	names := make([]string, len(s))
+	for i, strategy := range s {
+		names[i] = strategy.Name()
+	}
+	return "any(" + strings.Join(names, ", ") + ")"
+}
+
+func (s AnyStrategy) Split(session []HistoryEntry, next HistoryEntry) bool {
+	for _, strategy := range s {
+		if strategy.Split(session, next) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSegmentationStrategy builds the strategy described by the session
+// heuristics. The timeout always applies, per time of day when timeout
+// periods are set, as does the maximum duration when set. Directory and
+// category changes only split sessions after a short break, when one shorter
+// than the timeout is configured.
+func NewSegmentationStrategy(h SessionHeuristics, timeout time.Duration) SegmentationStrategy {
+	var strategies AnyStrategy
+	if len(h.TimeoutPeriods) > 0 {
+		strategies = append(strategies, TimeOfDayTimeoutStrategy{Default: timeout, Periods: h.TimeoutPeriods})
+	} else {
+		strategies = append(strategies, TimeoutStrategy{Timeout: timeout})
+	}
+
+	var drift AnyStrategy
+	if h.DirectoryChangeBreaksSession {
+		drift = append(drift, DirectoryTreeStrategy{})
+	}
+	if h.CategoryChangeThreshold > 0 {
+		drift = append(drift, CategoryDriftStrategy{Threshold: h.CategoryChangeThreshold})
+	}
+	if len(drift) > 0 {
+		var strategy SegmentationStrategy = drift
+		if len(drift) == 1 {
+			strategy = drift[0]
+		}
+		shortBreak := time.Duration(h.ShortBreakMinutes) * time.Minute
+		if shortBreak > 0 && shortBreak < timeout {
+			strategy = ShortBreakStrategy{ShortBreak: shortBreak, Strategy: strategy}
+		}
+		strategies = append(strategies, strategy)
+	}
+
+	if h.MaxSessionDuration > 0 {
+		strategies = append(strategies, MaxDurationStrategy{Max: time.Duration(h.MaxSessionDuration) * time.Minute})
+	}
+
+	if len(strategies) == 1 {
+		return strategies[0]
+	}
+	return strategies
+}
+
+func gapBefore(session []HistoryEntry, next HistoryEntry) time.Duration {
+	return next.Timestamp.Sub(session[len(session)-1].Timestamp)
+}
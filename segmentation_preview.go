@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SessionSpan is the outline of one session in a segmentation preview
+type SessionSpan struct {
+	ID           string    `json:"id"`
+	Host         string    `json:"host,omitempty"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	Commands     int       `json:"commands"`
+	FirstCommand string    `json:"first_command"`
+	Description  string    `json:"description"`
+}
+
+// Segmentation is the sessions a strategy produces for a date range
+type Segmentation struct {
+	Strategy string        `json:"strategy"`
+	Sessions []SessionSpan `json:"sessions"`
+}
+
+// SegmentationPreview compares the current sessions of a date range with
+// those a candidate configuration would produce. Nothing is saved.
+type SegmentationPreview struct {
+	Start     time.Time    `json:"start"`
+	End       time.Time    `json:"end"`
+	Current   Segmentation `json:"current"`
+	Candidate Segmentation `json:"candidate"`
+}
+
+// PreviewSegmentation re-segments the entries between start and end, by
+// timestamp, under the candidate heuristics and timeout. A zero end leaves
+// the range open. Boundaries set by hand in sessionIndex still apply, but
+// nothing is recorded in it. current are the sessions as they are now, of
+// which those starting in the range are shown.
+func (p *Parser) PreviewSegmentation(entries []HistoryEntry, current []Session, start, end time.Time, candidate SessionHeuristics, timeout time.Duration, sessionIndex *SessionIndex) SegmentationPreview {
+	inRange := func(t time.Time) bool {
+		return !t.Before(start) && (end.IsZero() || t.Before(end))
+	}
+
+	var rangeEntries []HistoryEntry
+	for _, entry := range entries {
+		if inRange(entry.Timestamp) {
+			rangeEntries = append(rangeEntries, entry)
+		}
+	}
+
+	preview := SegmentationPreview{
+		Start: start,
+		End:   end,
+		Current: Segmentation{
+			Strategy: NewSegmentationStrategy(p.config.SessionHeuristics, p.config.SessionTimeout).Name(),
+			Sessions: []SessionSpan{},
+		},
+	}
+	for _, session := range current {
+		if inRange(session.StartTime) {
+			preview.Current.Sessions = append(preview.Current.Sessions, spanOf(session))
+		}
+	}
+
+	// rangeEntries is a copy, so the session IDs written to it go nowhere
+	strategy := NewSegmentationStrategy(candidate, timeout)
+	preview.Candidate = Segmentation{Strategy: strategy.Name(), Sessions: []SessionSpan{}}
+	for _, session := range p.SegmentSessions(rangeEntries, strategy, candidate.MinCommandsPerSession, candidate.SplitConcurrentTerminals, sessionIndex.Overrides(), nil) {
+		preview.Candidate.Sessions = append(preview.Candidate.Sessions, spanOf(session))
+	}
+
+	return preview
+}
+
+func spanOf(session Session) SessionSpan {
+	span := SessionSpan{
+		ID:          session.ID,
+		Host:        session.Host,
+		StartTime:   session.StartTime,
+		EndTime:     session.EndTime,
+		Commands:    len(session.Commands),
+		Description: session.Description,
+	}
+	if len(session.Commands) > 0 {
+		span.FirstCommand = session.Commands[0].Command
+	}
+	return span
+}
+
+// candidateHeuristics returns the current heuristics with the fields set in
+// overrides, a session_heuristics JSON object, replaced, along with the
+// timeout they imply
+func candidateHeuristics(config *Config, overrides []byte) (SessionHeuristics, time.Duration, error) {
+	heuristics := config.SessionHeuristics
+	heuristics.TimeoutMinutes = int(config.SessionTimeout / time.Minute)
+	if len(overrides) > 0 {
+		if err := json.Unmarshal(overrides, &heuristics); err != nil {
+			return heuristics, 0, err
+		}
+	}
+	if heuristics.TimeoutMinutes <= 0 {
+		return heuristics, 0, fmt.Errorf("timeout_minutes must be positive")
+	}
+	return heuristics, time.Duration(heuristics.TimeoutMinutes) * time.Minute, nil
+}
+
+// previewRange parses the inclusive YYYY-MM-DD dates of a preview. Either
+// may be empty to leave that end of the range open, which is returned as the
+// zero time.
+func previewRange(startDate, endDate string) (time.Time, time.Time, error) {
+	var start, end time.Time
+	if startDate != "" {
+		t, err := time.Parse("2006-01-02", startDate)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid start date %q", startDate)
+		}
+		start = t
+	}
+	if endDate != "" {
+		t, err := time.Parse("2006-01-02", endDate)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid end date %q", endDate)
+		}
+		// Include the entire end date
+		end = t.Add(24 * time.Hour)
+	}
+	return start, end, nil
+}
+
+// runSegmentCommand implements "history_viewer segment", which prints the
+// sessions of a date range under the configured and a candidate set of
+// heuristics side by side. The flags default to the configured values.
+func runSegmentCommand(args []string, config *Config, out io.Writer) error {
+	h := config.SessionHeuristics
+	flags := flag.NewFlagSet("segment", flag.ContinueOnError)
+	flags.SetOutput(out)
+	from := flags.String("from", "", "First date to re-segment (YYYY-MM-DD)")
+	to := flags.String("to", "", "Last date to re-segment (YYYY-MM-DD)")
+	timeout := flags.Int("timeout", int(config.SessionTimeout/time.Minute), "Gap in minutes that ends a session")
+	shortBreak := flags.Int("short-break", h.ShortBreakMinutes, "Gap in minutes before directory or category changes may end a session")
+	directory := flags.Bool("directory", h.DirectoryChangeBreaksSession, "End sessions when moving to an unrelated directory tree")
+	category := flags.Int("category", h.CategoryChangeThreshold, "End sessions after this many commands of a different category (0 disables)")
+	maxDuration := flags.Int("max-duration", h.MaxSessionDuration, "Longest session in minutes (0 disables)")
+	minCommands := flags.Int("min-commands", h.MinCommandsPerSession, "Fewest commands in a session")
+	terminals := flags.Bool("terminals", h.SplitConcurrentTerminals, "Segment the commands of each terminal on their own")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *timeout <= 0 {
+		return fmt.Errorf("-timeout must be positive")
+	}
+
+	start, end, err := previewRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	// Heuristics without a flag, such as the timeout periods, stay as configured
+	candidate := config.SessionHeuristics
+	candidate.TimeoutMinutes = *timeout
+	candidate.DirectoryChangeBreaksSession = *directory
+	candidate.CategoryChangeThreshold = *category
+	candidate.MinCommandsPerSession = *minCommands
+	candidate.MaxSessionDuration = *maxDuration
+	candidate.ShortBreakMinutes = *shortBreak
+	candidate.SplitConcurrentTerminals = *terminals
+
+	// The index is read for its manual boundaries and never saved
+	sessionIndex, err := NewSessionIndex(filepath.Join(config.HomeDir, ".config", "history_viewer"))
+	if err != nil {
+		return err
+	}
+
+	parser := NewParser(config)
+	entries, err := parser.ParseHistory()
+	if err != nil {
+		return fmt.Errorf("failed to parse history: %w", err)
+	}
+	current := parser.GroupIntoSessions(entries, sessionIndex)
+	preview := parser.PreviewSegmentation(entries, current, start, end, candidate, time.Duration(*timeout)*time.Minute, sessionIndex)
+
+	writeSegmentationPreview(out, preview)
+	return nil
+}
+
+// writeSegmentationPreview prints the two segmentations as columns, with
+// sessions that start at the same time on the same row
+func writeSegmentationPreview(out io.Writer, preview SegmentationPreview) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "CURRENT: %s\tCANDIDATE: %s\n", preview.Current.Strategy, preview.Candidate.Strategy)
+
+	current, candidate := preview.Current.Sessions, preview.Candidate.Sessions
+	for len(current) > 0 || len(candidate) > 0 {
+		var left, right string
+		switch {
+		case len(candidate) == 0 || (len(current) > 0 && current[0].StartTime.Before(candidate[0].StartTime)):
+			left, current = formatSpan(current[0]), current[1:]
+		case len(current) == 0 || candidate[0].StartTime.Before(current[0].StartTime):
+			right, candidate = formatSpan(candidate[0]), candidate[1:]
+		default:
+			left, current = formatSpan(current[0]), current[1:]
+			right, candidate = formatSpan(candidate[0]), candidate[1:]
+		}
+		fmt.Fprintf(w, "%s\t%s\n", left, right)
+	}
+
+	fmt.Fprintf(w, "%d sessions\t%d sessions\n", len(preview.Current.Sessions), len(preview.Candidate.Sessions))
+	w.Flush()
+}
+
+func formatSpan(span SessionSpan) string {
+	host := ""
+	if span.Host != "" {
+		host = " [" + span.Host + "]"
+	}
+	description := []rune(span.Description)
+	if len(description) > 40 {
+		description = append([]rune(strings.TrimSpace(string(description[:40]))), '…')
+	}
+	return fmt.Sprintf("%s-%s%s %4d cmds  %s",
+		span.StartTime.Format("2006-01-02 15:04"), span.EndTime.Format("15:04"), host, span.Commands, string(description))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func segmentationEntries(specs ...string) []HistoryEntry {
+	// Each spec is "minutes directory category"
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	var entries []HistoryEntry
+	for i, spec := range specs {
+		fields := strings.Fields(spec)
+		minutes, _ := strconv.Atoi(fields[0])
+		entries = append(entries, HistoryEntry{
+			ID:        i + 1,
+			Timestamp: base.Add(time.Duration(minutes) * time.Minute),
+			Command:   fields[2] + " " + fields[0],
+			Directory: fields[1],
+			Category:  CommandCategory(fields[2]),
+		})
+	}
+	return entries
+}
+
+func sessionSizes(sessions []Session) []int {
+	sizes := make([]int, len(sessions))
+	for i, session := range sessions {
+		sizes[i] = len(session.Commands)
+	}
+	return sizes
+}
+
+func TestSegmentationStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy SegmentationStrategy
+		entries  []HistoryEntry
+		want     []int
+	}{
+		{
+			name:     "timeout",
+			strategy: TimeoutStrategy{Timeout: 30 * time.Minute},
+			entries:  segmentationEntries("0 /a vcs", "10 /a vcs", "50 /a vcs", "60 /a vcs"),
+			want:     []int{2, 2},
+		},
+		{
+			name:     "directory tree",
+			strategy: DirectoryTreeStrategy{},
+			entries:  segmentationEntries("0 /src/app vcs", "1 /src/app/web vcs", "2 /src/app vcs", "3 /etc/nginx vcs"),
+			want:     []int{3, 1},
+		},
+		{
+			name:     "category drift",
+			strategy: CategoryDriftStrategy{Threshold: 3},
+			entries:  segmentationEntries("0 /a vcs", "1 /a vcs", "2 /a containers", "3 /a containers", "4 /a containers", "5 /a containers"),
+			want:     []int{4, 2},
+		},
+		{
+			name:     "category drift broken by a return",
+			strategy: CategoryDriftStrategy{Threshold: 3},
+			entries:  segmentationEntries("0 /a vcs", "1 /a build", "2 /a vcs", "3 /a build", "4 /a vcs"),
+			want:     []int{5},
+		},
+		{
+			name:     "max duration",
+			strategy: MaxDurationStrategy{Max: 20 * time.Minute},
+			entries:  segmentationEntries("0 /a vcs", "10 /a vcs", "20 /a vcs", "21 /a vcs", "30 /a vcs"),
+			want:     []int{3, 2},
+		},
+		{
+			name:     "short break holds a directory change",
+			strategy: ShortBreakStrategy{ShortBreak: 5 * time.Minute, Strategy: DirectoryTreeStrategy{}},
+			entries:  segmentationEntries("0 /src/app vcs", "2 /etc/nginx vcs", "3 /src/app vcs", "15 /etc/nginx vcs"),
+			want:     []int{3, 1},
+		},
+		{
+			name:     "any",
+			strategy: AnyStrategy{TimeoutStrategy{Timeout: 30 * time.Minute}, DirectoryTreeStrategy{}},
+			entries:  segmentationEntries("0 /src/app vcs", "1 /etc/nginx vcs", "50 /etc/nginx vcs"),
+			want:     []int{1, 1, 1},
+		},
+	}
+
+	p := &Parser{config: &Config{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sessionSizes(p.SegmentSessions(tt.entries, tt.strategy, 1, false, nil, nil))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Session sizes = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Session sizes = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSegmentationStrategy(t *testing.T) {
+	tests := []struct {
+		name       string
+		heuristics SessionHeuristics
+		timeout    time.Duration
+		want       string
+	}{
+		{"timeout only", SessionHeuristics{ShortBreakMinutes: 5}, 30 * time.Minute, "timeout(30m0s)"},
+		{
+			"short break wraps drift",
+			SessionHeuristics{DirectoryChangeBreaksSession: true, CategoryChangeThreshold: 3, ShortBreakMinutes: 5, MaxSessionDuration: 120},
+			30 * time.Minute,
+			"any(timeout(30m0s), short-break(5m0s, any(directory-tree, category-drift(3))), max-duration(2h0m0s))",
+		},
+		{
+			"short break not shorter than timeout",
+			SessionHeuristics{DirectoryChangeBreaksSession: true, ShortBreakMinutes: 30},
+			30 * time.Minute,
+			"any(timeout(30m0s), directory-tree)",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := NewSegmentationStrategy(tt.heuristics, tt.timeout).Name(); got != tt.want {
+			t.Errorf("%s: strategy = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewSegmentation(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &Config{HomeDir: tmpDir, SessionTimeout: 30 * time.Minute}
+	config.SessionHeuristics.MinCommandsPerSession = 1
+	p := &Parser{config: config}
+
+	entries := segmentationEntries("0 /a vcs", "20 /a vcs", "40 /a vcs", "1500 /a vcs", "1510 /a vcs")
+	sessionIndex, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+	current := p.GroupIntoSessions(entries, sessionIndex)
+	indexed := len(sessionIndex.IDsBySequenceNumber())
+
+	start, end, err := previewRange("2024-03-01", "2024-03-01")
+	if err != nil {
+		t.Fatalf("previewRange failed: %v", err)
+	}
+	candidate := SessionHeuristics{TimeoutMinutes: 15, MinCommandsPerSession: 1}
+	preview := p.PreviewSegmentation(entries, current, start, end, candidate, 15*time.Minute, sessionIndex)
+
+	if got := len(preview.Current.Sessions); got != 1 {
+		t.Errorf("Current sessions on the day = %d, want 1", got)
+	}
+	if got := len(preview.Candidate.Sessions); got != 3 {
+		t.Errorf("Candidate sessions on the day = %d, want 3", got)
+	}
+	if preview.Candidate.Sessions[0].ID != preview.Current.Sessions[0].ID {
+		t.Errorf("Sessions starting with the same command got IDs %q and %q", preview.Candidate.Sessions[0].ID, preview.Current.Sessions[0].ID)
+	}
+	if got := len(sessionIndex.IDsBySequenceNumber()); got != indexed {
+		t.Errorf("Preview added %d sessions to the index", got-indexed)
+	}
+	if entries[1].SessionID != current[0].ID {
+		t.Errorf("Preview changed the session of an entry to %q", entries[1].SessionID)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "sessions.json")); !os.IsNotExist(err) {
+		t.Errorf("Preview saved the session index")
+	}
+
+	var out bytes.Buffer
+	writeSegmentationPreview(&out, preview)
+	if !strings.Contains(out.String(), "1 sessions") || !strings.Contains(out.String(), "3 sessions") {
+		t.Errorf("Preview output missing session counts:\n%s", out.String())
+	}
+}
+
+func TestRunSegmentCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".zsh_history")
+	if err := os.WriteFile(historyPath, []byte(": 1700000000:0;ls\n: 1700003600:0;pwd\n"), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+	config := &Config{HistoryFile: historyPath, HomeDir: tmpDir, SessionTimeout: 30 * time.Minute}
+	config.SessionHeuristics.MinCommandsPerSession = 1
+	config.SessionHeuristics.TimeoutPeriods = []TimeoutPeriod{{StartHour: 9, EndHour: 18, TimeoutMinutes: 90}}
+
+	// Without flags the candidate is the configuration itself
+	var out bytes.Buffer
+	if err := runSegmentCommand(nil, config, &out); err != nil {
+		t.Fatalf("runSegmentCommand failed: %v", err)
+	}
+	header, _, _ := strings.Cut(out.String(), "\n")
+	current, candidate, _ := strings.Cut(strings.TrimPrefix(header, "CURRENT: "), "CANDIDATE: ")
+	if strings.TrimSpace(current) != candidate {
+		t.Errorf("Strategies differ without flags: %q", header)
+	}
+
+	out.Reset()
+	if err := runSegmentCommand([]string{"-timeout", "45"}, config, &out); err != nil {
+		t.Fatalf("runSegmentCommand failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "CANDIDATE: time-of-day-timeout(45m0s, 09-18:1h30m0s)") {
+		t.Errorf("Candidate lost the timeout periods:\n%s", out.String())
+	}
+}
+
+func TestRunSegmentCommand_Terminals(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".zsh_history")
+	history := ": 1700000000:0;cd /x/a/p\n: 1700000010:0;cd /y/b/q\n: 1700000020:0;make\n"
+	if err := os.WriteFile(historyPath, []byte(history), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+	config := &Config{HistoryFile: historyPath, HomeDir: tmpDir, SessionTimeout: 30 * time.Minute}
+	config.SessionHeuristics.MinCommandsPerSession = 1
+	config.SessionHeuristics.SplitConcurrentTerminals = true
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "2 sessions 2 sessions"},
+		{[]string{"-terminals=false"}, "2 sessions 1 sessions"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := runSegmentCommand(tt.args, config, &out); err != nil {
+			t.Fatalf("runSegmentCommand(%q) failed: %v", tt.args, err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if got := strings.Join(strings.Fields(lines[len(lines)-1]), " "); got != tt.want {
+			t.Errorf("runSegmentCommand(%q) counts = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
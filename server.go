@@ -6,11 +6,14 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 type Server struct {
@@ -21,8 +24,17 @@ type Server struct {
 	exporter     *Exporter
 	metadata     *MetadataStore
 	sessionIndex *SessionIndex
+	classifications *ClassificationCache // LLM suggestions for uncategorized commands
+	classifyWake chan struct{}
+	classifierRunning bool // runClassifier is running, see startClassifier
+	classifyMu   sync.Mutex // held while classifying
+	events       *EventHub
+	watcher      *fsnotify.Watcher // history files of the current config, see WatchHistory
+	watchMu      sync.Mutex
+	index        *SearchIndex // commands and sessions with their notes and tags
 	sessions     []Session
 	entries      []HistoryEntry
+	cursors      HistoryCursors // where the last parse of each history source stopped
 	lastModTime  time.Time
 	mu           sync.RWMutex
 }
@@ -38,6 +50,12 @@ func NewServer(config *Config) *Server {
 	if err != nil {
 		log.Printf("Warning: Failed to load session index: %v", err)
 	}
+
+	classifications, err := NewClassificationCache(configDir)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	SetLearnedCategories(classifications.Accepted())
 	
 	return &Server{
 		config:       config,
@@ -46,20 +64,65 @@ func NewServer(config *Config) *Server {
 		exporter:     NewExporter(),
 		metadata:     metadata,
 		sessionIndex: sessionIndex,
+		classifications: classifications,
+		classifyWake: make(chan struct{}, 1),
+		events:       NewEventHub(),
+		index:        NewSearchIndex(),
 	}
 }
 
 func (s *Server) refreshData() error {
+	events, err := s.reloadHistory()
+	if err != nil {
+		return err
+	}
+
+	// Publish outside the lock so slow clients can't hold up readers
+	s.events.Publish(events...)
+	return nil
+}
+
+// reloadHistory brings entries and sessions up to date with the history file
+// and returns the changes to push to connected clients
+func (s *Server) reloadHistory() ([]ServerEvent, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	entries, err := s.parser.ParseHistory()
+	entries, result, err := s.parser.ParseSources(&s.cursors, s.entries)
 	if err != nil {
-		return err
+		s.cursors.Reset()
+		return nil, err
+	}
+
+	// Metadata saved by older versions points at line ordinals and sequence
+	// numbers, which grouping may renumber
+	if s.metadata != nil && result != ParseUnchanged {
+		if err := s.metadata.Migrate(entries, s.sessionIndex); err != nil {
+			log.Printf("Warning: Failed to migrate metadata: %v", err)
+		}
 	}
 
-	s.entries = entries
-	s.sessions = s.parser.GroupIntoSessions(entries, s.sessionIndex)
+	var events []ServerEvent
+	switch result {
+	case ParseUnchanged:
+		return nil, nil
+	case ParseAppended:
+		previous := s.sessions
+		s.sessions = s.regroupTail(entries)
+		events = diffSessions(previous, s.sessions)
+		s.entries = entries
+		if s.config.SessionHeuristics.SplitConcurrentTerminals {
+			// Any terminal's session may have grown, not only the last one
+			s.reindex()
+		} else {
+			s.reindexTail(previous)
+		}
+	default:
+		s.sessions = s.parser.GroupIntoSessions(entries, s.sessionIndex)
+		events = []ServerEvent{{Type: EventHistoryReloaded}}
+		s.entries = entries
+		s.reindex()
+	}
 	s.lastModTime = time.Now()
 	
 	// Save session index after grouping
@@ -69,7 +132,97 @@ func (s *Server) refreshData() error {
 		}
 	}
 
-	return nil
+	return events, nil
+}
+
+// regroupTail regroups only the trailing session, which is the only one
+// appended commands can extend. Earlier sessions are kept as they are.
+// With concurrent terminals split, sessions overlap and the terminal a
+// command joins depends on every command before it, so everything is
+// regrouped. Caller must hold the write lock.
+func (s *Server) regroupTail(entries []HistoryEntry) []Session {
+	if len(s.sessions) == 0 || s.config.SessionHeuristics.SplitConcurrentTerminals {
+		return s.parser.GroupIntoSessions(entries, s.sessionIndex)
+	}
+
+	last := s.sessions[len(s.sessions)-1]
+	start := -1
+	if len(last.Commands) > 0 {
+		// Entry IDs are their 1-based position in entries
+		start = last.Commands[0].ID - 1
+	}
+	if start < 0 || start >= len(entries) || entries[start].ID != last.Commands[0].ID {
+		return s.parser.GroupIntoSessions(entries, s.sessionIndex)
+	}
+
+	sessions := append([]Session{}, s.sessions[:len(s.sessions)-1]...)
+	return append(sessions, s.parser.GroupIntoSessions(entries[start:], s.sessionIndex)...)
+}
+
+// reindex brings the search index up to date with every session and
+// command. Documents that didn't change are skipped. Caller must hold the
+// write lock.
+func (s *Server) reindex() {
+	if s.index == nil {
+		s.index = NewSearchIndex()
+	}
+	s.index.Update(s.searchDocuments(s.sessions))
+
+	live := make(map[string]bool, len(s.entries)+len(s.sessions))
+	for i := range s.sessions {
+		live[s.sessions[i].ID] = true
+		for _, cmd := range s.sessions[i].Commands {
+			live[cmd.StableID] = true
+		}
+	}
+	s.index.Retain(func(id string) bool { return live[id] })
+}
+
+// reindexTail indexes the sessions regroupTail replaced or added, given the
+// sessions before it ran. Caller must hold the write lock.
+func (s *Server) reindexTail(previous []Session) {
+	if s.index == nil || len(previous) == 0 || len(s.sessions) < len(previous) {
+		s.reindex()
+		return
+	}
+	tail := s.sessions[len(previous)-1:]
+	s.index.Update(s.searchDocuments(tail))
+
+	// The old trailing session may have been split or renamed
+	stale := previous[len(previous)-1].ID
+	for _, session := range tail {
+		if session.ID == stale {
+			return
+		}
+	}
+	s.index.Retain(func(id string) bool { return id != stale })
+}
+
+// searchDocuments returns the search documents of sessions and their commands
+func (s *Server) searchDocuments(sessions []Session) []SearchDocument {
+	var notes map[string][]Note
+	var tags map[string][]Tag
+	if s.metadata != nil {
+		notes, tags = s.metadata.Annotations()
+	}
+
+	var docs []SearchDocument
+	for i := range sessions {
+		session := &sessions[i]
+		docs = append(docs, sessionDocument(session, notes[session.ID], tags[session.ID]))
+		for j := range session.Commands {
+			cmd := &session.Commands[j]
+			docs = append(docs, commandDocument(cmd, notes[cmd.StableID], tags[cmd.StableID]))
+		}
+	}
+	return docs
+}
+
+// annotate indexes the notes and tags of a target after they changed
+func (s *Server) annotate(targetType TargetType, targetID string) {
+	if s.metadata != nil {
+		s.index.Annotate(targetID, s.metadata.GetNotesForTarget(targetType, targetID), s.metadata.GetTagsForTarget(targetType, targetID))
+	}
 }
 
 func (s *Server) Start() error {
@@ -78,21 +231,42 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to load history: %w", err)
 	}
 
+	// Push history changes to the browser as they happen
+	if err := s.WatchHistory(); err != nil {
+		log.Printf("Warning: Failed to watch history file: %v", err)
+	}
+
+	if s.config.ClassifyOtherCommands {
+		s.mu.Lock()
+		s.startClassifier()
+		s.mu.Unlock()
+	}
+
 	// Setup routes
 	http.HandleFunc("/", s.handleIndex)
 	http.HandleFunc("/api/sessions", s.handleSessions)
 	http.HandleFunc("/api/sessions/", s.handleSessionDetail)
+	http.HandleFunc("/api/sessions/preview", s.handleSessionPreview)
+	http.HandleFunc("/api/sessions/split", s.handleSessionSplit)
+	http.HandleFunc("/api/sessions/merge", s.handleSessionMerge)
+	http.HandleFunc("/api/sessions/timeout-fit", s.handleTimeoutFit)
 	http.HandleFunc("/api/commands", s.handleCommands)
 	http.HandleFunc("/api/commands/search", s.handleCommandSearch)
 	http.HandleFunc("/api/directories", s.handleDirectories)
+	http.HandleFunc("/api/projects", s.handleProjects)
+	http.HandleFunc("/api/categorize", s.handleCategorize)
 	http.HandleFunc("/api/commands/by-directory", s.handleCommandsByDirectory)
 	http.HandleFunc("/api/search", s.handleSearch)
 	http.HandleFunc("/api/patterns", s.handlePatterns)
 	http.HandleFunc("/api/stats", s.handleStats)
 	http.HandleFunc("/api/volume", s.handleVolume)
 	http.HandleFunc("/api/refresh", s.handleRefresh)
+	http.HandleFunc("/api/events", s.handleEvents)
 	http.HandleFunc("/api/export", s.handleExport)
 	http.HandleFunc("/api/llm/analyze", s.handleLLMAnalyze)
+	http.HandleFunc("/api/llm/categories", s.handleLLMCategories)
+	http.HandleFunc("/api/llm/categories/review", s.handleLLMCategoryReview)
+	http.HandleFunc("/api/llm/categories/run", s.handleLLMCategoryRun)
 	http.HandleFunc("/api/config", s.handleConfig)
 	// Metadata routes
 	http.HandleFunc("/api/metadata/notes", s.handleNotes)
@@ -132,196 +306,26 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Parse query parameters
-	startDate := r.URL.Query().Get("start_date")
-	endDate := r.URL.Query().Get("end_date")
-	category := r.URL.Query().Get("category")
-	keyword := r.URL.Query().Get("keyword")
+	// The query in q or keyword, and the older filter parameters
+	query, err := sessionQueryFromParams(r.URL.Query().Get)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		return
+	}
 	sortOrder := r.URL.Query().Get("sort") // "asc" or "desc"
-	tagKeyword := r.URL.Query().Get("tag_keyword")
-	tagColor := r.URL.Query().Get("tag_color")
-	tagStarsStr := r.URL.Query().Get("tag_stars")
-	noteSearch := r.URL.Query().Get("note_search")
 
 	// Filter sessions
-	filteredSessions := make([]Session, 0)
-	for _, session := range s.sessions {
-		// Date filtering
-		if startDate != "" {
-			if start, err := time.Parse("2006-01-02", startDate); err == nil {
-				if session.StartTime.Before(start) {
-					continue
-				}
-			}
-		}
-		if endDate != "" {
-			if end, err := time.Parse("2006-01-02", endDate); err == nil {
-				// Add one day to include the entire end date
-				end = end.Add(24 * time.Hour)
-				if session.EndTime.After(end) {
-					continue
-				}
-			}
-		}
-		
-		// Category filtering
-		if category != "" && category != "all" {
-			found := false
-			for cat := range session.Categories {
-				if string(cat) == category {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
-		}
-		
-		// Keyword filtering - split by whitespace and match all tokens
-		if keyword != "" {
-			// Split keyword into tokens
-			tokens := strings.Fields(strings.ToLower(keyword))
-			if len(tokens) == 0 {
-				continue
-			}
-			
-			// Check if all tokens are found in session
-			allTokensFound := true
-			for _, token := range tokens {
-				tokenFound := false
-				
-				// Search in description
-				if strings.Contains(strings.ToLower(session.Description), token) {
-					tokenFound = true
-				}
-				
-				// Search in commands if not found in description
-				if !tokenFound {
-					for _, cmd := range session.Commands {
-						if strings.Contains(strings.ToLower(cmd.Command), token) {
-							tokenFound = true
-							break
-						}
-					}
-				}
-				
-				if !tokenFound {
-					allTokensFound = false
-					break
-				}
-			}
-			
-	if !allTokensFound {
-				continue
-			}
-		}
-		
-		filteredSessions = append(filteredSessions, session)
+	matched := s.querySessions(query)
+	filteredSessions := make([]Session, len(matched))
+	for i, session := range matched {
+		filteredSessions[i] = *session
 	}
 
-	// Merge metadata into sessions first, so we can filter by notes and tags
+	// Merge notes, tags and ratings into the sessions
 	if s.metadata != nil {
 		filteredSessions = s.metadata.MergeIntoSessions(filteredSessions)
 	}
 
-	// Filter by tag keywords
-	if tagKeyword != "" {
-		filteredByTags := make([]Session, 0)
-		for _, session := range filteredSessions {
-			hasMatchingTag := false
-
-			// Check session tags
-			for _, tag := range session.Tags {
-				if strings.Contains(strings.ToLower(tag.Keyword), strings.ToLower(tagKeyword)) {
-					hasMatchingTag = true
-					break
-				}
-			}
-
-			// Check command tags if no session tag matched
-			if !hasMatchingTag {
-				for _, cmd := range session.Commands {
-					for _, tag := range cmd.Tags {
-						if strings.Contains(strings.ToLower(tag.Keyword), strings.ToLower(tagKeyword)) {
-							hasMatchingTag = true
-							break
-						}
-					}
-					if hasMatchingTag {
-						break
-					}
-				}
-			}
-
-			if hasMatchingTag {
-				filteredByTags = append(filteredByTags, session)
-			}
-		}
-		filteredSessions = filteredByTags
-	}
-
-	// Filter by color (from session metadata)
-	if tagColor != "" {
-		filteredByColor := make([]Session, 0)
-		for _, session := range filteredSessions {
-			if session.Metadata != nil && strings.EqualFold(session.Metadata.ColorCode, tagColor) {
-				filteredByColor = append(filteredByColor, session)
-			}
-		}
-		filteredSessions = filteredByColor
-	}
-
-	// Filter by star rating (from session metadata)
-	if tagStarsStr != "" {
-		tagStars, _ := strconv.Atoi(tagStarsStr)
-		if tagStars > 0 {
-			filteredByStars := make([]Session, 0)
-			for _, session := range filteredSessions {
-				if session.Metadata != nil && session.Metadata.StarRating >= tagStars {
-					filteredByStars = append(filteredByStars, session)
-				}
-			}
-			filteredSessions = filteredByStars
-		}
-	}
-
-	// Filter by notes
-	if noteSearch != "" {
-		filteredByNotes := make([]Session, 0)
-		for _, session := range filteredSessions {
-			hasMatchingNote := false
-
-			// Check session notes
-			for _, note := range session.Notes {
-				if strings.Contains(strings.ToLower(note.Text), strings.ToLower(noteSearch)) {
-					hasMatchingNote = true
-					break
-				}
-			}
-
-			// Check command notes if no session note matched
-			if !hasMatchingNote {
-				for _, cmd := range session.Commands {
-					for _, note := range cmd.Notes {
-						if strings.Contains(strings.ToLower(note.Text), strings.ToLower(noteSearch)) {
-							hasMatchingNote = true
-							break
-						}
-					}
-					if hasMatchingNote {
-						break
-					}
-				}
-			}
-
-			if hasMatchingNote {
-				filteredByNotes = append(filteredByNotes, session)
-			}
-		}
-		filteredSessions = filteredByNotes
-	}
-
 	// Sort sessions
 	if sortOrder == "asc" {
 		// Already in ascending order (oldest first)
@@ -372,6 +376,139 @@ func (s *Server) handleSessionDetail(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Session not found", http.StatusNotFound)
 }
 
+// handleSessionPreview re-segments a date range under candidate session
+// heuristics and returns the resulting sessions next to the current ones.
+// Nothing is saved, so heuristics can be tuned before changing the config.
+func (s *Server) handleSessionPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		StartDate         string          `json:"start_date"`
+		EndDate           string          `json:"end_date"`
+		SessionHeuristics json.RawMessage `json:"session_heuristics"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	start, end, err := previewRange(req.StartDate, req.EndDate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.RLock()
+	candidate, timeout, err := candidateHeuristics(s.config, req.SessionHeuristics)
+	if err != nil {
+		s.mu.RUnlock()
+		http.Error(w, fmt.Sprintf("Invalid session_heuristics: %v", err), http.StatusBadRequest)
+		return
+	}
+	preview := s.parser.PreviewSegmentation(s.entries, s.sessions, start, end, candidate, timeout, s.sessionIndex)
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// handleTimeoutFit reports the session timeouts fitted to the gaps between
+// commands. POST saves them as the session heuristics and regroups.
+func (s *Server) handleTimeoutFit(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+
+	switch r.Method {
+	case "GET":
+		s.mu.RLock()
+		analysis := AnalyzeTimeout(s.entries, host, s.config.SessionTimeout)
+		s.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(analysis)
+
+	case "POST":
+		s.mu.Lock()
+		analysis := AnalyzeTimeout(s.entries, host, s.config.SessionTimeout)
+		heuristics, err := analysis.Apply(s.config.SessionHeuristics)
+		if err != nil {
+			s.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		s.config.SessionHeuristics = heuristics
+		s.config.SessionTimeout = time.Duration(heuristics.TimeoutMinutes) * time.Minute
+		s.regroupSessions()
+		config := *s.config
+		s.mu.Unlock()
+
+		if err := SaveConfig(&config); err != nil {
+			log.Printf("Failed to save config: %v", err)
+		}
+		s.events.Publish(ServerEvent{Type: EventHistoryReloaded})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"analysis":           analysis,
+			"session_heuristics": heuristics,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSessionSplit starts a new session at a command, by stable ID
+func (s *Server) handleSessionSplit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		CommandID string `json:"command_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CommandID == "" {
+		http.Error(w, "command_id is required", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, err := s.SplitSession(req.CommandID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"session_id": sessionID})
+}
+
+// handleSessionMerge merges adjacent sessions into one
+func (s *Server) handleSessionMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SessionIDs []string `json:"session_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.SessionIDs) < 2 {
+		http.Error(w, "session_ids must list at least two sessions", http.StatusBadRequest)
+		return
+	}
+
+	sessionID, err := s.MergeSessions(req.SessionIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"session_id": sessionID})
+}
+
 func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -394,6 +531,8 @@ type CommandSearchResult struct {
 	SessionID     string    `json:"session_id"` // Changed from int to string
 	SessionDesc   string    `json:"session_description"`
 	CommandID     int       `json:"command_id"`
+	Score         float64       `json:"score,omitempty"`   // regex and fuzzy modes, higher is better
+	Matches       []SearchMatch `json:"matches,omitempty"` // spans of the command that matched
 }
 
 func (s *Server) handleCommandSearch(w http.ResponseWriter, r *http.Request) {
@@ -406,6 +545,23 @@ func (s *Server) handleCommandSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Without a mode, cmd is a base command to find exactly
+	mode := r.URL.Query().Get("mode")
+	var hits []SearchHit
+	if mode != "" {
+		searchMode, err := parseSearchMode(mode, "")
+		if err == nil && searchMode == SearchWords {
+			err = fmt.Errorf("mode words isn't supported here, use regex or fuzzy")
+		}
+		if err == nil {
+			hits, err = scanEntries(s.entries, searchMode, baseCommand, []string{FieldCommand}, time.Now())
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	baseCommand = strings.ToLower(strings.TrimSpace(baseCommand))
 	var results []CommandSearchResult
 
@@ -415,33 +571,57 @@ func (s *Server) handleCommandSearch(w http.ResponseWriter, r *http.Request) {
 		sessionDescMap[session.ID] = session.Description
 	}
 
-	// Search through all entries
-	for _, entry := range s.entries {
-		// Match by base command
-		if strings.ToLower(entry.BaseCommand) == baseCommand {
-			result := CommandSearchResult{
-				Command:      entry.Command,
-				BaseCommand:  entry.BaseCommand,
-				Timestamp:    entry.Timestamp,
-				Directory:    entry.Directory,
-				Category:     string(entry.Category),
-				SessionID:    entry.SessionID,
-				SessionDesc:  sessionDescMap[entry.SessionID],
-				CommandID:    entry.ID,
+	newResult := func(entry *HistoryEntry) CommandSearchResult {
+		return CommandSearchResult{
+			Command:      entry.Command,
+			BaseCommand:  entry.BaseCommand,
+			Timestamp:    entry.Timestamp,
+			Directory:    entry.Directory,
+			Category:     string(entry.Category),
+			SessionID:    entry.SessionID,
+			SessionDesc:  sessionDescMap[entry.SessionID],
+			CommandID:    entry.ID,
+		}
+	}
+
+	if mode != "" {
+		// Best matches first, as scanned. Entry IDs are their 1-based
+		// position in entries.
+		for _, hit := range hits {
+			if hit.Ref < 1 || hit.Ref > len(s.entries) {
+				continue
 			}
+			result := newResult(&s.entries[hit.Ref-1])
+			result.Score, result.Matches = hit.Score, hit.Matches
 			results = append(results, result)
 		}
-	}
+	} else {
+		// Search through all entries
+		for i := range s.entries {
+			// Match by base command
+			if strings.ToLower(s.entries[i].BaseCommand) == baseCommand {
+				results = append(results, newResult(&s.entries[i]))
+			}
+		}
 
-	// Sort by timestamp descending (most recent first)
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Timestamp.After(results[j].Timestamp)
-	})
+		// Sort by timestamp descending (most recent first)
+		sort.Slice(results, func(i, j int) bool {
+			return results[i].Timestamp.After(results[j].Timestamp)
+		})
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
 
+// SearchResult is a command found by /api/search, with its relevance and
+// the spans of its fields that matched
+type SearchResult struct {
+	HistoryEntry
+	Score   float64       `json:"score"`
+	Matches []SearchMatch `json:"matches"`
+}
+
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -451,15 +631,46 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Query parameter 'q' required", http.StatusBadRequest)
 		return
 	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	mode, err := parseSearchMode(r.URL.Query().Get("mode"), SearchWords)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	query = strings.ToLower(query)
-	var results []HistoryEntry
+	// The index leaves out punctuation, so a query of nothing else, like &&
+	// or .., is found as written, newest first
+	pattern := query
+	if mode == SearchWords && len(queryTerms(query)) == 0 {
+		mode, pattern = SearchRegex, "(?i)"+regexp.QuoteMeta(query)
+	}
 
-	for _, entry := range s.entries {
-		if strings.Contains(strings.ToLower(entry.Command), query) ||
-			strings.Contains(strings.ToLower(entry.Directory), query) {
-			results = append(results, entry)
+	var hits []SearchHit
+	if mode == SearchWords {
+		hits = s.index.Search(query, TargetCommand)
+	} else if hits, err = scanEntries(s.entries, mode, pattern, []string{FieldCommand, FieldDirectory}, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	var notes map[string][]Note
+	var tags map[string][]Tag
+	if s.metadata != nil {
+		notes, tags = s.metadata.Annotations()
+	}
+
+	// Entry IDs are their 1-based position in entries
+	results := make([]SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		if hit.Ref < 1 || hit.Ref > len(s.entries) || s.entries[hit.Ref-1].StableID != hit.ID {
+			continue
 		}
+		result := SearchResult{HistoryEntry: s.entries[hit.Ref-1], Score: hit.Score, Matches: hit.Matches}
+		result.Notes, result.Tags = notes[hit.ID], tags[hit.ID]
+		results = append(results, result)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -473,26 +684,31 @@ func (s *Server) handlePatterns(w http.ResponseWriter, r *http.Request) {
 	// Build command patterns
 	patterns := make(map[string]*CommandPattern)
 
-	for _, entry := range s.entries {
-		base := entry.BaseCommand
-		if _, exists := patterns[base]; !exists {
-			patterns[base] = &CommandPattern{
-				Command:      base,
-				Count:        0,
-				CoOccurrence: make(map[string]int),
-				Categories:   make(map[CommandCategory]int),
+	// Count every segment of pipelines and command lists
+	for i := range s.entries {
+		for _, segment := range commandSegments(&s.entries[i]) {
+			base := segment.BaseCommand
+			if _, exists := patterns[base]; !exists {
+				patterns[base] = &CommandPattern{
+					Command:      base,
+					Count:        0,
+					CoOccurrence: make(map[string]int),
+					Categories:   make(map[CommandCategory]int),
+				}
 			}
-		}
 
-		patterns[base].Count++
-		patterns[base].Categories[entry.Category]++
+			patterns[base].Count++
+			patterns[base].Categories[segment.Category]++
+		}
 	}
 
 	// Build co-occurrence within sessions
 	for _, session := range s.sessions {
 		commandsInSession := make(map[string]bool)
-		for _, cmd := range session.Commands {
-			commandsInSession[cmd.BaseCommand] = true
+		for i := range session.Commands {
+			for _, segment := range commandSegments(&session.Commands[i]) {
+				commandsInSession[segment.BaseCommand] = true
+			}
 		}
 
 		// For each pair of commands in the session, increment co-occurrence
@@ -515,15 +731,24 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	categoryStats := make(map[CommandCategory]int)
-	for _, entry := range s.entries {
-		categoryStats[entry.Category]++
+	// Weighted shares of the commands, so that a command in two categories
+	// counts half in each
+	categoryStats := make(map[CommandCategory]float64)
+	subcategoryStats := make(map[CommandCategory]float64)
+	for i := range s.entries {
+		for _, label := range entryLabels(&s.entries[i]) {
+			categoryStats[label.Category] += label.Weight
+			if label.Subcategory != "" {
+				subcategoryStats[label.Subcategory] += label.Weight
+			}
+		}
 	}
 
 	stats := map[string]interface{}{
 		"total_commands":  len(s.entries),
 		"total_sessions":  len(s.sessions),
 		"categories":      categoryStats,
+		"subcategories":   subcategoryStats,
 		"last_updated":    s.lastModTime,
 	}
 
@@ -585,14 +810,12 @@ func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
 	format := r.URL.Query().Get("format")
 	sessionIDStr := r.URL.Query().Get("session")
 
-	// Get filter parameters (same as handleSessions)
-	startDate := r.URL.Query().Get("startDate")
-	endDate := r.URL.Query().Get("endDate")
-	category := r.URL.Query().Get("category")
-	keyword := r.URL.Query().Get("keyword")
-	noteSearch := r.URL.Query().Get("noteSearch")
-	tagKeyword := r.URL.Query().Get("tagKeyword")
-	tagStarsStr := r.URL.Query().Get("tagStars")
+	// Filters, as for handleSessions
+	query, err := sessionQueryFromParams(r.URL.Query().Get)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+		return
+	}
 
 	var sessions []Session
 	if sessionIDStr != "" {
@@ -606,151 +829,14 @@ func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// Apply filters (same logic as handleSessions)
-		filteredSessions := make([]Session, 0)
-		for _, session := range s.sessions {
-			// Date filtering
-			if startDate != "" {
-				if start, err := time.Parse("2006-01-02", startDate); err == nil {
-					if session.StartTime.Before(start) {
-						continue
-					}
-				}
-			}
-			if endDate != "" {
-				if end, err := time.Parse("2006-01-02", endDate); err == nil {
-					end = end.Add(24 * time.Hour)
-					if session.EndTime.After(end) {
-						continue
-					}
-				}
-			}
-
-			// Category filtering
-			if category != "" && category != "all" {
-				found := false
-				for cat := range session.Categories {
-					if string(cat) == category {
-						found = true
-						break
-					}
-				}
-				if !found {
-					continue
-				}
-			}
-
-			// Keyword filtering
-			if keyword != "" {
-				tokens := strings.Fields(strings.ToLower(keyword))
-				allTokensFound := true
-				for _, token := range tokens {
-					tokenFound := false
-					if strings.Contains(strings.ToLower(session.Description), token) {
-						tokenFound = true
-					}
-					if !tokenFound {
-						for _, cmd := range session.Commands {
-							if strings.Contains(strings.ToLower(cmd.Command), token) {
-								tokenFound = true
-								break
-							}
-						}
-					}
-					if !tokenFound {
-						allTokensFound = false
-						break
-					}
-				}
-				if !allTokensFound {
-					continue
-				}
-			}
-
-			filteredSessions = append(filteredSessions, session)
+		for _, session := range s.querySessions(query) {
+			sessions = append(sessions, *session)
 		}
 
 		// Merge metadata for filtered sessions
 		if s.metadata != nil {
-			filteredSessions = s.metadata.MergeIntoSessions(filteredSessions)
-		}
-
-		// Filter by tag keywords
-		if tagKeyword != "" {
-			temp := make([]Session, 0)
-			for _, session := range filteredSessions {
-				hasMatchingTag := false
-				for _, tag := range session.Tags {
-					if strings.Contains(strings.ToLower(tag.Keyword), strings.ToLower(tagKeyword)) {
-						hasMatchingTag = true
-						break
-					}
-				}
-				if !hasMatchingTag {
-					for _, cmd := range session.Commands {
-						for _, tag := range cmd.Tags {
-							if strings.Contains(strings.ToLower(tag.Keyword), strings.ToLower(tagKeyword)) {
-								hasMatchingTag = true
-								break
-							}
-						}
-						if hasMatchingTag {
-							break
-						}
-					}
-				}
-				if hasMatchingTag {
-					temp = append(temp, session)
-				}
-			}
-			filteredSessions = temp
-		}
-
-		// Filter by star rating
-		if tagStarsStr != "" {
-			tagStars, _ := strconv.Atoi(tagStarsStr)
-			if tagStars > 0 {
-				temp := make([]Session, 0)
-				for _, session := range filteredSessions {
-					if session.Metadata != nil && session.Metadata.StarRating >= tagStars {
-						temp = append(temp, session)
-					}
-				}
-				filteredSessions = temp
-			}
+			sessions = s.metadata.MergeIntoSessions(sessions)
 		}
-
-		// Filter by notes
-		if noteSearch != "" {
-			temp := make([]Session, 0)
-			for _, session := range filteredSessions {
-				hasMatchingNote := false
-				for _, note := range session.Notes {
-					if strings.Contains(strings.ToLower(note.Text), strings.ToLower(noteSearch)) {
-						hasMatchingNote = true
-						break
-					}
-				}
-				if !hasMatchingNote {
-					for _, cmd := range session.Commands {
-						for _, note := range cmd.Notes {
-							if strings.Contains(strings.ToLower(note.Text), strings.ToLower(noteSearch)) {
-								hasMatchingNote = true
-								break
-							}
-						}
-						if hasMatchingNote {
-							break
-						}
-					}
-				}
-				if hasMatchingNote {
-					temp = append(temp, session)
-				}
-			}
-			filteredSessions = temp
-		}
-
-		sessions = filteredSessions
 	}
 
 	var content string
@@ -880,6 +966,89 @@ func (s *Server) handleLLMAnalyze(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleLLMCategories lists the categories suggested for uncategorized
+// commands, optionally only those with ?status=suggested, accepted or rejected
+func (s *Server) handleLLMCategories(w http.ResponseWriter, r *http.Request) {
+	status := ClassificationStatus(r.URL.Query().Get("status"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.classifications.List(status))
+}
+
+// handleLLMCategoryReview accepts or rejects a suggested category. Accepting
+// can correct the category. Every command is then categorized again.
+func (s *Server) handleLLMCategoryReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		BaseCommand string          `json:"base_command"`
+		Action      string          `json:"action"`             // "accept" or "reject"
+		Category    CommandCategory `json:"category,omitempty"` // replaces the suggestion when accepting
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Action != "accept" && req.Action != "reject" {
+		http.Error(w, "action must be accept or reject", http.StatusBadRequest)
+		return
+	}
+	if _, ok := knownCategory(req.Category); req.Category != "" && !ok {
+		http.Error(w, fmt.Sprintf("Unknown category %q", req.Category), http.StatusBadRequest)
+		return
+	}
+
+	classification, err := s.classifications.Review(req.BaseCommand, req.Action == "accept", req.Category)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := s.classifications.Save(); err != nil {
+		log.Printf("Warning: Failed to save command classifications: %v", err)
+	}
+
+	s.mu.Lock()
+	s.applyClassifications()
+	s.mu.Unlock()
+	s.events.Publish(ServerEvent{Type: EventHistoryReloaded})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(classification)
+}
+
+// handleLLMCategoryRun starts classifying uncategorized commands now,
+// without waiting for the background job's next run
+func (s *Server) handleLLMCategoryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The background classifier may still be running after it was turned off
+	s.mu.RLock()
+	background := s.classifierRunning && s.config.ClassifyOtherCommands
+	s.mu.RUnlock()
+
+	if background {
+		select {
+		case s.classifyWake <- struct{}{}:
+		default: // a run is already due
+		}
+	} else {
+		go func() {
+			if _, err := s.classifyUncategorized(); err != nil {
+				log.Printf("Warning: Failed to classify uncategorized commands: %v", err)
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "started"})
+}
+
 // Helper function to truncate strings for logging
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -906,15 +1075,27 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		}
 
 		s.mu.Lock()
+		rewatch := strings.Join(watchedFiles(s.config), "\n") != strings.Join(watchedFiles(&newConfig), "\n")
 		s.config = &newConfig
 		s.parser = NewParser(s.config)
+		s.cursors.Reset()
 		s.ollama = NewOllamaClient(s.config.OllamaURL, s.config.OllamaModel)
+		if s.config.ClassifyOtherCommands {
+			s.startClassifier()
+		}
 		s.mu.Unlock()
 
 		if err := SaveConfig(&newConfig); err != nil {
 			log.Printf("Failed to save config: %v", err)
 		}
 
+		// Push changes of the new history files instead of the old ones
+		if rewatch {
+			if err := s.WatchHistory(); err != nil {
+				log.Printf("Warning: Failed to watch history file: %v", err)
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 		return
@@ -933,7 +1114,7 @@ func (s *Server) handleNotes(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		var req struct {
 			TargetType string `json:"target_type"`
-			TargetID   int    `json:"target_id"`
+			TargetID   string `json:"target_id"`
 			Text       string `json:"text"`
 		}
 
@@ -953,11 +1134,17 @@ func (s *Server) handleNotes(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !targetType.ValidID(req.TargetID) {
+			http.Error(w, "Invalid target_id. Must be a session id (sess_...) or a command stable_id (cmd_...)", http.StatusBadRequest)
+			return
+		}
+
 		note, err := s.metadata.AddNote(targetType, req.TargetID, req.Text)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to add note: %v", err), http.StatusInternalServerError)
 			return
 		}
+		s.annotate(note.TargetType, note.TargetID)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -984,6 +1171,7 @@ func (s *Server) handleNotes(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("Failed to update note: %v", err), http.StatusInternalServerError)
 			return
 		}
+		s.annotate(note.TargetType, note.TargetID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(note)
@@ -995,10 +1183,12 @@ func (s *Server) handleNotes(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		targetType, targetID, _ := s.metadata.NoteTarget(noteID)
 		if err := s.metadata.DeleteNote(noteID); err != nil {
 			http.Error(w, fmt.Sprintf("Failed to delete note: %v", err), http.StatusInternalServerError)
 			return
 		}
+		s.annotate(targetType, targetID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "message": "Note deleted"})
@@ -1018,7 +1208,7 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		var req struct {
 			TargetType string `json:"target_type"`
-			TargetID   int    `json:"target_id"`
+			TargetID   string `json:"target_id"`
 			Keyword    string `json:"keyword"`
 		}
 
@@ -1033,6 +1223,11 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !targetType.ValidID(req.TargetID) {
+			http.Error(w, "Invalid target_id. Must be a session id (sess_...) or a command stable_id (cmd_...)", http.StatusBadRequest)
+			return
+		}
+
 		if req.Keyword == "" {
 			http.Error(w, "keyword is required", http.StatusBadRequest)
 			return
@@ -1043,6 +1238,7 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("Failed to add tag: %v", err), http.StatusInternalServerError)
 			return
 		}
+		s.annotate(tag.TargetType, tag.TargetID)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -1055,10 +1251,12 @@ func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		targetType, targetID, _ := s.metadata.TagTarget(tagID)
 		if err := s.metadata.DeleteTag(tagID); err != nil {
 			http.Error(w, fmt.Sprintf("Failed to delete tag: %v", err), http.StatusInternalServerError)
 			return
 		}
+		s.annotate(targetType, targetID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "message": "Tag deleted"})
@@ -1078,7 +1276,7 @@ func (s *Server) handleSessionMetadata(w http.ResponseWriter, r *http.Request) {
 	case "POST", "PUT":
 		var req struct {
 			TargetType string `json:"target_type"`
-			TargetID   int    `json:"target_id"`
+			TargetID   string `json:"target_id"`
 			ColorCode  string `json:"color_code"`
 			StarRating int    `json:"star_rating"`
 		}
@@ -1094,6 +1292,11 @@ func (s *Server) handleSessionMetadata(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !targetType.ValidID(req.TargetID) {
+			http.Error(w, "Invalid target_id. Must be a session id (sess_...) or a command stable_id (cmd_...)", http.StatusBadRequest)
+			return
+		}
+
 		metadata, err := s.metadata.SetSessionMetadata(targetType, req.TargetID, req.ColorCode, req.StarRating)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to set metadata: %v", err), http.StatusInternalServerError)
@@ -1108,75 +1311,211 @@ func (s *Server) handleSessionMetadata(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Helper methods for native UI
+// SplitSession starts a new session at the command with the given stable ID
+// and returns the new session's ID. The boundary is saved in the session
+// index, and the new session gets copies of the notes, tags and rating of the
+// one it was split from.
+func (s *Server) SplitSession(commandID string) (string, error) {
+	s.mu.Lock()
+	sessionID, err := s.splitSession(commandID)
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
 
-func (s *Server) ParseHistory() error {
-	return s.refreshData()
+	s.events.Publish(ServerEvent{Type: EventHistoryReloaded})
+	return sessionID, nil
 }
 
-func (s *Server) GetSessions(startDate, endDate, category, keyword string) []*Session {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *Server) splitSession(commandID string) (string, error) {
+	if s.sessionIndex == nil {
+		return "", fmt.Errorf("session index not available")
+	}
 
-	filteredSessions := make([]*Session, 0)
-	for i := range s.sessions {
-		session := &s.sessions[i]
-		
-		// Date filtering
-		if startDate != "" {
-			if start, err := time.Parse("2006-01-02", startDate); err == nil {
-				if session.StartTime.Before(start) {
-					continue
-				}
-			}
+	session, position := s.findCommandSession(commandID)
+	if session == nil {
+		return "", fmt.Errorf("command not found in any session: %s", commandID)
+	}
+	if position == 0 {
+		return "", fmt.Errorf("command already starts session %s", session.ID)
+	}
+	original := session.ID
+
+	s.sessionIndex.SetOverride(commandID, true)
+	s.regroupSessions()
+
+	split, _ := s.findCommandSession(commandID)
+	if split == nil {
+		return "", fmt.Errorf("command %s not found after splitting", commandID)
+	}
+	if s.metadata != nil {
+		if err := s.metadata.CopyTarget(TargetSession, original, split.ID); err != nil {
+			log.Printf("Warning: Failed to copy session metadata: %v", err)
 		}
-		if endDate != "" {
-			if end, err := time.Parse("2006-01-02", endDate); err == nil {
-				end = end.Add(24 * time.Hour)
-				if session.EndTime.After(end) {
-					continue
-				}
+		s.annotate(TargetSession, split.ID)
+	}
+	return split.ID, nil
+}
+
+// MergeSessions merges adjacent sessions of one host into a single session
+// and returns its ID. The boundaries are saved in the session index, and the
+// merged session collects the notes, tags and rating of all of them.
+func (s *Server) MergeSessions(sessionIDs []string) (string, error) {
+	s.mu.Lock()
+	sessionID, err := s.mergeSessions(sessionIDs)
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	s.events.Publish(ServerEvent{Type: EventHistoryReloaded})
+	return sessionID, nil
+}
+
+func (s *Server) mergeSessions(sessionIDs []string) (string, error) {
+	if s.sessionIndex == nil {
+		return "", fmt.Errorf("session index not available")
+	}
+
+	wanted := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		wanted[id] = true
+	}
+
+	// The sessions must follow each other among the sessions of their host
+	// and terminal
+	var merged []Session
+	host, terminal := "", ""
+	for _, session := range s.sessions {
+		if wanted[session.ID] {
+			if len(merged) > 0 && (session.Host != host || session.Terminal != terminal) {
+				return "", fmt.Errorf("sessions from different hosts or terminals can't be merged")
 			}
+			host, terminal = session.Host, session.Terminal
+			merged = append(merged, session)
+		} else if len(merged) > 0 && len(merged) < len(wanted) && session.Host == host && session.Terminal == terminal {
+			return "", fmt.Errorf("only adjacent sessions can be merged, %s is in between", session.ID)
 		}
-		
-		// Category filtering
-		if category != "" && category != "all" {
-			found := false
-			for cat := range session.Categories {
-				if string(cat) == category {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
+	}
+	if len(merged) != len(wanted) {
+		return "", fmt.Errorf("session not found")
+	}
+
+	for _, session := range merged[1:] {
+		s.sessionIndex.SetOverride(session.Commands[0].StableID, false)
+	}
+	s.regroupSessions()
+
+	// Only move metadata once the sessions really are one
+	result, _ := s.findCommandSession(merged[0].Commands[0].StableID)
+	if result == nil {
+		return "", fmt.Errorf("session %s not found after merging", merged[0].ID)
+	}
+	for _, session := range merged[1:] {
+		if found, _ := s.findCommandSession(session.Commands[0].StableID); found == nil || found.ID != result.ID {
+			return "", fmt.Errorf("session %s could not be merged into %s", session.ID, result.ID)
 		}
-		
-		// Keyword filtering
-		if keyword != "" {
-			found := false
-			keywordLower := strings.ToLower(keyword)
-			if strings.Contains(strings.ToLower(session.Description), keywordLower) {
-				found = true
-			}
-			if !found {
-				for _, cmd := range session.Commands {
-					if strings.Contains(strings.ToLower(cmd.Command), keywordLower) {
-						found = true
-						break
-					}
-				}
+	}
+	if s.metadata != nil {
+		for _, session := range merged {
+			if err := s.metadata.MoveTarget(TargetSession, session.ID, result.ID); err != nil {
+				log.Printf("Warning: Failed to move session metadata: %v", err)
 			}
-			if !found {
-				continue
+		}
+		s.annotate(TargetSession, result.ID)
+	}
+	return result.ID, nil
+}
+
+// findCommandSession returns the session holding the command with the given
+// stable ID and the command's position in it. Caller must hold the lock.
+func (s *Server) findCommandSession(commandID string) (*Session, int) {
+	for i := range s.sessions {
+		for j, cmd := range s.sessions[i].Commands {
+			if cmd.StableID == commandID {
+				return &s.sessions[i], j
 			}
 		}
-		
-		filteredSessions = append(filteredSessions, session)
 	}
+	return nil, -1
+}
 
-	return filteredSessions
+// regroupSessions groups all entries again, after boundaries were changed by
+// hand, reindexes them and saves the session index. Caller must hold the write lock.
+func (s *Server) regroupSessions() {
+	s.sessions = s.parser.GroupIntoSessions(s.entries, s.sessionIndex)
+	s.reindex()
+	if err := s.sessionIndex.Save(); err != nil {
+		log.Printf("Warning: Failed to save session index: %v", err)
+	}
+}
+
+// Helper methods for native UI
+
+func (s *Server) ParseHistory() error {
+	return s.refreshData()
+}
+
+// GetSessions returns the sessions matching query, or all of them when it
+// is nil
+func (s *Server) GetSessions(query *SessionQuery) []*Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if query == nil {
+		query = &SessionQuery{}
+	}
+	return s.querySessions(query)
+}
+
+// Hosts returns the distinct hosts sessions were recorded on, sorted
+func (s *Server) Hosts() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, session := range s.sessions {
+		if session.Host != "" && !seen[session.Host] {
+			seen[session.Host] = true
+			hosts = append(hosts, session.Host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Projects returns the distinct projects of the sessions' commands, sorted
+func (s *Server) Projects() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var projects []string
+	for _, summary := range summarizeProjects(s.sessions) {
+		projects = append(projects, summary.Name)
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+// Categories returns the categories and subcategories of the sessions, each
+// category followed by its subcategories
+func (s *Server) Categories() []CommandCategory {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[CommandCategory]bool)
+	var categories []CommandCategory
+	for _, session := range s.sessions {
+		for cat := range session.Categories {
+			if !seen[cat] {
+				seen[cat] = true
+				categories = append(categories, cat)
+			}
+		}
+	}
+	sortCategories(categories)
+	return categories
 }
 
 func (s *Server) ExportSessions(sessions []*Session, format string) ([]byte, error) {
@@ -1254,6 +1593,38 @@ type DirectoryNode struct {
 	Children     map[string]*DirectoryNode `json:"children,omitempty"`
 }
 
+// handleProjects lists the projects with their command counts, time spent
+// and last activity, most recently active first
+func (s *Server) handleProjects(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeProjects(s.sessions))
+}
+
+// handleCategorize explains the category of ?cmd=, optionally as if run in
+// ?dir= (and its project, unless ?project= is given)
+func (s *Server) handleCategorize(w http.ResponseWriter, r *http.Request) {
+	command := r.URL.Query().Get("cmd")
+	if strings.TrimSpace(command) == "" {
+		http.Error(w, "cmd is required", http.StatusBadRequest)
+		return
+	}
+	directory := r.URL.Query().Get("dir")
+	project := r.URL.Query().Get("project")
+
+	s.mu.RLock()
+	expanded := s.parser.aliases.Expand(command)
+	if project == "" {
+		project = s.parser.projects.Resolve(directory)
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExplainCategory(command, expanded, directory, project))
+}
+
 func (s *Server) handleDirectories(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
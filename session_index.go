@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -15,6 +17,7 @@ import (
 type SessionBoundary struct {
 	ID               string    `json:"id"`                // Stable hash-based ID
 	SequenceNumber   int       `json:"sequence_number"`   // Display number (Session #5)
+	Host             string    `json:"host,omitempty"`
 	StartTime        time.Time `json:"start_time"`
 	EndTime          time.Time `json:"end_time"`
 	FirstCommand     string    `json:"first_command"`
@@ -22,10 +25,26 @@ type SessionBoundary struct {
 	Description      string    `json:"description"`
 }
 
+// SessionOverride is a session boundary set by hand, which grouping
+// respects whatever the segmentation strategy says
+type SessionOverride struct {
+	CommandID string    `json:"command_id"` // Stable ID of the command
+	Split     bool      `json:"split"`      // Whether the command starts a session or continues the previous one
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// sessionIndexFile is the layout of sessions.json. Older versions wrote
+// just the array of boundaries.
+type sessionIndexFile struct {
+	Sessions  []*SessionBoundary `json:"sessions"`
+	Overrides []SessionOverride  `json:"overrides,omitempty"`
+}
+
 // SessionIndex manages stable session IDs and boundaries
 type SessionIndex struct {
 	mu         sync.RWMutex
 	boundaries map[string]*SessionBoundary // ID -> Boundary
+	overrides  map[string]SessionOverride  // command ID -> Override
 	filePath   string
 }
 
@@ -35,6 +54,7 @@ func NewSessionIndex(configDir string) (*SessionIndex, error) {
 	
 	index := &SessionIndex{
 		boundaries: make(map[string]*SessionBoundary),
+		overrides:  make(map[string]SessionOverride),
 		filePath:   filePath,
 	}
 	
@@ -46,21 +66,37 @@ func NewSessionIndex(configDir string) (*SessionIndex, error) {
 	return index, nil
 }
 
-// GenerateStableID creates a stable session ID from the first command
-func GenerateStableID(timestamp time.Time, command string) string {
+// GenerateStableID creates a stable session ID from the first command.
+// The host is only mixed in when set so single-source IDs don't change.
+func GenerateStableID(host string, timestamp time.Time, command string) string {
 	// Create a hash from timestamp + command
 	data := fmt.Sprintf("%d:%s", timestamp.Unix(), command)
+	if host != "" {
+		data = host + ":" + data
+	}
 	hash := sha256.Sum256([]byte(data))
 	// Use first 12 chars of hex for readability
 	return fmt.Sprintf("sess_%x", hash[:6])
 }
 
+// GenerateCommandID creates a stable command ID from when the command ran,
+// its text and how many identical commands ran before it in the same second.
+// Unlike the line ordinal, it survives zsh trimming or deduplicating the file.
+func GenerateCommandID(host string, timestamp time.Time, command string, occurrence int) string {
+	data := fmt.Sprintf("%d:%d:%s", timestamp.Unix(), occurrence, command)
+	if host != "" {
+		data = host + ":" + data
+	}
+	hash := sha256.Sum256([]byte(data))
+	return fmt.Sprintf("cmd_%x", hash[:6])
+}
+
 // GetOrCreate returns existing session ID or creates a new one
-func (si *SessionIndex) GetOrCreate(startTime time.Time, endTime time.Time, firstCommand string, description string) string {
+func (si *SessionIndex) GetOrCreate(host string, startTime time.Time, endTime time.Time, firstCommand string, description string) string {
 	si.mu.Lock()
 	defer si.mu.Unlock()
 	
-	stableID := GenerateStableID(startTime, firstCommand)
+	stableID := GenerateStableID(host, startTime, firstCommand)
 	
 	if existing, found := si.boundaries[stableID]; found {
 		// Update end time if it changed (session grew)
@@ -75,6 +111,7 @@ func (si *SessionIndex) GetOrCreate(startTime time.Time, endTime time.Time, firs
 	boundary := &SessionBoundary{
 		ID:               stableID,
 		SequenceNumber:   len(si.boundaries) + 1,
+		Host:             host,
 		StartTime:        startTime,
 		EndTime:          endTime,
 		FirstCommand:     firstCommand,
@@ -105,6 +142,48 @@ func (si *SessionIndex) GetByID(sessionID string) *SessionBoundary {
 	return si.boundaries[sessionID]
 }
 
+// IDsBySequenceNumber maps the display sequence numbers currently in the
+// index, as strings, to session IDs
+func (si *SessionIndex) IDsBySequenceNumber() map[string]string {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	
+	ids := make(map[string]string, len(si.boundaries))
+	for id, boundary := range si.boundaries {
+		ids[strconv.Itoa(boundary.SequenceNumber)] = id
+	}
+	return ids
+}
+
+// SetOverride records by hand whether the command with the given stable ID
+// starts a session
+func (si *SessionIndex) SetOverride(commandID string, split bool) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	
+	si.overrides[commandID] = SessionOverride{
+		CommandID: commandID,
+		Split:     split,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Overrides maps the stable IDs of commands with a manual boundary to
+// whether they start a session. A nil index has none.
+func (si *SessionIndex) Overrides() map[string]bool {
+	if si == nil {
+		return nil
+	}
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	
+	overrides := make(map[string]bool, len(si.overrides))
+	for id, o := range si.overrides {
+		overrides[id] = o.Split
+	}
+	return overrides
+}
+
 // ReassignSequenceNumbers ensures sequence numbers are ordered by start time
 func (si *SessionIndex) ReassignSequenceNumbers() {
 	si.mu.Lock()
@@ -137,15 +216,24 @@ func (si *SessionIndex) Load() error {
 		return err
 	}
 	
-	var boundaries []*SessionBoundary
-	if err := json.Unmarshal(data, &boundaries); err != nil {
+	var file sessionIndexFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &file.Sessions)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to parse session index: %w", err)
 	}
 	
 	si.boundaries = make(map[string]*SessionBoundary)
-	for _, b := range boundaries {
+	for _, b := range file.Sessions {
 		si.boundaries[b.ID] = b
 	}
+	si.overrides = make(map[string]SessionOverride)
+	for _, o := range file.Overrides {
+		si.overrides[o.CommandID] = o
+	}
 	
 	return nil
 }
@@ -166,7 +254,15 @@ func (si *SessionIndex) Save() error {
 		return boundaries[i].SequenceNumber < boundaries[j].SequenceNumber
 	})
 	
-	data, err := json.MarshalIndent(boundaries, "", "  ")
+	file := sessionIndexFile{Sessions: boundaries}
+	for _, o := range si.overrides {
+		file.Overrides = append(file.Overrides, o)
+	}
+	sort.Slice(file.Overrides, func(i, j int) bool {
+		return file.Overrides[i].CreatedAt.Before(file.Overrides[j].CreatedAt)
+	})
+	
+	data, err := json.MarshalIndent(file, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal session index: %w", err)
 	}
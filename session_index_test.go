@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionIndex_Overrides(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Older versions wrote just the array of boundaries
+	legacy := `[{"id": "sess_111", "sequence_number": 1, "start_time": "2024-03-01T09:00:00Z"}]`
+	if err := os.WriteFile(filepath.Join(tmpDir, "sessions.json"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write session index: %v", err)
+	}
+	index, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+	if index.GetByID("sess_111") == nil {
+		t.Fatalf("Session from array index not loaded")
+	}
+
+	index.SetOverride("cmd_aaa", true)
+	index.SetOverride("cmd_bbb", false)
+	if err := index.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reloaded, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+	overrides := reloaded.Overrides()
+	if split, ok := overrides["cmd_aaa"]; !ok || !split {
+		t.Errorf("cmd_aaa override = %v, %v, want split", split, ok)
+	}
+	if split, ok := overrides["cmd_bbb"]; !ok || split {
+		t.Errorf("cmd_bbb override = %v, %v, want merge", split, ok)
+	}
+	if reloaded.GetByID("sess_111") == nil {
+		t.Errorf("Session lost after saving")
+	}
+
+	var none *SessionIndex
+	if none.Overrides() != nil {
+		t.Errorf("nil index has overrides")
+	}
+}
+
+func TestServer_SplitAndMergeSessions(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &Config{HomeDir: tmpDir, SessionTimeout: 30 * time.Minute}
+	config.SessionHeuristics.MinCommandsPerSession = 1
+
+	sessionIndex, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+	metadata := &MetadataStore{
+		Version:          metadataVersion,
+		Notes:            make(map[string]Note),
+		Tags:             make(map[string]Tag),
+		SessionMetadatas: make(map[string]SessionMetadata),
+		filePath:         filepath.Join(tmpDir, "metadata.json"),
+	}
+	s := &Server{
+		config:       config,
+		parser:       &Parser{config: config},
+		metadata:     metadata,
+		sessionIndex: sessionIndex,
+		events:       NewEventHub(),
+	}
+
+	s.entries = segmentationEntries("0 /a vcs", "5 /a vcs", "10 /a build", "15 /a build", "60 /a vcs")
+	assignCommandIDs(s.entries)
+	s.sessions = s.parser.GroupIntoSessions(s.entries, sessionIndex)
+	if got := sessionSizes(s.sessions); len(got) != 2 || got[0] != 4 {
+		t.Fatalf("Initial sessions = %v, want [4 1]", got)
+	}
+	whole := s.sessions[0].ID
+	if _, err := metadata.AddNote(TargetSession, whole, "refactoring"); err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+	if _, err := metadata.SetSessionMetadata(TargetSession, whole, "", 4); err != nil {
+		t.Fatalf("SetSessionMetadata failed: %v", err)
+	}
+
+	// Split where the build starts
+	splitID, err := s.SplitSession(s.entries[2].StableID)
+	if err != nil {
+		t.Fatalf("SplitSession failed: %v", err)
+	}
+	if got := sessionSizes(s.sessions); len(got) != 3 || got[0] != 2 || got[1] != 2 {
+		t.Fatalf("Sessions after split = %v, want [2 2 1]", got)
+	}
+	if s.sessions[1].ID != splitID || s.sessions[0].ID != whole {
+		t.Errorf("Split sessions are %q and %q, want %q and %q", s.sessions[0].ID, s.sessions[1].ID, whole, splitID)
+	}
+	if notes := metadata.GetNotesForTarget(TargetSession, splitID); len(notes) != 1 {
+		t.Errorf("Split session has %d notes, want a copy of the original's", len(notes))
+	}
+	if meta := metadata.GetSessionMetadata(TargetSession, splitID); meta == nil || meta.StarRating != 4 {
+		t.Errorf("Split session metadata = %+v, want the original's rating", meta)
+	}
+	if _, err := s.SplitSession(s.entries[2].StableID); err == nil {
+		t.Errorf("Splitting at the first command of a session succeeded")
+	}
+
+	// The split survives regrouping from the saved index
+	reloaded, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+	if got := sessionSizes(s.parser.GroupIntoSessions(s.entries, reloaded)); len(got) != 3 {
+		t.Errorf("Sessions from saved index = %v, want 3", got)
+	}
+
+	// Merge the build with the session the timeout split off
+	if _, err := s.MergeSessions([]string{s.sessions[0].ID, s.sessions[2].ID}); err == nil {
+		t.Errorf("Merging sessions that aren't adjacent succeeded")
+	}
+	last := s.sessions[2].ID
+	if _, err := metadata.AddTag(TargetSession, last, "deploy"); err != nil {
+		t.Fatalf("AddTag failed: %v", err)
+	}
+	mergedID, err := s.MergeSessions([]string{splitID, last})
+	if err != nil {
+		t.Fatalf("MergeSessions failed: %v", err)
+	}
+	if got := sessionSizes(s.sessions); len(got) != 2 || got[1] != 3 {
+		t.Fatalf("Sessions after merge = %v, want [2 3]", got)
+	}
+	if mergedID != splitID {
+		t.Errorf("Merged session ID = %q, want the first session's %q", mergedID, splitID)
+	}
+	if tags := metadata.GetTagsForTarget(TargetSession, mergedID); len(tags) != 1 || tags[0].Keyword != "deploy" {
+		t.Errorf("Merged session tags = %+v, want the tag of the merged-in session", tags)
+	}
+	if notes := metadata.GetNotesForTarget(TargetSession, mergedID); len(notes) != 1 {
+		t.Errorf("Merged session has %d notes, want 1", len(notes))
+	}
+}
+
+func TestServer_MergeSessionsByTerminal(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &Config{HomeDir: tmpDir, SessionTimeout: 30 * time.Minute}
+	config.SessionHeuristics.MinCommandsPerSession = 1
+	config.SessionHeuristics.SplitConcurrentTerminals = true
+
+	sessionIndex, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+	metadata := &MetadataStore{
+		Version:          metadataVersion,
+		Notes:            make(map[string]Note),
+		Tags:             make(map[string]Tag),
+		SessionMetadatas: make(map[string]SessionMetadata),
+		filePath:         filepath.Join(tmpDir, "metadata.json"),
+	}
+	s := &Server{
+		config:       config,
+		parser:       &Parser{config: config},
+		metadata:     metadata,
+		sessionIndex: sessionIndex,
+		events:       NewEventHub(),
+	}
+
+	// Terminal tty1 runs A1 and, after a pause, A2; tty2 runs B1 in between
+	s.entries = segmentationEntries("0 /a vcs", "5 /a vcs", "10 /b build", "60 /a vcs")
+	for i, hint := range []string{"tty1", "tty1", "tty2", "tty1"} {
+		s.entries[i].SessionHint = hint
+	}
+	assignCommandIDs(s.entries)
+	s.sessions = s.parser.GroupIntoSessions(s.entries, sessionIndex)
+	if got := sessionSizes(s.sessions); len(got) != 3 || got[0] != 2 {
+		t.Fatalf("Initial sessions = %v, want [2 1 1]", got)
+	}
+	a1, b1, a2 := s.sessions[0].ID, s.sessions[1].ID, s.sessions[2].ID
+	if _, err := metadata.AddTag(TargetSession, b1, "build"); err != nil {
+		t.Fatalf("AddTag failed: %v", err)
+	}
+
+	if _, err := s.MergeSessions([]string{a1, b1}); err == nil {
+		t.Errorf("Merging sessions of different terminals succeeded")
+	}
+	if tags := metadata.GetTagsForTarget(TargetSession, b1); len(tags) != 1 {
+		t.Errorf("Session %s has %d tags after a refused merge, want 1", b1, len(tags))
+	}
+
+	// B1 is in between only in time, not in its terminal
+	merged, err := s.MergeSessions([]string{a1, a2})
+	if err != nil {
+		t.Fatalf("MergeSessions failed: %v", err)
+	}
+	if got := sessionSizes(s.sessions); len(got) != 2 {
+		t.Fatalf("Sessions after merge = %v, want 2", got)
+	}
+	if session, _ := s.findCommandSession(s.entries[3].StableID); session == nil || session.ID != merged {
+		t.Errorf("Last command of tty1 is not in the merged session %s", merged)
+	}
+}
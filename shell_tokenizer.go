@@ -0,0 +1,526 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// CommandSegment is one simple command of a command line: a pipeline stage
+// or a command joined to the others by &&, ||, ; or &. Wrappers such as sudo
+// and env and leading variable assignments are not part of it.
+type CommandSegment struct {
+	Command     string          `json:"command"`
+	BaseCommand string          `json:"base_command"`
+	Category    CommandCategory `json:"category"`
+	Subcategory CommandCategory `json:"subcategory,omitempty"`
+	Labels      []CategoryLabel `json:"labels,omitempty"`   // Category and further categories, with their weights
+	Operator    string          `json:"operator,omitempty"` // what joins it to the previous segment: |, |&, &&, ||, ; or &
+}
+
+type shellTokenKind int
+
+const (
+	shellWord     shellTokenKind = iota
+	shellOperator                // | |& || && & ; ;; ( ), a newline is read as ;
+	shellRedirect                // < > >> <<< etc, the next word is its target
+)
+
+type shellToken struct {
+	kind  shellTokenKind
+	text  string // unquoted text of a word, or the operator itself
+	start int    // byte offsets in the command line
+	end   int
+}
+
+// Longest first so that "||" isn't read as two pipes
+var shellOperators = []string{"&&", "||", "|&", ";;", "|", "&", ";", "(", ")"}
+
+var shellRedirects = []string{"&>>", "<<<", "<<-", "&>", "<<", "<>", "<&", ">>", ">&", ">|", "<", ">"}
+
+// Commands that run the command given in their arguments, with the options
+// of each that take a separate value
+var commandWrappers = map[string][]string{
+	"sudo":    {"-u", "-g", "-h", "-p", "-C", "-D", "-r", "-t", "-U", "-T"},
+	"doas":    {"-u", "-C"},
+	"env":     {"-u", "-C", "-S", "--unset", "--chdir", "--split-string"},
+	"time":    {"-f", "-o", "--format", "--output"},
+	"nohup":   nil,
+	"nice":    {"-n", "--adjustment"},
+	"exec":    {"-a"},
+	"command": nil,
+	"builtin": nil,
+	"noglob":  nil,
+}
+
+// Reserved words that only introduce or close the commands around them
+var shellKeywords = map[string]bool{
+	"!": true, "{": true, "}": true, "if": true, "then": true, "elif": true, "else": true, "fi": true,
+	"while": true, "until": true, "do": true, "done": true, "esac": true,
+}
+
+// Reserved words whose arguments aren't a command
+var shellNonCommands = map[string]bool{
+	"for": true, "select": true, "case": true, "function": true,
+}
+
+var shellAssignmentRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\[[^]]*\])?\+?=`)
+
+// simpleCommand is the command word and arguments of one segment
+type simpleCommand struct {
+	words    []shellToken // command first, keywords, assignments and wrappers stripped
+	operator string       // what joins it to the previous command
+	subshell bool         // runs inside ( ), so a cd doesn't last
+}
+
+// SplitCommandSegments splits a command line into its simple commands. It
+// follows shell quoting and escaping, skips comments, redirections and here
+// documents, and treats subshells and command groups as the commands inside
+// them. Command substitutions stay part of the word they appear in.
+func SplitCommandSegments(line string) []CommandSegment {
+	commands := parseSimpleCommands(line)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	segments := make([]CommandSegment, len(commands))
+	for i, cmd := range commands {
+		text := line[cmd.words[0].start:cmd.words[len(cmd.words)-1].end]
+		labels, _ := labelContext(CommandContext{Command: text})
+		segments[i] = CommandSegment{
+			Command:     text,
+			BaseCommand: cmd.words[0].text,
+			Category:    labels[0].Category,
+			Subcategory: labels[0].Subcategory,
+			Labels:      labels,
+			Operator:    cmd.operator,
+		}
+	}
+	return segments
+}
+
+func parseSimpleCommands(line string) []simpleCommand {
+	tokens := tokenizeShell(line)
+
+	var commands []simpleCommand
+	var words []shellToken
+	operator := ""
+	depth := 0
+	flush := func() {
+		if command, ok := stripCommandWords(words); ok {
+			commands = append(commands, simpleCommand{words: command, operator: operator, subshell: depth > 0})
+			operator = ""
+		}
+		words = nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token.kind {
+		case shellRedirect:
+			if i+1 < len(tokens) && tokens[i+1].kind == shellWord {
+				i++
+			}
+		case shellOperator:
+			flush()
+			switch token.text {
+			// Parentheses don't join commands, keep the operator before them
+			case "(":
+				depth++
+			case ")":
+				if depth > 0 {
+					depth--
+				}
+			default:
+				if len(commands) > 0 {
+					operator = token.text
+				}
+			}
+		default:
+			words = append(words, token)
+		}
+	}
+	flush()
+
+	return commands
+}
+
+// stripCommandWords strips keywords, assignments and wrappers from the words
+// of a simple command
+func stripCommandWords(words []shellToken) ([]shellToken, bool) {
+	i := 0
+	for i < len(words) && shellKeywords[words[i].text] {
+		i++
+	}
+	if i == len(words) || shellNonCommands[words[i].text] {
+		return nil, false
+	}
+
+	for {
+		for i < len(words) && shellAssignmentRegex.MatchString(words[i].text) {
+			i++
+		}
+		if i == len(words) {
+			// Only assignments
+			return nil, false
+		}
+
+		valueOptions, isWrapper := commandWrappers[words[i].text]
+		if !isWrapper {
+			break
+		}
+		next := skipOptions(words, i+1, valueOptions)
+		for next < len(words) && shellAssignmentRegex.MatchString(words[next].text) {
+			next++
+		}
+		if next == len(words) {
+			// "sudo -i" or a bare "time" runs nothing else
+			break
+		}
+		i = next
+	}
+
+	return words[i:], true
+}
+
+// skipOptions returns the index of the first word after the options of a
+// wrapper
+func skipOptions(words []shellToken, i int, valueOptions []string) int {
+	for i < len(words) {
+		option := words[i].text
+		switch {
+		case option == "--":
+			return i + 1
+		case option == "-":
+			// env - is env -i
+			i++
+			continue
+		case !strings.HasPrefix(option, "-"):
+			return i
+		}
+		i++
+		for _, valueOption := range valueOptions {
+			if option == valueOption {
+				i++
+				break
+			}
+		}
+	}
+	return i
+}
+
+// primarySegment picks the segment that best describes a command line: the
+// first one that does more than move around or list files
+func primarySegment(segments []CommandSegment) CommandSegment {
+	return segments[primarySegmentIndex(segments)]
+}
+
+// primarySegmentIndex returns the index of the primarySegment
+func primarySegmentIndex(segments []CommandSegment) int {
+	for i, segment := range segments {
+		if segment.Category != CategoryNavigation && segment.Category != CategoryOther {
+			return i
+		}
+	}
+	return 0
+}
+
+// commandSegments returns the segments of an entry, or the entry as a single
+// segment when it has none
+func commandSegments(entry *HistoryEntry) []CommandSegment {
+	if len(entry.Segments) > 0 {
+		return entry.Segments
+	}
+	return []CommandSegment{{
+		Command:     entry.Command,
+		BaseCommand: entry.BaseCommand,
+		Category:    entry.Category,
+		Subcategory: entry.Subcategory,
+		Labels:      entry.Labels,
+	}}
+}
+
+func tokenizeShell(line string) []shellToken {
+	var tokens []shellToken
+	var heredocs []string // delimiters of here documents starting on the next line
+	stripTabs := false
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '\n':
+			tokens = append(tokens, shellToken{kind: shellOperator, text: ";", start: i, end: i + 1})
+			i++
+			if len(heredocs) > 0 {
+				i = skipHeredocs(line, i, heredocs, stripTabs)
+				heredocs = nil
+			}
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '\\' && i+1 < len(line) && line[i+1] == '\n':
+			i += 2
+		case c == '#':
+			for i < len(line) && line[i] != '\n' {
+				i++
+			}
+		case (c == '<' || c == '>') && i+1 < len(line) && line[i+1] == '(':
+			// Process substitution is a word
+			text, end := readShellWord(line, i)
+			tokens = append(tokens, shellToken{kind: shellWord, text: text, start: i, end: end})
+			i = end
+		default:
+			if redirect := matchPrefix(line[i:], shellRedirects); redirect != "" {
+				tokens = append(tokens, shellToken{kind: shellRedirect, text: redirect, start: i, end: i + len(redirect)})
+				i += len(redirect)
+				if redirect == "<<" || redirect == "<<-" {
+					for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+						i++
+					}
+					delimiter, end := readShellWord(line, i)
+					tokens = append(tokens, shellToken{kind: shellWord, text: delimiter, start: i, end: end})
+					heredocs = append(heredocs, delimiter)
+					stripTabs = redirect == "<<-"
+					i = end
+				}
+				continue
+			}
+			if operator := matchPrefix(line[i:], shellOperators); operator != "" {
+				tokens = append(tokens, shellToken{kind: shellOperator, text: operator, start: i, end: i + len(operator)})
+				i += len(operator)
+				continue
+			}
+
+			text, end := readShellWord(line, i)
+			if end < len(line) && (line[end] == '<' || line[end] == '>') && isFileDescriptor(line[i:end]) {
+				// The 2 of 2>&1 belongs to the redirection
+				i = end
+				continue
+			}
+			if end == i {
+				// Unexpected character, don't get stuck on it
+				end++
+			}
+			tokens = append(tokens, shellToken{kind: shellWord, text: text, start: i, end: end})
+			i = end
+		}
+	}
+
+	return tokens
+}
+
+// readShellWord reads the word starting at i and returns its unquoted text
+// and the offset just past it
+func readShellWord(line string, i int) (string, int) {
+	var text strings.Builder
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '&' || c == '|':
+			return text.String(), i
+		case c == '(':
+			if text.Len() == 0 {
+				return text.String(), i
+			}
+			// zsh glob qualifiers and array assignments: *(.) arr=(a b)
+			end := skipBalanced(line, i+1, ')')
+			text.WriteString(line[i:end])
+			i = end
+		case c == ')':
+			return text.String(), i
+		case (c == '<' || c == '>') && i+1 < len(line) && line[i+1] == '(':
+			end := skipBalanced(line, i+2, ')')
+			text.WriteString(line[i:end])
+			i = end
+		case c == '<' || c == '>':
+			return text.String(), i
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				text.WriteString(line[i+1:])
+				return text.String(), len(line)
+			}
+			text.WriteString(line[i+1 : i+1+end])
+			i += end + 2
+		case c == '$' && i+1 < len(line) && line[i+1] == '\'':
+			i = readANSIQuoted(line, i+2, &text)
+		case c == '$' && i+1 < len(line) && (line[i+1] == '(' || line[i+1] == '{'):
+			closer := byte(')')
+			if line[i+1] == '{' {
+				closer = '}'
+			}
+			end := skipBalanced(line, i+2, closer)
+			text.WriteString(line[i:end])
+			i = end
+		case c == '`':
+			end := skipBackquoted(line, i+1)
+			text.WriteString(line[i:end])
+			i = end
+		case c == '"':
+			i = readDoubleQuoted(line, i+1, &text)
+		case c == '\\':
+			if i+1 < len(line) {
+				if line[i+1] != '\n' {
+					text.WriteByte(line[i+1])
+				}
+				i += 2
+			} else {
+				i++
+			}
+		default:
+			text.WriteByte(c)
+			i++
+		}
+	}
+	return text.String(), i
+}
+
+// readDoubleQuoted appends the contents of a double-quoted string starting
+// at i and returns the offset past its closing quote
+func readDoubleQuoted(line string, i int, text *strings.Builder) int {
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == '"':
+			return i + 1
+		case c == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`\n", line[i+1]) >= 0:
+			if line[i+1] != '\n' {
+				text.WriteByte(line[i+1])
+			}
+			i += 2
+		case c == '$' && i+1 < len(line) && line[i+1] == '(':
+			end := skipBalanced(line, i+2, ')')
+			text.WriteString(line[i:end])
+			i = end
+		case c == '`':
+			end := skipBackquoted(line, i+1)
+			text.WriteString(line[i:end])
+			i = end
+		default:
+			text.WriteByte(c)
+			i++
+		}
+	}
+	return i
+}
+
+// readANSIQuoted appends the contents of a $'...' string starting at i and
+// returns the offset past its closing quote
+func readANSIQuoted(line string, i int, text *strings.Builder) int {
+	escapes := map[byte]byte{'n': '\n', 't': '\t', 'r': '\r', '\\': '\\', '\'': '\'', '"': '"'}
+	for i < len(line) {
+		c := line[i]
+		switch {
+		case c == '\'':
+			return i + 1
+		case c == '\\' && i+1 < len(line):
+			if escaped, ok := escapes[line[i+1]]; ok {
+				text.WriteByte(escaped)
+			} else {
+				text.WriteString(line[i : i+2])
+			}
+			i += 2
+		default:
+			text.WriteByte(c)
+			i++
+		}
+	}
+	return i
+}
+
+// skipBalanced returns the offset past the closer matching an opening
+// bracket just before i, skipping over quoted text
+func skipBalanced(line string, i int, closer byte) int {
+	opener := byte('(')
+	if closer == '}' {
+		opener = '{'
+	}
+	depth := 1
+	for i < len(line) {
+		c := line[i]
+		switch c {
+		case '\\':
+			i += 2
+			continue
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return len(line)
+			}
+			i += end + 2
+			continue
+		case '"':
+			var discard strings.Builder
+			i = readDoubleQuoted(line, i+1, &discard)
+			continue
+		case opener:
+			depth++
+		case closer:
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+		i++
+	}
+	return len(line)
+}
+
+// skipBackquoted returns the offset past the closing backquote
+func skipBackquoted(line string, i int) int {
+	for i < len(line) {
+		switch line[i] {
+		case '\\':
+			i += 2
+			continue
+		case '`':
+			return i + 1
+		}
+		i++
+	}
+	return len(line)
+}
+
+// skipHeredocs skips the bodies of here documents starting at i, one per
+// delimiter, and returns the offset of the line after the last one
+func skipHeredocs(line string, i int, delimiters []string, stripTabs bool) int {
+	for _, delimiter := range delimiters {
+		for i < len(line) {
+			end := strings.IndexByte(line[i:], '\n')
+			if end < 0 {
+				end = len(line) - i
+			}
+			body := line[i : i+end]
+			i += end
+			if i < len(line) {
+				i++
+			}
+			if stripTabs {
+				body = strings.TrimLeft(body, "\t")
+			}
+			if body == delimiter {
+				break
+			}
+		}
+	}
+	return i
+}
+
+func matchPrefix(s string, candidates []string) string {
+	for _, candidate := range candidates {
+		if strings.HasPrefix(s, candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func isFileDescriptor(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
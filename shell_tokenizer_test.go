@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandSegments(t *testing.T) {
+	tests := []struct {
+		command string
+		bases   []string
+		texts   []string
+	}{
+		{"git status", []string{"git"}, []string{"git status"}},
+		{"sudo docker ps", []string{"docker"}, []string{"docker ps"}},
+		{"sudo -u postgres psql -c 'select 1'", []string{"psql"}, []string{"psql -c 'select 1'"}},
+		{"FOO=1 BAR='a b' make test", []string{"make"}, []string{"make test"}},
+		{"time go test ./...", []string{"go"}, []string{"go test ./..."}},
+		{"nohup env -i PATH=/bin ./server &", []string{"./server"}, []string{"./server"}},
+		{"cd x && git pull", []string{"cd", "git"}, []string{"cd x", "git pull"}},
+		{"cat log | grep -v 'a|b' | wc -l", []string{"cat", "grep", "wc"}, []string{"cat log", "grep -v 'a|b'", "wc -l"}},
+		{`echo "a; b" ; ls`, []string{"echo", "ls"}, []string{`echo "a; b"`, "ls"}},
+		{"(cd src; make) || echo failed", []string{"cd", "make", "echo"}, []string{"cd src", "make", "echo failed"}},
+		{"kill $(pgrep -f 'a && b')", []string{"kill"}, []string{"kill $(pgrep -f 'a && b')"}},
+		{"make 2>&1 > build.log", []string{"make"}, []string{"make"}},
+		{"if grep -q x f; then echo yes; fi", []string{"grep", "echo"}, []string{"grep -q x f", "echo yes"}},
+		{"cat <<EOF | kubectl apply -f -\nkind: Pod\nrm -rf /\nEOF\nls", []string{"cat", "kubectl", "ls"}, []string{"cat", "kubectl apply -f -", "ls"}},
+		{`\ls -la # list`, []string{"ls"}, []string{`\ls -la`}},
+		{"sudo -i", []string{"sudo"}, []string{"sudo -i"}},
+		{"FOO=1", nil, nil},
+		{"", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			var bases, texts []string
+			for _, segment := range SplitCommandSegments(tt.command) {
+				bases = append(bases, segment.BaseCommand)
+				texts = append(texts, segment.Command)
+			}
+			if !reflect.DeepEqual(bases, tt.bases) {
+				t.Errorf("base commands = %q, want %q", bases, tt.bases)
+			}
+			if !reflect.DeepEqual(texts, tt.texts) {
+				t.Errorf("segments = %q, want %q", texts, tt.texts)
+			}
+		})
+	}
+}
+
+func TestSplitCommandSegments_Operators(t *testing.T) {
+	segments := SplitCommandSegments("make && ./run | tee out; echo done")
+	var operators []string
+	for _, segment := range segments {
+		operators = append(operators, segment.Operator)
+	}
+	want := []string{"", "&&", "|", ";"}
+	if !reflect.DeepEqual(operators, want) {
+		t.Errorf("operators = %q, want %q", operators, want)
+	}
+}
+
+func TestCategorizeCommand_Segments(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected CommandCategory
+	}{
+		{"sudo docker ps", CategoryContainers},
+		{"cd api && git pull", CategoryVCS},
+		{"FOO=1 psql -d app", CategoryDatabase},
+		{"ls -la | grep foo", CategorySearch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := CategorizeCommand(tt.command); got != tt.expected {
+				t.Errorf("CategorizeCommand(%q) = %v, want %v", tt.command, got, tt.expected)
+			}
+		})
+	}
+}
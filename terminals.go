@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxTerminalIdle is how long an inferred terminal can go without commands
+// before later commands are no longer attributed to it
+const maxTerminalIdle = 12 * time.Hour
+
+// terminalStream is the commands of one terminal, in order
+type terminalStream struct {
+	label   string
+	hinted  bool
+	entries []HistoryEntry
+
+	// Where the terminal is, from the last of its commands that moved it
+	directory string
+	project   string
+}
+
+// splitTerminals separates the interleaved commands of a host's terminals,
+// as written by zsh's share_history, into one stream per terminal. Commands
+// with a session hint, from Atuin or the hook log's tty, go to the stream of
+// their hint. The rest go to the recent stream they continue best: for a
+// command that moved to another directory, one in the same project or a
+// related directory, preferably running the same kind of commands. A command
+// that moved somewhere related to no recent stream opens a new one.
+//
+// Without a hint, a command's directory is inferred from the cd before it
+// in the interleaved history, whichever terminal typed that. So only a
+// directory that differs from the previous command's, set by the command
+// itself, says where a terminal is. Other commands are placed by the kind
+// of commands a stream runs, and otherwise follow the previous command.
+func splitTerminals(entries []HistoryEntry) []terminalStream {
+	var streams []*terminalStream
+	byHint := make(map[string]*terminalStream)
+	inferred := 0
+	var previous *terminalStream
+
+	for i, entry := range entries {
+		if entry.SessionHint != "" {
+			stream, ok := byHint[entry.SessionHint]
+			if !ok {
+				stream = &terminalStream{label: entry.SessionHint, hinted: true}
+				byHint[entry.SessionHint] = stream
+				streams = append(streams, stream)
+			}
+			stream.entries = append(stream.entries, entry)
+			previous = stream
+			continue
+		}
+
+		moved := i == 0 || entry.Directory != entries[i-1].Directory
+		var best *terminalStream
+		bestScore := 0
+		for _, stream := range streams {
+			if stream.hinted {
+				continue
+			}
+			last := stream.entries[len(stream.entries)-1]
+			if entry.Timestamp.Sub(last.Timestamp) > maxTerminalIdle {
+				continue
+			}
+			score := continuityScore(stream, entry, moved)
+			if score == 0 || score < bestScore {
+				continue
+			}
+			// Ties go to the stream of the previous command when the entry
+			// didn't move, and otherwise to later streams, being the more
+			// recently opened
+			if score == bestScore && !moved && best == previous {
+				continue
+			}
+			best, bestScore = stream, score
+		}
+		if best == nil {
+			inferred++
+			best = &terminalStream{label: fmt.Sprintf("terminal %d", inferred)}
+			streams = append(streams, best)
+		}
+		best.entries = append(best.entries, entry)
+		if moved || best.directory == "" {
+			best.directory, best.project = entry.Directory, entry.Project
+		}
+		previous = best
+	}
+
+	result := make([]terminalStream, len(streams))
+	for i, stream := range streams {
+		result[i] = *stream
+	}
+	return result
+}
+
+// continuityScore rates how well entry continues a stream. When the entry
+// moved to another directory, it is 0 unless that is in the stream's
+// project, or without projects, in or related to the stream's directory. A
+// cd is recorded in the directory it changed to, so the commands after it
+// continue it. An entry that didn't move continues any stream.
+func continuityScore(stream *terminalStream, entry HistoryEntry, moved bool) int {
+	score := 1
+	if moved {
+		switch {
+		case stream.project != "" && entry.Project != "":
+			if stream.project != entry.Project {
+				return 0
+			}
+			score = 3
+		case stream.directory == entry.Directory:
+			score = 3
+		case isRelatedDirectory(stream.directory, entry.Directory):
+			score = 2
+		default:
+			return 0
+		}
+	}
+
+	last := stream.entries[len(stream.entries)-1]
+	if last.Category == entry.Category {
+		score++
+	}
+	recent := stream.entries
+	if len(recent) > 5 {
+		recent = recent[len(recent)-5:]
+	}
+	for _, previous := range recent {
+		if previous.BaseCommand != "" && previous.BaseCommand == entry.BaseCommand {
+			score++
+			break
+		}
+	}
+	return score
+}
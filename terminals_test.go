@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSplitTerminals(t *testing.T) {
+	// Two terminals under share_history: one building the app, one
+	// editing nginx config, with their commands interleaved
+	entries := segmentationEntries(
+		"0 /src/app build",
+		"1 /etc/nginx editor",
+		"2 /src/app build",
+		"3 /src/app/web build",
+		"4 /etc/nginx editor",
+		"5 /src/app build",
+		"6 /etc/nginx system-admin",
+	)
+	streams := splitTerminals(entries)
+	if len(streams) != 2 {
+		t.Fatalf("Got %d terminals, want 2", len(streams))
+	}
+	if got := len(streams[0].entries); got != 4 {
+		t.Errorf("App terminal has %d commands, want 4", got)
+	}
+	for _, entry := range streams[1].entries {
+		if entry.Directory != "/etc/nginx" {
+			t.Errorf("Nginx terminal has a command in %s", entry.Directory)
+		}
+	}
+
+	// A directory carried over from the other terminal's cd is no evidence
+	entries = segmentationEntries(
+		"0 /src/app build",
+		"1 /etc/nginx editor",
+		"2 /etc/nginx build",
+		"3 /etc/nginx editor",
+	)
+	streams = splitTerminals(entries)
+	if len(streams) != 2 || len(streams[0].entries) != 2 || streams[0].entries[1].ID != 3 {
+		t.Errorf("Build after the other terminal's cd not in the app terminal: %+v", streams)
+	}
+
+	// Projects relate directories the tree doesn't, and separate siblings
+	entries = segmentationEntries(
+		"0 /code/app/web/src build",
+		"1 /code/lib vcs",
+		"2 /code/app/api/internal build",
+		"3 /code/tool vcs",
+	)
+	for i, project := range []string{"app", "lib", "app", "tool"} {
+		entries[i].Project = project
+	}
+	streams = splitTerminals(entries)
+	if len(streams) != 3 || len(streams[0].entries) != 2 {
+		t.Errorf("Terminals by project = %+v, want app, lib and tool", streams)
+	}
+
+	// Hints win over directories
+	entries = segmentationEntries(
+		"0 /src/app build",
+		"1 /etc/nginx editor",
+		"2 /src/app build",
+		"3 /src/app/web build",
+		"4 /etc/nginx editor",
+		"5 /src/app build",
+		"6 /etc/nginx system-admin",
+	)
+	entries[1].SessionHint = "s1"
+	entries[2].SessionHint = "s1"
+	streams = splitTerminals(entries)
+	if len(streams) != 3 || streams[1].label != "s1" || len(streams[1].entries) != 2 {
+		t.Errorf("Hinted commands not in their own terminal: %+v", streams)
+	}
+}
+
+func TestSegmentSessions_ByTerminal(t *testing.T) {
+	var entries []HistoryEntry
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		hint := "tty1"
+		if i%2 == 1 {
+			hint = "tty2"
+		}
+		entries = append(entries, HistoryEntry{
+			ID:          i + 1,
+			Timestamp:   base.Add(time.Duration(i) * time.Minute),
+			Command:     "make",
+			Directory:   "/src/app",
+			SessionHint: hint,
+		})
+	}
+
+	p := &Parser{config: &Config{}}
+	strategy := TimeoutStrategy{Timeout: 30 * time.Minute}
+	if got := p.SegmentSessions(entries, strategy, 1, false, nil, nil); len(got) != 1 {
+		t.Errorf("Without splitting terminals got %d sessions, want 1", len(got))
+	}
+
+	sessions := p.SegmentSessions(entries, strategy, 1, true, nil, nil)
+	if len(sessions) != 2 {
+		t.Fatalf("Got %d sessions, want one per terminal", len(sessions))
+	}
+	if sessions[0].Terminal != "tty1" || sessions[1].Terminal != "tty2" {
+		t.Errorf("Session terminals = %q, %q", sessions[0].Terminal, sessions[1].Terminal)
+	}
+	if !sessions[1].StartTime.Before(sessions[0].EndTime) {
+		t.Errorf("Sessions of parallel terminals don't overlap")
+	}
+	if entries[1].SessionID != sessions[1].ID {
+		t.Errorf("Entry session ID = %q, want %q", entries[1].SessionID, sessions[1].ID)
+	}
+}
+
+func TestReloadHistory_ByTerminal(t *testing.T) {
+	tmpDir := t.TempDir()
+	historyPath := filepath.Join(tmpDir, ".zsh_history")
+	history := ": 1700000000:0;cd /x/a/p\n: 1700000010:0;make\n: 1700000020:0;cd /y/b/q\n: 1700000030:0;cd /x/a/p\n"
+	if err := os.WriteFile(historyPath, []byte(history), 0644); err != nil {
+		t.Fatalf("Failed to write history file: %v", err)
+	}
+
+	config := &Config{HistoryFile: historyPath, HomeDir: tmpDir, SessionTimeout: 30 * time.Minute}
+	config.SessionHeuristics.MinCommandsPerSession = 1
+	config.SessionHeuristics.SplitConcurrentTerminals = true
+	sessionIndex, err := NewSessionIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("NewSessionIndex failed: %v", err)
+	}
+	s := &Server{
+		config:       config,
+		parser:       &Parser{config: config},
+		sessionIndex: sessionIndex,
+		events:       NewEventHub(),
+	}
+	if _, err := s.reloadHistory(); err != nil {
+		t.Fatalf("Initial reload failed: %v", err)
+	}
+
+	f, err := os.OpenFile(historyPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open history file: %v", err)
+	}
+	if _, err := f.WriteString(": 1700000040:0;vim x\n"); err != nil {
+		t.Fatalf("Failed to append to history file: %v", err)
+	}
+	f.Close()
+	if _, err := s.reloadHistory(); err != nil {
+		t.Fatalf("Incremental reload failed: %v", err)
+	}
+
+	// Every command is in one session, as a full regroup puts it
+	seen := make(map[string]bool)
+	for _, session := range s.sessions {
+		for _, cmd := range session.Commands {
+			if seen[cmd.StableID] {
+				t.Errorf("Command %q is in more than one session", cmd.Command)
+			}
+			seen[cmd.StableID] = true
+		}
+	}
+	if len(seen) != len(s.entries) {
+		t.Errorf("Sessions hold %d commands, want %d", len(seen), len(s.entries))
+	}
+	full := s.parser.GroupIntoSessions(append([]HistoryEntry{}, s.entries...), sessionIndex)
+	describe := func(sessions []Session) string {
+		var parts []string
+		for _, session := range sessions {
+			parts = append(parts, fmt.Sprintf("%s:%d", session.Terminal, len(session.Commands)))
+		}
+		return fmt.Sprint(parts)
+	}
+	if got, want := describe(s.sessions), describe(full); got != want {
+		t.Errorf("Sessions after append = %s, want %s", got, want)
+	}
+}
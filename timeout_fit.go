@@ -0,0 +1,327 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// gapBinsPerDecade is the resolution of the log-scale gap histogram,
+	// which runs from one second to gapHistogramDecades powers of ten
+	gapBinsPerDecade    = 10
+	gapHistogramDecades = 6
+
+	// minFitGaps is the fewest gaps a timeout is fitted to
+	minFitGaps = 100
+)
+
+// The valley between working and idle gaps is only looked for between these
+var (
+	minFittedTimeout = 2 * time.Minute
+	maxFittedTimeout = 4 * time.Hour
+)
+
+// timeOfDayPeriods are the parts of the day timeouts are fitted for
+var timeOfDayPeriods = []struct {
+	Name      string
+	StartHour int
+	EndHour   int
+}{
+	{"night", 0, 6},
+	{"morning", 6, 12},
+	{"afternoon", 12, 18},
+	{"evening", 18, 24},
+}
+
+// GapBin is one bucket of the log-scale histogram of gaps between commands
+type GapBin struct {
+	MinSeconds float64 `json:"min_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+	Count      int     `json:"count"`
+}
+
+// TimeoutFit is the session timeout suggested by a set of gaps. The gaps
+// within a session and those between sessions form two humps in the
+// histogram; the timeout is at the bottom of the valley between them.
+// TimeoutMinutes is 0 when there are too few gaps or no valley.
+type TimeoutFit struct {
+	Gaps           int      `json:"gaps"`
+	TimeoutMinutes int      `json:"timeout_minutes"`
+	Histogram      []GapBin `json:"histogram"`
+}
+
+// PeriodFit is the timeout fitted to the gaps starting in a part of the day
+type PeriodFit struct {
+	Name      string `json:"name"`
+	StartHour int    `json:"start_hour"`
+	EndHour   int    `json:"end_hour"`
+	TimeoutFit
+}
+
+// TimeoutAnalysis is the distribution of the gaps between commands with the
+// timeouts fitted to it, overall and per part of the day
+type TimeoutAnalysis struct {
+	CurrentTimeoutMinutes int         `json:"current_timeout_minutes"`
+	Overall               TimeoutFit  `json:"overall"`
+	Periods               []PeriodFit `json:"periods"`
+}
+
+// commandGap is the time between a command and the next one on its host
+type commandGap struct {
+	Start    time.Time
+	Duration time.Duration
+}
+
+// AnalyzeTimeout fits session timeouts to the gaps between the entries,
+// taken per host so that interleaved machines don't shorten them. host
+// limits the analysis to one host when not empty.
+func AnalyzeTimeout(entries []HistoryEntry, host string, current time.Duration) TimeoutAnalysis {
+	var gaps []commandGap
+	for _, hostEntries := range splitByHost(entries) {
+		if host != "" && len(hostEntries) > 0 && hostEntries[0].Host != host {
+			continue
+		}
+		for i := 1; i < len(hostEntries); i++ {
+			gaps = append(gaps, commandGap{
+				Start:    hostEntries[i-1].Timestamp,
+				Duration: hostEntries[i].Timestamp.Sub(hostEntries[i-1].Timestamp),
+			})
+		}
+	}
+
+	analysis := TimeoutAnalysis{
+		CurrentTimeoutMinutes: int(current / time.Minute),
+		Overall:               fitTimeout(gaps),
+	}
+	for _, period := range timeOfDayPeriods {
+		var periodGaps []commandGap
+		for _, gap := range gaps {
+			if hour := gap.Start.Hour(); hour >= period.StartHour && hour < period.EndHour {
+				periodGaps = append(periodGaps, gap)
+			}
+		}
+		analysis.Periods = append(analysis.Periods, PeriodFit{
+			Name:       period.Name,
+			StartHour:  period.StartHour,
+			EndHour:    period.EndHour,
+			TimeoutFit: fitTimeout(periodGaps),
+		})
+	}
+	return analysis
+}
+
+// fitTimeout builds the gap histogram and finds its valley
+func fitTimeout(gaps []commandGap) TimeoutFit {
+	bins := gapBinsPerDecade * gapHistogramDecades
+	fit := TimeoutFit{Gaps: len(gaps), Histogram: make([]GapBin, bins)}
+	for i := range fit.Histogram {
+		fit.Histogram[i].MinSeconds = binEdge(i)
+		fit.Histogram[i].MaxSeconds = binEdge(i + 1)
+	}
+	fit.Histogram[0].MinSeconds = 0
+	fit.Histogram[bins-1].MaxSeconds = math.Inf(1)
+	for _, gap := range gaps {
+		fit.Histogram[gapBin(gap.Duration)].Count++
+	}
+
+	if len(gaps) < minFitGaps {
+		return fit
+	}
+
+	// Smooth over five bins so that a single empty bin isn't a valley
+	smoothed := make([]float64, bins)
+	for i := range smoothed {
+		var sum float64
+		var n int
+		for j := i - 2; j <= i+2; j++ {
+			if j >= 0 && j < bins {
+				sum += float64(fit.Histogram[j].Count)
+				n++
+			}
+		}
+		smoothed[i] = sum / float64(n)
+	}
+
+	lo, hi := gapBin(minFittedTimeout), gapBin(maxFittedTimeout)
+	valley := lo
+	for i := lo; i <= hi; i++ {
+		if smoothed[i] < smoothed[valley] {
+			valley = i
+		}
+	}
+	// A flat valley floor is split down the middle
+	floor := valley
+	for floor+1 <= hi && smoothed[floor+1] == smoothed[valley] {
+		floor++
+	}
+	valley = (valley + floor) / 2
+
+	// Without a hump on both sides there is nothing to separate
+	if maxOf(smoothed[:valley]) <= smoothed[valley] || maxOf(smoothed[valley+1:]) <= smoothed[valley] {
+		return fit
+	}
+
+	center := math.Sqrt(binEdge(valley) * binEdge(valley+1))
+	fit.TimeoutMinutes = int(math.Round(center / 60))
+	if fit.TimeoutMinutes < 1 {
+		fit.TimeoutMinutes = 1
+	}
+	return fit
+}
+
+// gapBin is the histogram bin of a gap; gaps under a second fall in the
+// first and those past the end of the histogram in the last
+func gapBin(gap time.Duration) int {
+	seconds := gap.Seconds()
+	if seconds < 1 {
+		return 0
+	}
+	bin := int(math.Log10(seconds) * gapBinsPerDecade)
+	if last := gapBinsPerDecade*gapHistogramDecades - 1; bin > last {
+		return last
+	}
+	return bin
+}
+
+// binEdge is the lower edge of a bin in seconds
+func binEdge(bin int) float64 {
+	return math.Pow(10, float64(bin)/gapBinsPerDecade)
+}
+
+func maxOf(values []float64) float64 {
+	max := math.Inf(-1)
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// Apply returns h with its timeout and timeout periods replaced by the fitted
+// ones. Parts of the day without a fit use the overall timeout.
+func (a TimeoutAnalysis) Apply(h SessionHeuristics) (SessionHeuristics, error) {
+	if a.Overall.TimeoutMinutes == 0 {
+		return h, fmt.Errorf("no timeout could be fitted to %d gaps", a.Overall.Gaps)
+	}
+	h.TimeoutMinutes = a.Overall.TimeoutMinutes
+	h.TimeoutPeriods = nil
+	for _, period := range a.Periods {
+		if period.TimeoutMinutes > 0 && period.TimeoutMinutes != a.Overall.TimeoutMinutes {
+			h.TimeoutPeriods = append(h.TimeoutPeriods, TimeoutPeriod{
+				StartHour:      period.StartHour,
+				EndHour:        period.EndHour,
+				TimeoutMinutes: period.TimeoutMinutes,
+			})
+		}
+	}
+	return h, nil
+}
+
+// runFitTimeoutCommand implements "history_viewer fit-timeout", which prints
+// the gap histogram and the fitted timeouts, and with -apply saves them to
+// the config file
+func runFitTimeoutCommand(args []string, config *Config, out io.Writer) error {
+	flags := flag.NewFlagSet("fit-timeout", flag.ContinueOnError)
+	flags.SetOutput(out)
+	host := flags.String("host", "", "Only analyze commands from this host")
+	apply := flags.Bool("apply", false, "Save the fitted timeouts as session heuristics")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	parser := NewParser(config)
+	entries, err := parser.ParseHistory()
+	if err != nil {
+		return fmt.Errorf("failed to parse history: %w", err)
+	}
+	analysis := AnalyzeTimeout(entries, *host, config.SessionTimeout)
+	writeTimeoutAnalysis(out, analysis)
+
+	if !*apply {
+		return nil
+	}
+	heuristics, err := analysis.Apply(config.SessionHeuristics)
+	if err != nil {
+		return err
+	}
+	config.SessionHeuristics = heuristics
+	config.SessionTimeout = time.Duration(heuristics.TimeoutMinutes) * time.Minute
+	if err := SaveConfig(config); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Fprintf(out, "\nSaved session heuristics with a %d minute timeout\n", heuristics.TimeoutMinutes)
+	return nil
+}
+
+// writeTimeoutAnalysis prints the overall histogram as bars, with the fitted
+// timeout marked, followed by the timeouts per part of the day
+func writeTimeoutAnalysis(out io.Writer, analysis TimeoutAnalysis) {
+	fit := analysis.Overall
+	fmt.Fprintf(out, "Gaps between commands: %d\n\n", fit.Gaps)
+
+	first, last := -1, -1
+	maxCount := 0
+	for i, bin := range fit.Histogram {
+		if bin.Count > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+		if bin.Count > maxCount {
+			maxCount = bin.Count
+		}
+	}
+
+	suggested := -1
+	if fit.TimeoutMinutes > 0 {
+		suggested = gapBin(time.Duration(fit.TimeoutMinutes) * time.Minute)
+	}
+	for i := first; first >= 0 && i <= last; i++ {
+		bin := fit.Histogram[i]
+		bar := strings.Repeat("#", int(math.Ceil(float64(bin.Count)*50/float64(maxCount))))
+		marker := ""
+		if i == suggested {
+			marker = fmt.Sprintf("  <- %d min", fit.TimeoutMinutes)
+		}
+		fmt.Fprintf(out, "%8s %6d %s%s\n", formatGap(bin.MinSeconds), bin.Count, bar, marker)
+	}
+
+	fmt.Fprintf(out, "\nCurrent timeout: %d min\n", analysis.CurrentTimeoutMinutes)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "overall\t\t%d gaps\t%s\n", fit.Gaps, formatFit(fit))
+	for _, period := range analysis.Periods {
+		fmt.Fprintf(w, "%s\t%02d-%02d\t%d gaps\t%s\n", period.Name, period.StartHour, period.EndHour, period.Gaps, formatFit(period.TimeoutFit))
+	}
+	w.Flush()
+}
+
+func formatFit(fit TimeoutFit) string {
+	if fit.TimeoutMinutes == 0 {
+		if fit.Gaps < minFitGaps {
+			return "too few gaps"
+		}
+		return "no valley"
+	}
+	return fmt.Sprintf("suggested %d min", fit.TimeoutMinutes)
+}
+
+// formatGap prints a gap in seconds with the largest unit that fits
+func formatGap(seconds float64) string {
+	switch {
+	case seconds < 60:
+		return fmt.Sprintf("%.0fs", seconds)
+	case seconds < 3600:
+		return fmt.Sprintf("%.1fm", seconds/60)
+	case seconds < 86400:
+		return fmt.Sprintf("%.1fh", seconds/3600)
+	default:
+		return fmt.Sprintf("%.1fd", seconds/86400)
+	}
+}
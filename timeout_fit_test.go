@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// workdayEntries is days of three sessions, at 9:00, 12:00 and 15:00, of
+// forty commands a few seconds to two minutes apart
+func workdayEntries(days int) []HistoryEntry {
+	var entries []HistoryEntry
+	for day := 0; day < days; day++ {
+		for _, hour := range []int{9, 12, 15} {
+			t := time.Date(2024, 3, 1+day, hour, 0, 0, 0, time.UTC)
+			for i := 0; i < 40; i++ {
+				t = t.Add(time.Duration(i*7%110+5) * time.Second)
+				entries = append(entries, HistoryEntry{ID: len(entries) + 1, Timestamp: t, Command: "make"})
+			}
+		}
+	}
+	return entries
+}
+
+func TestAnalyzeTimeout(t *testing.T) {
+	analysis := AnalyzeTimeout(workdayEntries(30), "", 30*time.Minute)
+
+	if got, want := analysis.Overall.Gaps, 30*120-1; got != want {
+		t.Errorf("Gaps = %d, want %d", got, want)
+	}
+	if got := analysis.Overall.TimeoutMinutes; got < 5 || got > 60 {
+		t.Errorf("Fitted timeout = %d min, want one between the 2 minute and 3 hour gaps", got)
+	}
+	total := 0
+	for _, bin := range analysis.Overall.Histogram {
+		total += bin.Count
+	}
+	if total != analysis.Overall.Gaps {
+		t.Errorf("Histogram holds %d gaps, want %d", total, analysis.Overall.Gaps)
+	}
+
+	periods := make(map[string]PeriodFit)
+	for _, period := range analysis.Periods {
+		periods[period.Name] = period
+	}
+	if periods["morning"].TimeoutMinutes == 0 || periods["afternoon"].TimeoutMinutes == 0 {
+		t.Errorf("No fit for working hours: %+v", analysis.Periods)
+	}
+	if periods["night"].Gaps != 0 || periods["night"].TimeoutMinutes != 0 {
+		t.Errorf("Night fit = %d gaps, %d min, want none", periods["night"].Gaps, periods["night"].TimeoutMinutes)
+	}
+
+	// Too few gaps to fit
+	if got := AnalyzeTimeout(workdayEntries(1)[:50], "", 30*time.Minute).Overall.TimeoutMinutes; got != 0 {
+		t.Errorf("Timeout fitted to 49 gaps = %d min, want none", got)
+	}
+	if got := AnalyzeTimeout(workdayEntries(30), "laptop", 30*time.Minute).Overall.Gaps; got != 0 {
+		t.Errorf("Gaps on an unknown host = %d, want 0", got)
+	}
+}
+
+func TestTimeoutAnalysis_Apply(t *testing.T) {
+	analysis := TimeoutAnalysis{
+		Overall: TimeoutFit{Gaps: 500, TimeoutMinutes: 20},
+		Periods: []PeriodFit{
+			{Name: "night", StartHour: 0, EndHour: 6},
+			{Name: "morning", StartHour: 6, EndHour: 12, TimeoutFit: TimeoutFit{TimeoutMinutes: 20}},
+			{Name: "evening", StartHour: 18, EndHour: 24, TimeoutFit: TimeoutFit{TimeoutMinutes: 45}},
+		},
+	}
+	h, err := analysis.Apply(SessionHeuristics{TimeoutMinutes: 30, MinCommandsPerSession: 2})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if h.TimeoutMinutes != 20 || h.MinCommandsPerSession != 2 {
+		t.Errorf("Applied heuristics = %+v, want a 20 minute timeout and the rest kept", h)
+	}
+	if len(h.TimeoutPeriods) != 1 || h.TimeoutPeriods[0] != (TimeoutPeriod{StartHour: 18, EndHour: 24, TimeoutMinutes: 45}) {
+		t.Errorf("Timeout periods = %+v, want only the evening", h.TimeoutPeriods)
+	}
+
+	if _, err := (TimeoutAnalysis{Overall: TimeoutFit{Gaps: 3}}).Apply(h); err == nil {
+		t.Errorf("Apply without a fitted timeout succeeded")
+	}
+}
+
+func TestTimeOfDayTimeoutStrategy(t *testing.T) {
+	strategy := NewSegmentationStrategy(SessionHeuristics{
+		TimeoutPeriods: []TimeoutPeriod{{StartHour: 9, EndHour: 10, TimeoutMinutes: 5}},
+	}, 30*time.Minute)
+	if got, want := strategy.Name(), "time-of-day-timeout(30m0s, 09-10:5m0s)"; got != want {
+		t.Errorf("Strategy = %q, want %q", got, want)
+	}
+
+	// segmentationEntries start at 9:00, so the 5 minute timeout applies
+	// until 10:00 and the default one after
+	entries := segmentationEntries("0 /a vcs", "3 /a vcs", "10 /a vcs", "70 /a vcs", "90 /a vcs")
+	p := &Parser{config: &Config{}}
+	got := sessionSizes(p.SegmentSessions(entries, strategy, 1, false, nil, nil))
+	if len(got) != 3 || got[0] != 2 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("Session sizes = %v, want [2 1 2]", got)
+	}
+}
+
+func TestWriteTimeoutAnalysis(t *testing.T) {
+	var out bytes.Buffer
+	writeTimeoutAnalysis(&out, AnalyzeTimeout(workdayEntries(30), "", 30*time.Minute))
+	for _, want := range []string{"Current timeout: 30 min", "<- ", "suggested", "night", "too few gaps"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Output missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce groups the burst of writes a shell makes when saving history
+const watchDebounce = 250 * time.Millisecond
+
+// WatchHistory refreshes data whenever one of the history files changes. The
+// parent directories are watched rather than the files themselves so that the
+// watch survives zsh replacing a file when it trims history. Calling it again
+// stops the previous watch, so that files of a new config are watched instead.
+func (s *Server) WatchHistory() error {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	if s.watcher != nil {
+		s.watcher.Close()
+		s.watcher = nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	files := watchedFiles(s.config)
+	s.mu.RUnlock()
+
+	historyFiles := make(map[string]bool)
+	watchedDirs := make(map[string]bool)
+	for _, historyFile := range files {
+		historyFiles[historyFile] = true
+		// SQLite sources such as Atuin's write to a -wal file first
+		historyFiles[historyFile+"-wal"] = true
+
+		dir := filepath.Dir(historyFile)
+		if watchedDirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+		watchedDirs[dir] = true
+	}
+	s.watcher = watcher
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !historyFiles[filepath.Clean(event.Name)] {
+					continue
+				}
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+					debounce = time.After(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Warning: History watcher error: %v", err)
+			case <-debounce:
+				debounce = nil
+				if err := s.refreshData(); err != nil {
+					log.Printf("Warning: Failed to refresh after history change: %v", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchedFiles returns the files WatchHistory watches under config
+func watchedFiles(config *Config) []string {
+	var files []string
+	for _, source := range config.Sources() {
+		files = append(files, filepath.Clean(source.Path))
+	}
+	// The hook log changes when a command finishes, after its history line
+	// was written
+	if config.HookLog != "" {
+		files = append(files, filepath.Clean(config.HookLog))
+	}
+	return files
+}
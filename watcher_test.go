@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchHistory_Rearm(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldDir, newDir := filepath.Join(tmpDir, "old"), filepath.Join(tmpDir, "new")
+	for _, dir := range []string{oldDir, newDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldHistory, newHistory := filepath.Join(oldDir, ".zsh_history"), filepath.Join(newDir, ".zsh_history")
+
+	config := &Config{HistoryFile: oldHistory, HomeDir: tmpDir, SessionTimeout: 30 * time.Minute}
+	s := &Server{config: config, parser: NewParser(config), events: NewEventHub()}
+	if err := s.WatchHistory(); err != nil {
+		t.Fatalf("WatchHistory failed: %v", err)
+	}
+
+	// Switch to another history file, as PUT /api/config does
+	newConfig := *config
+	newConfig.HistoryFile = newHistory
+	s.mu.Lock()
+	s.config = &newConfig
+	s.parser = NewParser(s.config)
+	s.mu.Unlock()
+	if err := s.WatchHistory(); err != nil {
+		t.Fatalf("WatchHistory failed: %v", err)
+	}
+	defer s.watcher.Close()
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	if err := os.WriteFile(oldHistory, []byte(": 1700000000:0;ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-ch:
+		t.Fatalf("Got %s after writing the old history file", event.Type)
+	case <-time.After(2 * watchDebounce):
+	}
+
+	if err := os.WriteFile(newHistory, []byte(": 1700000000:0;ls\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event after writing the new history file")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+// zsh stores its history "metafied": bytes that clash with its internal
+// tokens (NUL and 0x83-0xa2) are written as the Meta byte 0x83 followed by
+// the original byte XORed with 32. UTF-8 text is full of such bytes.
+const zshMeta = 0x83
+
+// isZshMeta reports whether zsh metafies byte b on write
+func isZshMeta(b byte) bool {
+	return b == 0 || (b >= zshMeta && b <= 0xa2)
+}
+
+// unmetafy decodes a line read from .zsh_history back to raw bytes
+func unmetafy(s string) string {
+	i := 0
+	for i < len(s) && s[i] != zshMeta {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+
+	buf := make([]byte, 0, len(s))
+	buf = append(buf, s[:i]...)
+	for ; i < len(s); i++ {
+		if s[i] == zshMeta && i+1 < len(s) {
+			i++
+			buf = append(buf, s[i]^32)
+			continue
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+// metafy encodes s the way zsh writes it to .zsh_history
+func metafy(s string) string {
+	i := 0
+	for i < len(s) && !isZshMeta(s[i]) {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+
+	buf := make([]byte, 0, len(s)+8)
+	buf = append(buf, s[:i]...)
+	for ; i < len(s); i++ {
+		if isZshMeta(s[i]) {
+			buf = append(buf, zshMeta, s[i]^32)
+			continue
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
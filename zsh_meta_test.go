@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnmetafy(t *testing.T) {
+	// "à" is 0xc3 0xa0; zsh writes 0xa0 as Meta, 0xa0^32
+	metafied := "echo voil\xc3\x83\x80"
+	if got := unmetafy(metafied); got != "echo voilà" {
+		t.Errorf("unmetafy() = %q, want %q", got, "echo voilà")
+	}
+	if got := metafy("echo voilà"); got != metafied {
+		t.Errorf("metafy() = %q, want %q", got, metafied)
+	}
+}
+
+func TestZshHistory_RoundTrip(t *testing.T) {
+	original := ": 1700000000:0;git commit -m \"fix caf" + metafy("é 🚀") + "\"\n" +
+		": 1700000010:2;echo one\\\ntwo\n" +
+		": 1700000020:0;ls /tmp\n"
+
+	p := &Parser{config: &Config{HomeDir: "/home/test"}}
+	entries, err := p.parseZshHistory(strings.NewReader(original), p.newParseState())
+	if err != nil {
+		t.Fatalf("parseZshHistory() error = %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Command != "git commit -m \"fix café 🚀\"" {
+		t.Errorf("Command not decoded, got %q", entries[0].Command)
+	}
+
+	exported := NewExporter().ExportZshHistory(entries)
+	if exported != original {
+		t.Errorf("Round trip mismatch:\ngot  %q\nwant %q", exported, original)
+	}
+}